@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errInsufficientScope = errors.New("token does not have the required scope")
+	errNotAdmin          = errors.New("action requires an admin")
+	errSuspended         = errors.New("account is suspended")
+)
+
+func getBearerToken(r *http.Request) string {
+	return strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
+}
+
+// parseAccessToken validates a chirpy-access JWT and returns its claims along with the user id
+func parseAccessToken(token string) (*security.ChirpyClaims, int, error) {
+	if security.IsAPIToken(token) {
+		return nil, 0, errors.New("action requires an access token")
+	}
+
+	claims, err := security.GetTokenClaims(token)
+	if err != nil {
+		return nil, 0, errors.New("token is invalid")
+	}
+
+	issuer, err := claims.GetIssuer()
+	if err != nil {
+		return nil, 0, errors.New("token is invalid")
+	}
+
+	if issuer != "chirpy-access" {
+		return nil, 0, errors.New("action requires an access token")
+	}
+
+	id, err := claims.GetSubject()
+	if err != nil {
+		return nil, 0, errors.New("user id is invalid")
+	}
+
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, 0, errors.New("user id is invalid")
+	}
+
+	return claims, userID, nil
+}
+
+// authenticateAccessToken only accepts first-party chirpy-access JWTs, e.g. for managing personal access tokens
+func (cfg *apiConfig) authenticateAccessToken(r *http.Request) (int, error) {
+	claims, userID, err := parseAccessToken(getBearerToken(r))
+	if err != nil {
+		return 0, err
+	}
+
+	if claims.Scope != "" {
+		return 0, errInsufficientScope
+	}
+
+	return userID, cfg.checkSuspended(userID)
+}
+
+// authenticateViewer returns the user making a read request, or 0 when it is anonymous
+func (cfg *apiConfig) authenticateViewer(r *http.Request) (int, error) {
+	if r.Header.Get("Authorization") == "" {
+		return 0, nil
+	}
+
+	return cfg.authenticateUser(r, security.ScopeChirpsRead)
+}
+
+// checkSuspended stops suspended users from using tokens issued before the suspension
+func (cfg *apiConfig) checkSuspended(userID int) error {
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		return errors.New("user id is invalid")
+	}
+
+	if user.SuspendedAt != nil {
+		return errSuspended
+	}
+
+	return nil
+}
+
+// authenticateUser accepts a chirpy-access JWT, or a personal access token, that has been granted the given scope.
+// First-party JWTs carry every scope while those issued to OAuth clients are limited to what the user consented to.
+func (cfg *apiConfig) authenticateUser(r *http.Request, scope string) (int, error) {
+	token := getBearerToken(r)
+	if !security.IsAPIToken(token) {
+		claims, userID, err := parseAccessToken(token)
+		if err != nil {
+			return 0, err
+		}
+
+		if claims.Scope != "" && !security.HasScope(strings.Fields(claims.Scope), scope) {
+			return 0, errInsufficientScope
+		}
+
+		return userID, cfg.checkSuspended(userID)
+	}
+
+	t, err := cfg.db.GetAPITokenByHash(security.HashToken(token))
+	if err != nil {
+		return 0, errors.New("token is invalid")
+	}
+
+	if !security.HasScope(t.Scopes, scope) {
+		return 0, errInsufficientScope
+	}
+
+	return t.UserID, cfg.checkSuspended(t.UserID)
+}
+
+// authenticateAdmin accepts first-party access tokens of users listed in ADMIN_EMAILS
+func (cfg *apiConfig) authenticateAdmin(r *http.Request) (int, error) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		return 0, err
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		return 0, errors.New("user id is invalid")
+	}
+
+	for _, v := range cfg.adminEmails {
+		if v == database.NormalizeEmail(user.Email) {
+			return userID, nil
+		}
+	}
+
+	return 0, errNotAdmin
+}
+
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInsufficientScope) || errors.Is(err, errNotAdmin) || errors.Is(err, errSuspended) {
+		respondWithErr(w, err)
+		return
+	}
+	respondWithProblem(w, http.StatusUnauthorized, codeInvalidToken, err.Error())
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type authenticateUserTest struct {
+	name          string
+	token         string
+	scope         string
+	expectedUser  int
+	expectedError error
+}
+
+func TestAuthenticateUser(t *testing.T) {
+	cfg := newTestConfig(t)
+	walt := createTestUser(t, cfg, "walt@breakingbad.com", "Str0ngPassw0rd!")
+	accessToken, _ := cfg.createAccessToken(walt, "", "")
+	oauthToken, _ := cfg.createAccessToken(walt, security.ScopeChirpsRead, "client")
+
+	// Personal access tokens are created with a first-party access token and only returned once
+	r := httptest.NewRequest("POST", "/api/tokens", strings.NewReader(`{"name":"cli","scopes":["chirps:read"]}`))
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	cfg.handlerPostTokens(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d not equal to expected %d", w.Code, http.StatusCreated)
+	}
+
+	var created struct {
+		ID    int    `json:"id"`
+		Token string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	// Only the hash is stored
+	tokens, _ := cfg.db.GetAPITokens(walt.ID)
+	if len(tokens) != 1 || tokens[0].TokenHash != "" {
+		t.Errorf("Output %v should list the token without its hash", tokens)
+	}
+	if stored, err := cfg.db.GetAPITokenByHash(security.HashToken(created.Token)); err != nil || stored.ID != created.ID {
+		t.Errorf("Token %d should be found by its hash: %v", created.ID, err)
+	}
+
+	authenticateUserTests := []authenticateUserTest{
+		{name: "access token", token: accessToken, scope: security.ScopeChirpsWrite, expectedUser: walt.ID},
+		{name: "pat with scope", token: created.Token, scope: security.ScopeChirpsRead, expectedUser: walt.ID},
+		{name: "pat without scope", token: created.Token, scope: security.ScopeChirpsWrite, expectedError: errInsufficientScope},
+		{name: "oauth token with scope", token: oauthToken, scope: security.ScopeChirpsRead, expectedUser: walt.ID},
+		{name: "oauth token without scope", token: oauthToken, scope: security.ScopeUsersWrite, expectedError: errInsufficientScope},
+	}
+
+	for _, test := range authenticateUserTests {
+		r := httptest.NewRequest("GET", "/api/chirps", nil)
+		r.Header.Set("Authorization", "Bearer "+test.token)
+
+		userID, err := cfg.authenticateUser(r, test.scope)
+		if test.expectedError != nil {
+			if !errors.Is(err, test.expectedError) {
+				t.Errorf("%s: output %v not equal to expected %v", test.name, err, test.expectedError)
+			}
+
+			w := httptest.NewRecorder()
+			respondWithAuthError(w, err)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s: status %d not equal to expected %d", test.name, w.Code, http.StatusForbidden)
+			}
+			continue
+		}
+
+		if err != nil || userID != test.expectedUser {
+			t.Errorf("%s: output %d, %v not equal to expected %d", test.name, userID, err, test.expectedUser)
+		}
+	}
+
+	// Managing tokens needs a first-party access token, neither a PAT nor an OAuth token will do
+	for _, token := range []string{created.Token, oauthToken} {
+		r := httptest.NewRequest("GET", "/api/tokens", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		if _, err := cfg.authenticateAccessToken(r); err == nil {
+			t.Errorf("Token %.20s... should not be accepted to manage tokens", token)
+		}
+	}
+
+	// Revoked tokens are rejected
+	r = httptest.NewRequest("DELETE", "/api/tokens/"+strconv.Itoa(created.ID), nil)
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("tokenID", strconv.Itoa(created.ID))
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	w = httptest.NewRecorder()
+	cfg.handlerDeleteToken(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("revoke: status %d not equal to expected %d", w.Code, http.StatusOK)
+	}
+
+	r = httptest.NewRequest("GET", "/api/chirps", nil)
+	r.Header.Set("Authorization", "Bearer "+created.Token)
+	if _, err := cfg.authenticateUser(r, security.ScopeChirpsRead); err == nil || errors.Is(err, errInsufficientScope) {
+		t.Errorf("Revoked token should be invalid, got %v", err)
+	}
+}
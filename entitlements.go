@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"github.com/bobby-lin/chirpy/internal/utils"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const chirpQuotaWindow = time.Hour
+
+// createAccessToken embeds the user's current entitlements in a chirpy-access token
+func (cfg *apiConfig) createAccessToken(user database.User, scope, clientID string) (string, error) {
+	entitlements := security.EntitlementsFor(user.ChirpyRedActive(time.Now().UTC()))
+	return security.CreateAccessToken(user.ID, cfg.accessTokenExpiresInSeconds, scope, clientID, entitlements)
+}
+
+// entitlementsFor reads the entitlements from the access token of an authenticated request. Personal access
+// tokens, and access tokens issued before entitlements existed, get the entitlements of the user's current plan.
+func (cfg *apiConfig) entitlementsFor(r *http.Request, userID int) security.Entitlements {
+	token := getBearerToken(r)
+	if !security.IsAPIToken(token) {
+		claims, _, err := parseAccessToken(token)
+		if err == nil && claims.Entitlements != nil {
+			return *claims.Entitlements
+		}
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		return security.FreeEntitlements
+	}
+
+	return security.EntitlementsFor(user.ChirpyRedActive(time.Now().UTC()))
+}
+
+// allowChirp counts a chirp against the hourly quota of the plan and responds with 429 once it is used up
+func (cfg *apiConfig) allowChirp(w http.ResponseWriter, userID int, entitlements security.Entitlements) bool {
+	ok, retryAfter := cfg.chirpQuota.Allow(strconv.Itoa(userID), entitlements.ChirpsPerHour)
+	if ok {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	respondWithProblem(w, http.StatusTooManyRequests, codeQuotaExceeded,
+		fmt.Sprintf("you can post %d chirps per hour on the %s plan", entitlements.ChirpsPerHour, entitlements.Plan))
+	return false
+}
+
+// chirpPipeline validates chirps against the limits of the plan. Every endpoint that writes chirps uses it.
+func (cfg *apiConfig) chirpPipeline(entitlements security.Entitlements) *utils.ChirpPipeline {
+	return utils.NewChirpPipeline(
+		utils.StripControlChars(),
+		utils.RequireBody(),
+		utils.MaxLength(entitlements.MaxChirpLength),
+		utils.MaxMedia(entitlements.MaxMediaPerChirp),
+		utils.FilterProfanity(cfg.profanity),
+	)
+}
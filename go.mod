@@ -3,8 +3,16 @@ module github.com/bobby-lin/chirpy
 go 1.21.5
 
 require (
-	github.com/go-chi/chi/v5 v5.0.11 // indirect
-	github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	golang.org/x/crypto v0.17.0 // indirect
+	github.com/go-chi/chi/v5 v5.0.11
+	github.com/golang-jwt/jwt/v5 v5.2.0
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.17.0
 )
+
+require (
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	github.com/swaggo/files/v2 v2.0.2
+	golang.org/x/text v0.14.0
+)
+
+require golang.org/x/sys v0.15.0 // indirect
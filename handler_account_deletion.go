@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const accountDeletionJobInterval = time.Minute * 10
+
+// accountDeletionConfigFromEnv reads ACCOUNT_DELETION_GRACE_PERIOD (a duration, 14 days by default) and
+// ACCOUNT_DELETION_CHIRPS ("delete" by default or "anonymize")
+func accountDeletionConfigFromEnv() (time.Duration, database.DeletedChirpsPolicy, error) {
+	gracePeriod := time.Hour * 24 * 14
+	if v := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return 0, "", fmt.Errorf("invalid ACCOUNT_DELETION_GRACE_PERIOD: %s", v)
+		}
+		gracePeriod = d
+	}
+
+	policy := database.DeletedChirpsPolicy(os.Getenv("ACCOUNT_DELETION_CHIRPS"))
+	switch policy {
+	case "":
+		policy = database.DeleteChirps
+	case database.DeleteChirps, database.AnonymizeChirps:
+	default:
+		return 0, "", fmt.Errorf("invalid ACCOUNT_DELETION_CHIRPS: %s", policy)
+	}
+
+	return gracePeriod, policy, nil
+}
+
+// cancelAccountDeletion is called on login, which is how users change their mind during the grace period
+func (cfg *apiConfig) cancelAccountDeletion(user database.User) {
+	if user.DeletionScheduledAt == nil {
+		return
+	}
+
+	err := cfg.db.CancelUserDeletion(user.ID)
+	if err != nil {
+		log.Printf("Error cancelling account deletion: %s", err)
+	}
+}
+
+func (cfg *apiConfig) handlerDeleteUsers(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	type requestBody struct {
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to delete user")
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	attempt := cfg.reserveLoginAttempt(w, r, user.Email)
+	if attempt == nil {
+		return
+	}
+	defer attempt.release()
+
+	err = cfg.verifyPassword(user, reqBody.Password)
+	if err != nil {
+		attempt.fail()
+		respondWithProblem(w, http.StatusUnauthorized, codeInvalidCredentials, "password is incorrect")
+		return
+	}
+
+	user, err = cfg.db.ScheduleUserDeletion(userID, time.Now().Add(cfg.accountDeletionGracePeriod))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to delete user")
+		return
+	}
+
+	type responseBody struct {
+		DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	dat, _ := json.Marshal(responseBody{DeletionScheduledAt: *user.DeletionScheduledAt})
+	w.Write(dat)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/export"
+	"github.com/go-chi/chi/v5"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	dataExportExpiresIn = time.Hour * 24 * 7
+	// dataExportExpiryJobInterval is how often expired archives are removed from the export directory
+	dataExportExpiryJobInterval = time.Hour
+	// maxConcurrentExports keeps exports from starving request handlers
+	maxConcurrentExports = 2
+)
+
+// runDataExport builds the archive in the background and records the outcome on the export
+func (cfg *apiConfig) runDataExport(e database.DataExport) {
+	cfg.exportSlots <- struct{}{}
+	defer func() { <-cfg.exportSlots }()
+
+	path := dataExportPath(cfg.exportDir, e)
+	exportErr := cfg.writeDataExport(e.UserID, path)
+	if exportErr != nil {
+		log.Printf("Error exporting data for user %d: %s", e.UserID, exportErr)
+		os.Remove(path)
+	}
+
+	// The export is gone when the account was purged in the meantime, its archive must not be left behind
+	err := cfg.db.CompleteDataExport(e.ID, path, time.Now().Add(dataExportExpiresIn), exportErr)
+	if err != nil {
+		log.Printf("Error completing data export %d: %s", e.ID, err)
+		os.Remove(path)
+	}
+}
+
+func dataExportPath(exportDir string, e database.DataExport) string {
+	return filepath.Join(exportDir, fmt.Sprintf("chirpy-export-%d-%d.zip", e.UserID, e.ID))
+}
+
+// failInterruptedDataExports fails the exports a previous run didn't finish and removes their partial archives
+func failInterruptedDataExports(db *database.DB, exportDir string) error {
+	failed, err := db.FailPendingDataExports()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range failed {
+		err := os.Remove(dataExportPath(exportDir, e))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Error removing interrupted data export %d: %s", e.ID, err)
+		}
+	}
+	if len(failed) > 0 {
+		log.Printf("Failed %d data exports interrupted by a restart", len(failed))
+	}
+
+	return nil
+}
+
+func (cfg *apiConfig) writeDataExport(userID int, path string) error {
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	chirps, err := cfg.db.GetUserChirps(userID)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = export.WriteArchive(file, user, chirps)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (cfg *apiConfig) handlerPostDataExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	e, err := cfg.db.CreateDataExport(userID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	go cfg.runDataExport(e)
+
+	e.Path = "" // Don't expose server paths :)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/users/export/"+strconv.Itoa(e.ID))
+	w.WriteHeader(http.StatusAccepted)
+	dat, _ := json.Marshal(e)
+	w.Write(dat)
+}
+
+// handlerGetDataExport reports the status of the export until it is ready, then serves the archive
+func (cfg *apiConfig) handlerGetDataExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	paramValue := chi.URLParam(r, "exportID")
+	exportID, err := strconv.Atoi(paramValue)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid export id value: "+paramValue)
+		return
+	}
+
+	e, err := cfg.db.GetDataExport(exportID)
+	if err != nil || e.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "export does not exist")
+		return
+	}
+
+	if e.Status != database.ExportReady {
+		code := http.StatusAccepted
+		if e.Status == database.ExportFailed {
+			code = http.StatusInternalServerError
+		}
+
+		e.Path = ""
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		dat, _ := json.Marshal(e)
+		w.Write(dat)
+		return
+	}
+
+	if e.ExpiresAt != nil && e.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, http.StatusGone, "export has expired")
+		return
+	}
+
+	file, err := os.Open(e.Path)
+	if err != nil {
+		respondWithError(w, http.StatusGone, "export has expired")
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(e.Path)))
+	http.ServeContent(w, r, filepath.Base(e.Path), *e.CompletedAt, file)
+}
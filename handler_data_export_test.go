@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDataExportFlow(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.exportDir = t.TempDir()
+	cfg.exportSlots = make(chan struct{}, maxConcurrentExports)
+
+	walt := createTestUser(t, cfg, "walt@breakingbad.com", "Str0ngPassw0rd!")
+	cfg.db.CreateChirp("I am the one who knocks", walt.ID)
+	token, _ := cfg.createAccessToken(walt, "", "")
+
+	getExport := func(id int) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/users/export/"+strconv.Itoa(id), nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("exportID", strconv.Itoa(id))
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+		w := httptest.NewRecorder()
+		cfg.handlerGetDataExport(w, r)
+		return w
+	}
+
+	// An export interrupted by a restart fails and doesn't block a new one
+	interrupted, _ := cfg.db.CreateDataExport(walt.ID)
+	err := failInterruptedDataExports(cfg.db, cfg.exportDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := getExport(interrupted.ID); w.Code != http.StatusInternalServerError {
+		t.Errorf("interrupted: status %d not equal to expected %d", w.Code, http.StatusInternalServerError)
+	}
+
+	r := httptest.NewRequest("POST", "/api/users/export", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	cfg.handlerPostDataExport(w, r)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("create: status %d not equal to expected %d", w.Code, http.StatusAccepted)
+	}
+
+	var e database.DataExport
+	json.Unmarshal(w.Body.Bytes(), &e)
+	if e.Status != database.ExportPending {
+		t.Errorf("create: output %s not equal to expected %s", e.Status, database.ExportPending)
+	}
+
+	// The archive is built in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for getExport(e.ID).Code == http.StatusAccepted && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w = getExport(e.ID)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Errorf("ready: status %d with %q not equal to expected %d", w.Code, w.Header().Get("Content-Type"), http.StatusOK)
+	}
+
+	// Once expired the archive is removed and no longer served
+	cfg.db.ExpireDataExports(time.Now().Add(dataExportExpiresIn + time.Hour))
+	if w := getExport(e.ID); w.Code != http.StatusGone {
+		t.Errorf("expired: status %d not equal to expected %d", w.Code, http.StatusGone)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/mail"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+const (
+	verifyEmailTokenExpiresInSeconds   = 60 * 60 * 24 // 1 day
+	resetPasswordTokenExpiresInSeconds = 60 * 30      // 30 minutes
+	changeEmailTokenExpiresInSeconds   = 60 * 60 * 24 // 1 day
+)
+
+// newMailerFromEnv picks the mailer from MAILER: "smtp", "file" (MAIL_FILE) or "stdout" (default)
+func newMailerFromEnv() (mail.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@chirpy.local"
+	}
+
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		return &mail.SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case "file":
+		return mail.NewFileMailer(os.Getenv("MAIL_FILE"), from)
+	default:
+		return mail.NewWriterMailer(os.Stdout, from), nil
+	}
+}
+
+// sendMail doesn't block the request, so response times don't reveal whether an email was sent
+func (cfg *apiConfig) sendMail(msg mail.Message) {
+	go func() {
+		err := cfg.mailer.Send(msg)
+		if err != nil {
+			log.Printf("Error sending email to %s: %s", msg.To, err)
+		}
+	}()
+}
+
+func (cfg *apiConfig) sendVerificationEmail(user database.User) error {
+	token, err := security.CreateActionToken(user.ID, verifyEmailTokenExpiresInSeconds, "chirpy-verify-email", user.Email)
+	if err != nil {
+		return err
+	}
+
+	cfg.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Use the following token to verify your email address. It expires in 24 hours.\r\n\r\n"+
+			"POST %s/api/users/verify-email\r\n{\"token\": \"%s\"}\r\n", cfg.oauthIssuer, token),
+	})
+
+	return nil
+}
+
+// sendEmailChangeConfirmation asks the new address to confirm the change and lets the old one know about it
+func (cfg *apiConfig) sendEmailChangeConfirmation(user database.User) error {
+	token, err := security.CreateActionToken(user.ID, changeEmailTokenExpiresInSeconds, "chirpy-change-email", user.PendingEmail)
+	if err != nil {
+		return err
+	}
+
+	cfg.sendMail(mail.Message{
+		To:      user.PendingEmail,
+		Subject: "Confirm your new Chirpy email address",
+		Body: fmt.Sprintf("Use the following token to confirm this as the new email address of your Chirpy account. It expires in 24 hours.\r\n\r\n"+
+			"POST %s/api/users/email/confirm\r\n{\"token\": \"%s\"}\r\n", cfg.oauthIssuer, token),
+	})
+
+	cfg.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy email address is being changed",
+		Body: fmt.Sprintf("Someone asked to change the email address of your Chirpy account to %s. "+
+			"If it wasn't you, reset your password right away.\r\n", user.PendingEmail),
+	})
+
+	return nil
+}
+
+// parseActionToken validates a single-use token for the given purpose without marking it as used
+func parseActionToken(token, issuer string) (*security.ChirpyClaims, int, error) {
+	claims, err := security.GetTokenClaims(token)
+	if err != nil || claims.Issuer != issuer || claims.ID == "" {
+		return nil, 0, fmt.Errorf("token is invalid")
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, 0, fmt.Errorf("user id is invalid")
+	}
+
+	return claims, userID, nil
+}
+
+// consumeActionToken validates a single-use token for the given purpose and marks it as used
+func (cfg *apiConfig) consumeActionToken(token, issuer string) (*security.ChirpyClaims, int, error) {
+	claims, userID, err := parseActionToken(token, issuer)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = cfg.db.ConsumeActionToken(claims.ID, userID, claims.ExpiresAt.Time)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return claims, userID, nil
+}
+
+func (cfg *apiConfig) handlerRequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	if user.EmailVerified {
+		respondWithError(w, http.StatusConflict, "email is already verified")
+		return
+	}
+
+	err = cfg.sendVerificationEmail(user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to send verification email")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type requestBody struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err := decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to verify email")
+		return
+	}
+
+	claims, userID, err := cfg.consumeActionToken(reqBody.Token, "chirpy-verify-email")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = cfg.db.SetEmailVerified(userID, claims.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlerRequestPasswordReset always responds with 202 so it can't be used to find out which emails have an account
+func (cfg *apiConfig) handlerRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type requestBody struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err := decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to reset password")
+		return
+	}
+
+	user, err := cfg.db.GetUser(reqBody.Email)
+	if err != nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	token, err := security.CreateActionToken(user.ID, resetPasswordTokenExpiresInSeconds, "chirpy-reset-password", user.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to reset password")
+		return
+	}
+
+	cfg.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account. If it wasn't you, ignore this email.\r\n"+
+			"Otherwise use the following token within 30 minutes.\r\n\r\n"+
+			"POST %s/api/users/password-reset\r\n{\"token\": \"%s\", \"password\": \"<new password>\"}\r\n", cfg.oauthIssuer, token),
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerResetPassword(w http.ResponseWriter, r *http.Request) {
+	type requestBody struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err := decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to reset password")
+		return
+	}
+
+	claims, userID, err := parseActionToken(reqBody.Token, "chirpy-reset-password")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil || user.Email != claims.Email {
+		respondWithError(w, http.StatusBadRequest, "token is invalid")
+		return
+	}
+
+	// Validate before using up the token so the user can try another password
+	err = cfg.passwordPolicy.Validate(reqBody.Password, user.Email)
+	if err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+
+	err = cfg.db.ConsumeActionToken(claims.ID, userID, claims.ExpiresAt.Time)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	passwordHash, err := cfg.passwords.Hash(reqBody.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to reset password")
+		return
+	}
+
+	// Whoever asked for the reset may not be the only one with the old password, so their sessions end too
+	err = cfg.db.ChangePassword(userID, passwordHash)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "fail to reset password")
+		return
+	}
+
+	// Following the emailed link proves ownership of the address too
+	err = cfg.db.SetEmailVerified(userID, user.Email)
+	if err != nil {
+		log.Printf("Error verifying email after password reset: %s", err)
+	}
+
+	cfg.recordLoginSuccess(user.Email)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	type requestBody struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err := decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to confirm email")
+		return
+	}
+
+	claims, userID, err := parseActionToken(reqBody.Token, "chirpy-change-email")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// A newer change request replaces the pending email, which invalidates older tokens
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil || user.PendingEmail != claims.Email {
+		respondWithError(w, http.StatusBadRequest, "token is invalid")
+		return
+	}
+
+	err = cfg.db.ConsumeActionToken(claims.ID, userID, claims.ExpiresAt.Time)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err = cfg.db.UpdateEmail(userID, claims.Email)
+	if errors.Is(err, database.ErrEmailTaken) {
+		respondWithErr(w, err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "fail to confirm email")
+		return
+	}
+
+	user.Password = ""
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(user)
+	w.Write(dat)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// moderateUser applies a suspension or shadow-ban, or lifts it. The request body may contain a note for the audit.
+func (cfg *apiConfig) moderateUser(w http.ResponseWriter, r *http.Request, action string) {
+	moderatorID, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	paramValue := chi.URLParam(r, "userID")
+	userID, err := strconv.Atoi(paramValue)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id value: "+paramValue)
+		return
+	}
+
+	type requestBody struct {
+		Note string `json:"note"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err = decoder.Decode(&reqBody)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to moderate user")
+		return
+	}
+
+	user, err := cfg.db.ModerateUser(userID, moderatorID, action, strings.TrimSpace(reqBody.Note))
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(user)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerSuspendUser(w http.ResponseWriter, r *http.Request) {
+	cfg.moderateUser(w, r, database.ModerationSuspendUser)
+}
+
+func (cfg *apiConfig) handlerUnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	cfg.moderateUser(w, r, database.ModerationUnsuspendUser)
+}
+
+func (cfg *apiConfig) handlerShadowBanUser(w http.ResponseWriter, r *http.Request) {
+	cfg.moderateUser(w, r, database.ModerationShadowBan)
+}
+
+func (cfg *apiConfig) handlerUnshadowBanUser(w http.ResponseWriter, r *http.Request) {
+	cfg.moderateUser(w, r, database.ModerationUnshadowBan)
+}
+
+func (cfg *apiConfig) addRelationship(w http.ResponseWriter, r *http.Request, kind string) {
+	userID, err := cfg.authenticateUser(r, security.ScopeUsersWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	paramValue := chi.URLParam(r, "userID")
+	targetID, err := strconv.Atoi(paramValue)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id value: "+paramValue)
+		return
+	}
+
+	rel, err := cfg.db.AddRelationship(userID, targetID, kind)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	dat, _ := json.Marshal(rel)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) removeRelationship(w http.ResponseWriter, r *http.Request, kind string) {
+	userID, err := cfg.authenticateUser(r, security.ScopeUsersWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	paramValue := chi.URLParam(r, "userID")
+	targetID, err := strconv.Atoi(paramValue)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id value: "+paramValue)
+		return
+	}
+
+	err = cfg.db.RemoveRelationship(userID, targetID, kind)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) getRelationships(w http.ResponseWriter, r *http.Request, kind string) {
+	userID, err := cfg.authenticateUser(r, security.ScopeUsersRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	relationships, err := cfg.db.GetRelationships(userID, kind)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to get "+kind+" list")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(relationships)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerBlockUser(w http.ResponseWriter, r *http.Request) {
+	cfg.addRelationship(w, r, database.RelationshipBlock)
+}
+
+func (cfg *apiConfig) handlerUnblockUser(w http.ResponseWriter, r *http.Request) {
+	cfg.removeRelationship(w, r, database.RelationshipBlock)
+}
+
+func (cfg *apiConfig) handlerMuteUser(w http.ResponseWriter, r *http.Request) {
+	cfg.addRelationship(w, r, database.RelationshipMute)
+}
+
+func (cfg *apiConfig) handlerUnmuteUser(w http.ResponseWriter, r *http.Request) {
+	cfg.removeRelationship(w, r, database.RelationshipMute)
+}
+
+func (cfg *apiConfig) handlerGetBlocks(w http.ResponseWriter, r *http.Request) {
+	cfg.getRelationships(w, r, database.RelationshipBlock)
+}
+
+func (cfg *apiConfig) handlerGetMutes(w http.ResponseWriter, r *http.Request) {
+	cfg.getRelationships(w, r, database.RelationshipMute)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (cfg *apiConfig) createReport(w http.ResponseWriter, r *http.Request, targetType, paramName string) {
+	userID, err := cfg.authenticateUser(r, security.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	paramValue := chi.URLParam(r, paramName)
+	targetID, err := strconv.Atoi(paramValue)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid "+targetType+" id value: "+paramValue)
+		return
+	}
+
+	type requestBody struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to create report")
+		return
+	}
+
+	if !database.IsValidReportReason(reqBody.Reason) {
+		respondWithError(w, http.StatusBadRequest, "reason must be one of: "+strings.Join(database.ReportReasons, ", "))
+		return
+	}
+
+	report, err := cfg.db.CreateReport(userID, targetType, targetID, reqBody.Reason, strings.TrimSpace(reqBody.Details))
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	dat, _ := json.Marshal(report)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerReportChirp(w http.ResponseWriter, r *http.Request) {
+	cfg.createReport(w, r, database.ReportTargetChirp, "chirpID")
+}
+
+func (cfg *apiConfig) handlerReportUser(w http.ResponseWriter, r *http.Request) {
+	cfg.createReport(w, r, database.ReportTargetUser, "userID")
+}
+
+func reportIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	paramValue := chi.URLParam(r, "reportID")
+	reportID, err := strconv.Atoi(paramValue)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid report id value: "+paramValue)
+		return 0, false
+	}
+	return reportID, true
+}
+
+// handlerGetReports lists the moderation queue oldest first. Supports ?status=, ?target_type= and ?limit=.
+func (cfg *apiConfig) handlerGetReports(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	limit := defaultDeliveriesLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			respondWithError(w, http.StatusBadRequest, "invalid limit value: "+v)
+			return
+		}
+	}
+
+	reports, err := cfg.db.GetReports(r.URL.Query().Get("status"), r.URL.Query().Get("target_type"), limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to get reports")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(reports)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerGetReport(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	reportID, ok := reportIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := cfg.db.GetReport(reportID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(report)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerClaimReport(w http.ResponseWriter, r *http.Request) {
+	moderatorID, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	reportID, ok := reportIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := cfg.db.ClaimReport(reportID, moderatorID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(report)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerResolveReport(w http.ResponseWriter, r *http.Request) {
+	moderatorID, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	reportID, ok := reportIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	type requestBody struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to resolve report")
+		return
+	}
+
+	switch reqBody.Action {
+	case database.ModerationHideChirp, database.ModerationSuspendUser, database.ModerationShadowBan, database.ModerationDismiss:
+	default:
+		respondWithError(w, http.StatusBadRequest, "invalid action: "+reqBody.Action)
+		return
+	}
+
+	report, err := cfg.db.ResolveReport(reportID, moderatorID, reqBody.Action, strings.TrimSpace(reqBody.Note))
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(report)
+	w.Write(dat)
+}
+
+// handlerGetModerationAudit lists moderator decisions newest first. Supports ?report_id= and ?limit=.
+func (cfg *apiConfig) handlerGetModerationAudit(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	reportID := 0
+	if v := r.URL.Query().Get("report_id"); v != "" {
+		reportID, err = strconv.Atoi(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid report id value: "+v)
+			return
+		}
+	}
+
+	limit := defaultDeliveriesLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			respondWithError(w, http.StatusBadRequest, "invalid limit value: "+v)
+			return
+		}
+	}
+
+	entries, err := cfg.db.GetModerationAudit(reportID, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to get moderation audit")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(entries)
+	w.Write(dat)
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const oauthCodeExpiresInSeconds = 60 * 10 // 10 minutes
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Authorize {{.ClientName}}</title>
+</head>
+<body>
+    <h1>{{.ClientName}} wants to access your Chirpy account</h1>
+    <p>It is requesting permission to:</p>
+    <ul>
+        {{range .Scopes}}<li>{{.}}</li>{{end}}
+    </ul>
+    {{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+    <form method="post" action="/api/oauth/authorize">
+        <input type="hidden" name="response_type" value="code">
+        <input type="hidden" name="client_id" value="{{.ClientID}}">
+        <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+        <input type="hidden" name="scope" value="{{.Scope}}">
+        <input type="hidden" name="state" value="{{.State}}">
+        <input type="hidden" name="nonce" value="{{.Nonce}}">
+        <input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+        <input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+        <label>Email <input type="email" name="email"></label>
+        <label>Password <input type="password" name="password"></label>
+        <label>Two-factor code, if enabled <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code"></label>
+        <label>Or a recovery code <input type="text" name="recovery_code" autocomplete="off"></label>
+        <button type="submit" name="decision" value="allow">Allow</button>
+        <button type="submit" name="decision" value="deny">Deny</button>
+    </form>
+</body>
+</html>
+`))
+
+type authorizeRequest struct {
+	ClientID            string
+	ClientName          string
+	RedirectURI         string
+	Scope               string
+	Scopes              []string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Error               string
+}
+
+// parseAuthorizeRequest validates the authorization request. When the client or redirect uri is invalid
+// the user must not be redirected, so redirectURL is empty; otherwise it carries the OAuth error code.
+func (cfg *apiConfig) parseAuthorizeRequest(params url.Values) (authorizeRequest, string, string) {
+	req := authorizeRequest{
+		ClientID:            params.Get("client_id"),
+		RedirectURI:         params.Get("redirect_uri"),
+		Scope:               params.Get("scope"),
+		State:               params.Get("state"),
+		Nonce:               params.Get("nonce"),
+		CodeChallenge:       params.Get("code_challenge"),
+		CodeChallengeMethod: params.Get("code_challenge_method"),
+	}
+
+	client, err := cfg.db.GetOAuthClient(req.ClientID)
+	if err != nil {
+		return req, "", "unknown client"
+	}
+
+	// Clients registered before redirect URIs were restricted may still have unsafe ones
+	if !client.HasRedirectURI(req.RedirectURI) || !validRedirectURI(req.RedirectURI) {
+		return req, "", "redirect_uri is not registered for this client"
+	}
+
+	req.ClientName = client.Name
+	req.Scopes = strings.Fields(req.Scope)
+
+	if params.Get("response_type") != "code" {
+		return req, req.RedirectURI, "unsupported_response_type"
+	}
+
+	if !security.IsValidOAuthScope(req.Scope) {
+		return req, req.RedirectURI, "invalid_scope"
+	}
+
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return req, req.RedirectURI, "invalid_request"
+	}
+
+	return req, "", ""
+}
+
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		if len(v) > 0 && v[0] != "" {
+			q.Set(k, v[0])
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, errCode, description string) {
+	type responseBody struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+
+	dat, _ := json.Marshal(responseBody{
+		Error:            errCode,
+		ErrorDescription: description,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(dat)
+}
+
+func renderConsent(w http.ResponseWriter, code int, req authorizeRequest) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	err := consentTemplate.Execute(w, req)
+	if err != nil {
+		log.Printf("Error rendering consent page: %s", err)
+	}
+}
+
+// validRedirectURI accepts https URIs, and http only for apps on the user's own machine (RFC 8252), so codes
+// are never sent in the clear or to schemes like javascript:
+func validRedirectURI(v string) bool {
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+	return false
+}
+
+func (cfg *apiConfig) handlerPostOAuthClients(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	type requestBody struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Public       bool     `json:"public"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to register client")
+		return
+	}
+
+	if reqBody.Name == "" || len(reqBody.RedirectURIs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "name and redirect_uris are required")
+		return
+	}
+
+	for _, v := range reqBody.RedirectURIs {
+		if !validRedirectURI(v) {
+			respondWithError(w, http.StatusBadRequest, "invalid redirect uri: "+v)
+			return
+		}
+	}
+
+	clientID, err := security.GenerateRandomString(16)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to register client")
+		return
+	}
+
+	client := database.OAuthClient{
+		ID:           clientID,
+		Name:         reqBody.Name,
+		RedirectURIs: reqBody.RedirectURIs,
+		OwnerID:      userID,
+	}
+
+	clientSecret := ""
+	if !reqBody.Public {
+		clientSecret, err = security.GenerateRandomString(32)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "fail to register client")
+			return
+		}
+		client.SecretHash = security.HashToken(clientSecret)
+	}
+
+	client, err = cfg.db.CreateOAuthClient(client)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to register client")
+		return
+	}
+
+	// The client secret is only ever returned here
+	type responseBody struct {
+		ClientID     string   `json:"client_id"`
+		ClientSecret string   `json:"client_secret,omitempty"`
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+	}
+
+	resp := responseBody{
+		ClientID:     client.ID,
+		ClientSecret: clientSecret,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	dat, _ := json.Marshal(resp)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerGetAuthorize(w http.ResponseWriter, r *http.Request) {
+	req, redirectURI, errCode := cfg.parseAuthorizeRequest(r.URL.Query())
+	if errCode != "" {
+		if redirectURI == "" {
+			respondWithError(w, http.StatusBadRequest, errCode)
+			return
+		}
+		redirectWithParams(w, r, redirectURI, url.Values{"error": {errCode}, "state": {req.State}})
+		return
+	}
+
+	renderConsent(w, http.StatusOK, req)
+}
+
+func (cfg *apiConfig) handlerPostAuthorize(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid form")
+		return
+	}
+
+	req, redirectURI, errCode := cfg.parseAuthorizeRequest(r.PostForm)
+	if errCode != "" {
+		if redirectURI == "" {
+			respondWithError(w, http.StatusBadRequest, errCode)
+			return
+		}
+		redirectWithParams(w, r, redirectURI, url.Values{"error": {errCode}, "state": {req.State}})
+		return
+	}
+
+	if r.PostForm.Get("decision") != "allow" {
+		redirectWithParams(w, r, req.RedirectURI, url.Values{"error": {"access_denied"}, "state": {req.State}})
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	attempt := cfg.reserveLoginAttempt(w, r, email)
+	if attempt == nil {
+		return
+	}
+	defer attempt.release()
+
+	user, err := cfg.db.GetUser(email)
+	if err == nil {
+		err = cfg.verifyPassword(user, r.PostForm.Get("password"))
+	} else {
+		// Unknown emails take as long as wrong passwords so accounts can't be enumerated
+		cfg.passwords.Verify(r.PostForm.Get("password"), cfg.passwords.DummyHash())
+	}
+	if err != nil {
+		attempt.fail()
+		req.Error = "Invalid email or password"
+		renderConsent(w, http.StatusUnauthorized, req)
+		return
+	}
+
+	if user.SuspendedAt != nil {
+		req.Error = "Your account is suspended"
+		renderConsent(w, http.StatusForbidden, req)
+		return
+	}
+
+	// Consent issues tokens just like a login, so the second factor is required here too
+	tf, err := cfg.db.GetTwoFactor(user.ID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		respondWithErr(w, err)
+		return
+	}
+	if err == nil && tf.Enabled {
+		err = cfg.verifySecondFactor(tf, r.PostForm.Get("code"), r.PostForm.Get("recovery_code"))
+		if err != nil {
+			attempt.fail()
+			req.Error = "Invalid two-factor code"
+			renderConsent(w, http.StatusUnauthorized, req)
+			return
+		}
+	}
+
+	cfg.recordLoginSuccess(email)
+	cfg.cancelAccountDeletion(user)
+
+	code, err := security.GenerateRandomString(32)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to authorize")
+		return
+	}
+
+	err = cfg.db.CreateOAuthCode(security.HashToken(code), database.OAuthCode{
+		ClientID:      req.ClientID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(oauthCodeExpiresInSeconds * time.Second),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to authorize")
+		return
+	}
+
+	redirectWithParams(w, r, req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})
+}
+
+// authenticateOAuthClient supports client_secret_basic and client_secret_post. Public clients only send their id.
+func (cfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OAuthClient, bool) {
+	clientID, clientSecret, hasBasic := r.BasicAuth()
+	if !hasBasic {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := cfg.db.GetOAuthClient(clientID)
+	if err != nil {
+		return database.OAuthClient{}, false
+	}
+
+	if client.IsConfidential() && !security.CompareTokenHash(clientSecret, client.SecretHash) {
+		return database.OAuthClient{}, false
+	}
+
+	return client, true
+}
+
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form")
+		return
+	}
+
+	client, ok := cfg.authenticateOAuthClient(r)
+	if !ok {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	var userID int
+	var scope, nonce string
+	// refreshIssuedAt is set for the refresh_token grant, whose token may have been revoked by a password change
+	var refreshIssuedAt *time.Time
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := cfg.db.ConsumeOAuthCode(security.HashToken(r.PostForm.Get("code")))
+		if err != nil {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		}
+
+		if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "code was not issued to this client")
+			return
+		}
+
+		if !security.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge, "S256") {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match")
+			return
+		}
+
+		userID, scope, nonce = code.UserID, code.Scope, code.Nonce
+	case "refresh_token":
+		token := r.PostForm.Get("refresh_token")
+		claims, err := security.GetTokenClaims(token)
+		if err != nil || claims.Issuer != "chirpy-refresh" || claims.ClientID != client.ID {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "refresh token is invalid")
+			return
+		}
+
+		isRevoked, err := cfg.db.CheckTokenRevocation(token)
+		if err != nil || isRevoked {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "refresh token is invalid")
+			return
+		}
+
+		userID, err = strconv.Atoi(claims.Subject)
+		if err != nil {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "refresh token is invalid")
+			return
+		}
+		scope = claims.Scope
+		refreshIssuedAt = &claims.IssuedAt.Time
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	type responseBody struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		Scope        string `json:"scope"`
+		IDToken      string `json:"id_token,omitempty"`
+	}
+
+	resp := responseBody{
+		TokenType: "Bearer",
+		ExpiresIn: cfg.accessTokenExpiresInSeconds,
+		Scope:     scope,
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "user does not exist")
+		return
+	}
+
+	if user.SuspendedAt != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", errSuspended.Error())
+		return
+	}
+
+	if refreshIssuedAt != nil && user.TokenRevoked(*refreshIssuedAt) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "refresh token is invalid")
+		return
+	}
+
+	resp.AccessToken, err = cfg.createAccessToken(user, scope, client.ID)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "fail to generate accessToken")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") == "authorization_code" {
+		resp.RefreshToken, err = security.CreateScopedJwtToken(userID, cfg.refreshTokenExpiresInSeconds, "chirpy-refresh", scope, client.ID)
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "fail to generate refreshToken")
+			return
+		}
+	}
+
+	scopes := strings.Fields(scope)
+	if security.HasScope(scopes, security.ScopeOpenID) {
+		email := ""
+		if security.HasScope(scopes, security.ScopeEmail) {
+			email = user.Email
+		}
+
+		resp.IDToken, err = security.CreateIDToken(cfg.oidcKey, cfg.oauthIssuer, userID, client.ID, nonce, email, cfg.accessTokenExpiresInSeconds)
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "fail to generate idToken")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(resp)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerOAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form")
+		return
+	}
+
+	client, ok := cfg.authenticateOAuthClient(r)
+	if !ok || !client.IsConfidential() {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	type responseBody struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Sub       string `json:"sub,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		Exp       int64  `json:"exp,omitempty"`
+		Iat       int64  `json:"iat,omitempty"`
+	}
+
+	resp := responseBody{}
+	token := r.PostForm.Get("token")
+
+	if security.IsAPIToken(token) {
+		t, err := cfg.db.GetAPITokenByHash(security.HashToken(token))
+		if err == nil {
+			resp = responseBody{
+				Active:    true,
+				Scope:     strings.Join(t.Scopes, " "),
+				Sub:       strconv.Itoa(t.UserID),
+				TokenType: "personal_access_token",
+				Iat:       t.CreatedAt.Unix(),
+			}
+		}
+	} else if claims, err := security.GetTokenClaims(token); err == nil {
+		isRevoked := false
+		if claims.Issuer == "chirpy-refresh" {
+			isRevoked, err = cfg.db.CheckTokenRevocation(token)
+			if err == nil && !isRevoked {
+				isRevoked = cfg.refreshTokenRevokedByUser(claims)
+			}
+		}
+
+		if err == nil && !isRevoked {
+			resp = responseBody{
+				Active:    true,
+				Scope:     claims.Scope,
+				ClientID:  claims.ClientID,
+				Sub:       claims.Subject,
+				TokenType: claims.Issuer,
+				Exp:       claims.ExpiresAt.Unix(),
+				Iat:       claims.IssuedAt.Unix(),
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(resp)
+	w.Write(dat)
+}
+
+// refreshTokenRevokedByUser reports whether the user of a refresh token is gone or changed their password since
+func (cfg *apiConfig) refreshTokenRevokedByUser(claims *security.ChirpyClaims) bool {
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return true
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		return true
+	}
+
+	return user.TokenRevoked(claims.IssuedAt.Time)
+}
+
+func (cfg *apiConfig) handlerUserInfo(w http.ResponseWriter, r *http.Request) {
+	claims, userID, err := parseAccessToken(getBearerToken(r))
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	scopes := strings.Fields(claims.Scope)
+	if !security.HasScope(scopes, security.ScopeOpenID) {
+		respondWithAuthError(w, errInsufficientScope)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	type responseBody struct {
+		Sub   string `json:"sub"`
+		Email string `json:"email,omitempty"`
+	}
+
+	resp := responseBody{
+		Sub: strconv.Itoa(user.ID),
+	}
+	if security.HasScope(scopes, security.ScopeEmail) {
+		resp.Email = user.Email
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(resp)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(security.JWKS(cfg.oidcKey))
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerOpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	issuer := cfg.oauthIssuer
+
+	doc := map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/api/oauth/authorize",
+		"token_endpoint":                        issuer + "/api/oauth/token",
+		"introspection_endpoint":                issuer + "/api/oauth/introspect",
+		"userinfo_endpoint":                     issuer + "/api/oauth/userinfo",
+		"jwks_uri":                              issuer + "/api/oauth/jwks",
+		"scopes_supported":                      []string{security.ScopeOpenID, security.ScopeEmail, security.ScopeChirpsRead, security.ScopeChirpsWrite, security.ScopeUsersRead, security.ScopeUsersWrite},
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"claims_supported":                      []string{"sub", "email", "nonce"},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(doc)
+	w.Write(dat)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostAuthorizeTwoFactor(t *testing.T) {
+	cfg := newTestConfig(t)
+	walt := createTestUser(t, cfg, "walt@breakingbad.com", "Str0ngPassw0rd!")
+
+	_, err := cfg.db.CreateOAuthClient(database.OAuthClient{ID: "client", Name: "Client", RedirectURIs: []string{"https://client.example/cb"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, _ := security.GenerateTOTPSecret()
+	cfg.db.SaveTwoFactorSecret(walt.ID, secret)
+	cfg.db.EnableTwoFactor(walt.ID, 0, []string{security.HashToken("recovery")})
+	code, _ := security.TOTPCode(secret, time.Now().Unix()/30)
+	cfg.db.ScheduleUserDeletion(walt.ID, time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name         string
+		code         string
+		recoveryCode string
+		expected     int
+	}{
+		{name: "password only", expected: http.StatusUnauthorized},
+		{name: "wrong code", code: "000000", expected: http.StatusUnauthorized},
+		{name: "code", code: code, expected: http.StatusFound},
+		{name: "code reused", code: code, expected: http.StatusUnauthorized},
+		{name: "recovery code", recoveryCode: "recovery", expected: http.StatusFound},
+	}
+
+	for _, test := range tests {
+		form := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {"client"},
+			"redirect_uri":          {"https://client.example/cb"},
+			"scope":                 {"openid"},
+			"code_challenge":        {"challenge"},
+			"code_challenge_method": {"S256"},
+			"decision":              {"allow"},
+			"email":                 {walt.Email},
+			"password":              {"Str0ngPassw0rd!"},
+			"code":                  {test.code},
+			"recovery_code":         {test.recoveryCode},
+		}
+
+		r := httptest.NewRequest("POST", "/api/oauth/authorize", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		cfg.handlerPostAuthorize(w, r)
+
+		if w.Code != test.expected {
+			t.Errorf("%s: status %d not equal to expected %d", test.name, w.Code, test.expected)
+		}
+		if test.expected == http.StatusFound && !strings.Contains(w.Header().Get("Location"), "code=") {
+			t.Errorf("%s: redirect %q has no code", test.name, w.Header().Get("Location"))
+		}
+	}
+
+	// Consenting is a login, so it cancels a scheduled deletion like one
+	walt, _ = cfg.db.GetUserByID(walt.ID)
+	if walt.DeletionScheduledAt != nil {
+		t.Errorf("Deletion should have been cancelled: %v", walt.DeletionScheduledAt)
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri      string
+		expected bool
+	}{
+		{uri: "https://client.example/cb", expected: true},
+		{uri: "https://client.example/cb?source=chirpy", expected: true},
+		{uri: "http://localhost:3000/cb", expected: true},
+		{uri: "http://127.0.0.1:3000/cb", expected: true},
+		{uri: "http://[::1]:3000/cb", expected: true},
+		{uri: "http://client.example/cb", expected: false},
+		{uri: "https://client.example/cb#token", expected: false},
+		{uri: "javascript:alert(1)", expected: false},
+		{uri: "data:text/html,<script>alert(1)</script>", expected: false},
+		{uri: "com.client.app:/cb", expected: false},
+		{uri: "/cb", expected: false},
+	}
+
+	for _, test := range tests {
+		if output := validRedirectURI(test.uri); output != test.expected {
+			t.Errorf("%s: output %v not equal to expected %v", test.uri, output, test.expected)
+		}
+	}
+}
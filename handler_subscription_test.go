@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookDeduplication(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+
+	legacy := `{"event":"user.upgraded","data":{"user_id":` + strconv.Itoa(walt.ID) + `}}`
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name      string
+		secret    string
+		body      string
+		timestamp time.Time
+		expected  string
+	}{
+		{name: "legacy payload", body: legacy, expected: database.WebhookProcessed},
+		{name: "legacy payload again", body: legacy, expected: database.WebhookProcessed},
+		{name: "signed", secret: "whsec", body: legacy, timestamp: now, expected: database.WebhookProcessed},
+		{name: "signed replay", secret: "whsec", body: legacy, timestamp: now, expected: database.WebhookDuplicate},
+		{name: "signed later", secret: "whsec", body: legacy, timestamp: now.Add(time.Second), expected: database.WebhookProcessed},
+		{name: "event id", body: `{"id":"evt_1","event":"user.renewed","data":{"user_id":` + strconv.Itoa(walt.ID) + `}}`, expected: database.WebhookProcessed},
+		{name: "event id retried", body: `{"id":"evt_1","event":"user.renewed","data":{"user_id":` + strconv.Itoa(walt.ID) + `}}`, expected: database.WebhookDuplicate},
+	}
+
+	for _, test := range tests {
+		cfg := &apiConfig{db: db, polkaAPIKey: "polkakey", polkaWebhookSecret: test.secret}
+
+		r := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(test.body))
+		if test.secret != "" {
+			r.Header.Set("Polka-Signature", security.SignWebhook(test.secret, test.timestamp, []byte(test.body)))
+		} else {
+			r.Header.Set("Authorization", "ApiKey polkakey")
+		}
+
+		w := httptest.NewRecorder()
+		cfg.handlerWebhook(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d not equal to expected %d", test.name, w.Code, http.StatusOK)
+		}
+
+		deliveries, _ := db.GetWebhookDeliveries("polka", "", 1)
+		if len(deliveries) != 1 || deliveries[0].Outcome != test.expected {
+			t.Errorf("%s: output %v not equal to expected %s", test.name, deliveries, test.expected)
+		}
+	}
+}
+
+func TestWebhookRejectedNotRecorded(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"event":"user.upgraded","data":{"user_id":1}}`
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		value  string
+	}{
+		{name: "wrong API key", header: "Authorization", value: "ApiKey wrong"},
+		{name: "wrong signature", secret: "whsec", header: "Polka-Signature", value: security.SignWebhook("other", time.Now().UTC(), []byte(body))},
+	}
+
+	for _, test := range tests {
+		cfg := &apiConfig{db: db, polkaAPIKey: "polkakey", polkaWebhookSecret: test.secret}
+
+		r := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(body))
+		r.Header.Set(test.header, test.value)
+
+		w := httptest.NewRecorder()
+		cfg.handlerWebhook(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d not equal to expected %d", test.name, w.Code, http.StatusUnauthorized)
+		}
+
+		deliveries, _ := db.GetWebhookDeliveries("polka", "", 0)
+		if len(deliveries) != 0 {
+			t.Errorf("%s: output %v not equal to expected no deliveries", test.name, deliveries)
+		}
+	}
+}
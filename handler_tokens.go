@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+func (cfg *apiConfig) handlerPostTokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	type requestBody struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to create token")
+		return
+	}
+
+	if reqBody.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "token name is required")
+		return
+	}
+
+	if len(reqBody.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "at least one scope is required")
+		return
+	}
+
+	for _, s := range reqBody.Scopes {
+		if !security.IsValidScope(s) {
+			respondWithError(w, http.StatusBadRequest, "invalid scope: "+s)
+			return
+		}
+	}
+
+	token, err := security.GenerateAPIToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to create token")
+		return
+	}
+
+	t, err := cfg.db.CreateAPIToken(userID, reqBody.Name, security.HashToken(token), reqBody.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to create token")
+		return
+	}
+
+	// The plain token is only ever returned here
+	type responseBody struct {
+		ID     int      `json:"id"`
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		Token  string   `json:"token"`
+	}
+
+	resp := responseBody{
+		ID:     t.ID,
+		Name:   t.Name,
+		Scopes: t.Scopes,
+		Token:  token,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	dat, _ := json.Marshal(resp)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerGetTokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	tokenList, err := cfg.db.GetAPITokens(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to get tokens")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(tokenList)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerDeleteToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	paramValue := chi.URLParam(r, "tokenID")
+	tokenID, err := strconv.Atoi(paramValue)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid token id value: "+paramValue)
+		return
+	}
+
+	err = cfg.db.RevokeAPIToken(userID, tokenID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
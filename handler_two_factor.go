@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	mfaTokenExpiresInSeconds = 60 * 5 // 5 minutes
+	totpIssuer               = "Chirpy"
+)
+
+func respondWithMFAChallenge(w http.ResponseWriter, user database.User) {
+	mfaToken, err := security.CreateJwtToken(user.ID, mfaTokenExpiresInSeconds, "chirpy-mfa")
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "fail to generate mfaToken")
+		return
+	}
+
+	type responseBody struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	resp := responseBody{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(resp)
+	w.Write(dat)
+}
+
+// verifySecondFactor accepts either a TOTP code or one of the user's recovery codes
+func (cfg *apiConfig) verifySecondFactor(tf database.TwoFactor, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		return cfg.db.UseRecoveryCode(tf.UserID, security.HashToken(recoveryCode))
+	}
+
+	step, ok := security.ValidateTOTP(tf.Secret, code, time.Now().UTC())
+	if !ok {
+		return errors.New("code is invalid")
+	}
+
+	return cfg.db.UseTwoFactorStep(tf.UserID, step)
+}
+
+func (cfg *apiConfig) handlerPostLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type requestBody struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err := decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to login")
+		return
+	}
+
+	claims, err := security.GetTokenClaims(reqBody.MFAToken)
+	if err != nil || claims.Issuer != "chirpy-mfa" {
+		respondWithError(w, http.StatusUnauthorized, "mfa token is invalid")
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "user id is invalid")
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "fail to login")
+		return
+	}
+
+	if user.SuspendedAt != nil {
+		respondWithErr(w, errSuspended)
+		return
+	}
+
+	attempt := cfg.reserveLoginAttempt(w, r, user.Email)
+	if attempt == nil {
+		return
+	}
+	defer attempt.release()
+
+	tf, err := cfg.db.GetTwoFactor(userID)
+	if err != nil || !tf.Enabled {
+		respondWithError(w, http.StatusUnauthorized, "fail to login")
+		return
+	}
+
+	err = cfg.verifySecondFactor(tf, reqBody.Code, reqBody.RecoveryCode)
+	if err != nil {
+		attempt.fail()
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	cfg.recordLoginSuccess(user.Email)
+	cfg.cancelAccountDeletion(user)
+	cfg.respondWithLogin(w, r, user)
+}
+
+func (cfg *apiConfig) handlerPostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	secret, err := security.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to enroll two-factor authentication")
+		return
+	}
+
+	err = cfg.db.SaveTwoFactorSecret(userID, secret)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	type responseBody struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+		QRCodeURL  string `json:"qr_code_url"`
+	}
+
+	resp := responseBody{
+		Secret:     secret,
+		OTPAuthURI: security.TOTPURI(totpIssuer, user.Email, secret),
+		QRCodeURL:  "/api/users/2fa/qr",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	dat, _ := json.Marshal(resp)
+	w.Write(dat)
+}
+
+// handlerGetTwoFactorQRCode renders the pending enrollment as a PNG so it can be scanned by an authenticator app
+func (cfg *apiConfig) handlerGetTwoFactorQRCode(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	tf, err := cfg.db.GetTwoFactor(userID)
+	if err != nil || tf.Enabled {
+		respondWithError(w, http.StatusNotFound, "no pending two-factor enrollment")
+		return
+	}
+
+	png, err := security.TOTPQRCode(security.TOTPURI(totpIssuer, user.Email, tf.Secret))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to generate qr code")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
+}
+
+func (cfg *apiConfig) handlerVerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	type requestBody struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to verify code")
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	// Codes are guessable like passwords, so wrong ones count against the account too
+	attempt := cfg.reserveLoginAttempt(w, r, user.Email)
+	if attempt == nil {
+		return
+	}
+	defer attempt.release()
+
+	tf, err := cfg.db.GetTwoFactor(userID)
+	if err != nil || tf.Enabled {
+		respondWithError(w, http.StatusNotFound, "no pending two-factor enrollment")
+		return
+	}
+
+	step, ok := security.ValidateTOTP(tf.Secret, reqBody.Code, time.Now().UTC())
+	if !ok {
+		attempt.fail()
+		respondWithError(w, http.StatusBadRequest, "code is invalid")
+		return
+	}
+
+	recoveryCodes, err := security.GenerateRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to generate recovery codes")
+		return
+	}
+
+	recoveryCodeHashes := make([]string, 0, len(recoveryCodes))
+	for _, c := range recoveryCodes {
+		recoveryCodeHashes = append(recoveryCodeHashes, security.HashToken(c))
+	}
+
+	err = cfg.db.EnableTwoFactor(userID, step, recoveryCodeHashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to enable two-factor authentication")
+		return
+	}
+
+	// Recovery codes are only ever returned here
+	type responseBody struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(responseBody{RecoveryCodes: recoveryCodes})
+	w.Write(dat)
+}
+
+// handlerDisableTwoFactor requires the password and a second factor so a stolen access token is not enough
+func (cfg *apiConfig) handlerDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	type requestBody struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to disable two-factor authentication")
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	attempt := cfg.reserveLoginAttempt(w, r, user.Email)
+	if attempt == nil {
+		return
+	}
+	defer attempt.release()
+
+	err = cfg.verifyPassword(user, reqBody.Password)
+	if err != nil {
+		attempt.fail()
+		respondWithProblem(w, http.StatusUnauthorized, codeInvalidCredentials, "password is incorrect")
+		return
+	}
+
+	tf, err := cfg.db.GetTwoFactor(userID)
+	if err != nil || !tf.Enabled {
+		respondWithError(w, http.StatusNotFound, "two-factor authentication is not enabled")
+		return
+	}
+
+	err = cfg.verifySecondFactor(tf, reqBody.Code, reqBody.RecoveryCode)
+	if err != nil {
+		attempt.fail()
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	err = cfg.db.DisableTwoFactor(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to disable two-factor authentication")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const webhookDispatchInterval = time.Second * 10
+
+// createWebhookEndpoint registers an endpoint for ownerID, 0 meaning an admin endpoint that receives all events
+func (cfg *apiConfig) createWebhookEndpoint(w http.ResponseWriter, r *http.Request, ownerID int) {
+	type requestBody struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err := decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to create webhook")
+		return
+	}
+
+	u, err := url.Parse(reqBody.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		respondWithError(w, http.StatusBadRequest, "invalid webhook url: "+reqBody.URL)
+		return
+	}
+
+	if len(reqBody.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "at least one event is required")
+		return
+	}
+
+	for _, e := range reqBody.Events {
+		if !database.IsValidOutboundEvent(e) {
+			respondWithError(w, http.StatusBadRequest, "invalid event: "+e)
+			return
+		}
+	}
+
+	secret, err := security.GenerateRandomString(32)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to create webhook")
+		return
+	}
+
+	// The secret is only ever returned here
+	endpoint, err := cfg.db.CreateWebhookEndpoint(ownerID, u.String(), reqBody.Events, "whsec_"+secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to create webhook")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	dat, _ := json.Marshal(endpoint)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) getWebhookEndpoints(w http.ResponseWriter, ownerID int) {
+	endpoints, err := cfg.db.GetWebhookEndpoints(ownerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to get webhooks")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(endpoints)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) deleteWebhookEndpoint(w http.ResponseWriter, r *http.Request, ownerID int) {
+	paramValue := chi.URLParam(r, "webhookID")
+	webhookID, err := strconv.Atoi(paramValue)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid webhook id value: "+paramValue)
+		return
+	}
+
+	err = cfg.db.DeleteWebhookEndpoint(ownerID, webhookID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// getOutboundWebhooks lists deliveries newest first. Supports ?status= and ?limit=.
+func (cfg *apiConfig) getOutboundWebhooks(w http.ResponseWriter, r *http.Request, endpointID int, status string) {
+	limit := defaultDeliveriesLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			respondWithError(w, http.StatusBadRequest, "invalid limit value: "+v)
+			return
+		}
+	}
+
+	if status == "" {
+		status = r.URL.Query().Get("status")
+	}
+
+	deliveries, err := cfg.db.GetOutboundWebhooks(endpointID, status, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to get webhook deliveries")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(deliveries)
+	w.Write(dat)
+}
+
+func (cfg *apiConfig) handlerPostWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	cfg.createWebhookEndpoint(w, r, userID)
+}
+
+func (cfg *apiConfig) handlerGetWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	cfg.getWebhookEndpoints(w, userID)
+}
+
+func (cfg *apiConfig) handlerDeleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	cfg.deleteWebhookEndpoint(w, r, userID)
+}
+
+func (cfg *apiConfig) handlerGetWebhookEndpointDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateAccessToken(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	paramValue := chi.URLParam(r, "webhookID")
+	webhookID, err := strconv.Atoi(paramValue)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid webhook id value: "+paramValue)
+		return
+	}
+
+	endpoint, err := cfg.db.GetWebhookEndpoint(webhookID)
+	if err != nil || endpoint.OwnerID != userID {
+		respondWithError(w, http.StatusNotFound, "webhook endpoint does not exist")
+		return
+	}
+
+	cfg.getOutboundWebhooks(w, r, webhookID, "")
+}
+
+func (cfg *apiConfig) handlerAdminPostWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	cfg.createWebhookEndpoint(w, r, 0)
+}
+
+func (cfg *apiConfig) handlerAdminGetWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	cfg.getWebhookEndpoints(w, 0)
+}
+
+func (cfg *apiConfig) handlerAdminDeleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	cfg.deleteWebhookEndpoint(w, r, 0)
+}
+
+// handlerGetWebhookDeadLetters lists deliveries of every endpoint that ran out of attempts
+func (cfg *apiConfig) handlerGetWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	cfg.getOutboundWebhooks(w, r, 0, database.OutboundDead)
+}
+
+func (cfg *apiConfig) handlerRetryWebhookDeadLetter(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	paramValue := chi.URLParam(r, "deliveryID")
+	deliveryID, err := strconv.Atoi(paramValue)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid delivery id value: "+paramValue)
+		return
+	}
+
+	delivery, err := cfg.db.RetryOutboundWebhook(deliveryID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	dat, _ := json.Marshal(delivery)
+	w.Write(dat)
+}
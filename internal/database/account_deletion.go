@@ -0,0 +1,231 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+)
+
+// DeletedChirpsPolicy decides what happens to the chirps of a deleted account
+type DeletedChirpsPolicy string
+
+const (
+	DeleteChirps    DeletedChirpsPolicy = "delete"
+	AnonymizeChirps DeletedChirpsPolicy = "anonymize"
+)
+
+func (db *DB) ScheduleUserDeletion(userID int, at time.Time) (User, error) {
+	var u User
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		u, ok = dbStructure.Users[userID]
+		if !ok {
+			return notFound("cannot find user with id: %d", userID)
+		}
+
+		at = at.UTC()
+		u.DeletionScheduledAt = &at
+		dbStructure.Users[userID] = u
+
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return u, nil
+}
+
+// CancelUserDeletion is a no-op when no deletion is scheduled
+func (db *DB) CancelUserDeletion(userID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		u, ok := dbStructure.Users[userID]
+		if !ok || u.DeletionScheduledAt == nil {
+			return errUnchanged
+		}
+
+		u.DeletionScheduledAt = nil
+		dbStructure.Users[userID] = u
+
+		return nil
+	})
+}
+
+// PurgeDeletedUsers permanently removes users whose grace period is over, along with everything that belongs to them
+func (db *DB) PurgeDeletedUsers(now time.Time, policy DeletedChirpsPolicy) (int, error) {
+	purged := 0
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, u := range dbStructure.Users {
+			if u.DeletionScheduledAt == nil || u.DeletionScheduledAt.After(now) {
+				continue
+			}
+
+			purgeUser(dbStructure, id, policy)
+			purged++
+		}
+
+		if purged == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+func purgeUser(dbStructure *DBStructure, userID int, policy DeletedChirpsPolicy) {
+	// Reports are matched to the user's chirps before those are deleted
+	purgeUserModeration(dbStructure, userID, policy)
+
+	for id, c := range dbStructure.Chirps {
+		if c.AuthorID != userID {
+			continue
+		}
+
+		if policy == AnonymizeChirps {
+			c.AuthorID = 0
+			dbStructure.Chirps[id] = c
+		} else {
+			delete(dbStructure.Chirps, id)
+		}
+	}
+
+	for id, v := range dbStructure.Relationships {
+		if v.UserID == userID || v.TargetID == userID {
+			delete(dbStructure.Relationships, id)
+		}
+	}
+
+	for id, t := range dbStructure.RefreshTokenRevocation {
+		if t.UserID == userID {
+			delete(dbStructure.RefreshTokenRevocation, id)
+		}
+	}
+
+	for id, t := range dbStructure.APITokens {
+		if t.UserID == userID {
+			delete(dbStructure.APITokens, id)
+		}
+	}
+
+	for id, c := range dbStructure.OAuthClients {
+		if c.OwnerID == userID {
+			delete(dbStructure.OAuthClients, id)
+		}
+	}
+
+	for id, c := range dbStructure.OAuthCodes {
+		if c.UserID == userID {
+			delete(dbStructure.OAuthCodes, id)
+		}
+	}
+
+	for id, e := range dbStructure.DataExports {
+		if e.UserID != userID {
+			continue
+		}
+
+		if e.Path != "" {
+			err := os.Remove(e.Path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Error removing data export %d: %s", id, err)
+			}
+		}
+		delete(dbStructure.DataExports, id)
+	}
+
+	for id, e := range dbStructure.WebhookEndpoints {
+		if e.OwnerID == userID {
+			deleteWebhookEndpoint(dbStructure, id)
+		}
+	}
+
+	for id, d := range dbStructure.WebhookDeliveries {
+		if d.UserID == userID {
+			delete(dbStructure.WebhookDeliveries, id)
+		}
+	}
+
+	for id, t := range dbStructure.UsedActionTokens {
+		if t.UserID == userID {
+			delete(dbStructure.UsedActionTokens, id)
+		}
+	}
+
+	delete(dbStructure.TwoFactor, userID)
+	delete(dbStructure.Users, userID)
+}
+
+// purgeUserModeration removes the user from reports and the moderation audit. Open reports about the user or
+// their deleted chirps are dropped; resolved ones are kept for the audit trail without the reported content.
+func purgeUserModeration(dbStructure *DBStructure, userID int, policy DeletedChirpsPolicy) {
+	for id, r := range dbStructure.Reports {
+		if r.ReporterID == userID {
+			r.ReporterID = 0
+		}
+		if r.ClaimedBy == userID {
+			r.ClaimedBy = 0
+		}
+		if r.ResolvedBy == userID {
+			r.ResolvedBy = 0
+		}
+
+		chirp, ok := dbStructure.Chirps[r.TargetID]
+		aboutUser := (r.TargetType == ReportTargetUser && r.TargetID == userID) ||
+			(r.TargetType == ReportTargetChirp && ok && chirp.AuthorID == userID && policy == DeleteChirps)
+		if aboutUser {
+			if r.Status != ReportResolved {
+				delete(dbStructure.Reports, id)
+				continue
+			}
+
+			r.Snapshot = ""
+			r.Details = ""
+			if r.TargetType == ReportTargetUser {
+				r.TargetID = 0
+			}
+		}
+
+		dbStructure.Reports[id] = r
+	}
+
+	for id, e := range dbStructure.ModerationAudit {
+		if e.ModeratorID == userID {
+			e.ModeratorID = 0
+		}
+		if e.TargetType == ReportTargetUser && e.TargetID == userID {
+			e.TargetID = 0
+		}
+		dbStructure.ModerationAudit[id] = e
+	}
+}
+
+// RunAccountDeletionJob purges deleted users every interval until ctx is cancelled
+func (db *DB) RunAccountDeletionJob(ctx context.Context, interval time.Duration, policy DeletedChirpsPolicy) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := db.PurgeDeletedUsers(time.Now().UTC(), policy)
+			if err != nil {
+				log.Printf("Error purging deleted users: %s", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d deleted users", purged)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	jesse, _ := db.CreateUser("jesse@breakingbad.com", "hash")
+	db.CreateChirp("say my name", walt.ID)
+	jesseChirp, _ := db.CreateChirp("yeah science", jesse.ID)
+
+	now := time.Now().UTC()
+	_, err = db.ScheduleUserDeletion(walt.ID, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ScheduleUserDeletion(jesse.ID, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := db.PurgeDeletedUsers(now, DeleteChirps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("Output %d not equal to expected %d", purged, 1)
+	}
+
+	if _, err := db.GetUserByID(walt.ID); err == nil {
+		t.Errorf("User %d should have been purged", walt.ID)
+	}
+
+	chirps, _ := db.GetChirps(0, 0)
+	if len(chirps) != 1 || chirps[0].ID != jesseChirp.ID {
+		t.Errorf("Only chirp %d should be left, got %v", jesseChirp.ID, chirps)
+	}
+
+	// Ids of purged users must not be handed out again
+	skyler, _ := db.CreateUser("skyler@breakingbad.com", "hash")
+	if skyler.ID == walt.ID || skyler.ID == jesse.ID {
+		t.Errorf("New user got id %d which was already used", skyler.ID)
+	}
+}
+
+func TestPurgeDeletedUsersAnonymizesChirps(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	c, _ := db.CreateChirp("say my name", walt.ID)
+
+	now := time.Now().UTC()
+	db.ScheduleUserDeletion(walt.ID, now)
+
+	_, err = db.PurgeDeletedUsers(now, AnonymizeChirps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anonymized, err := db.GetChirp(c.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anonymized.AuthorID != 0 {
+		t.Errorf("Output %d not equal to expected %d", anonymized.AuthorID, 0)
+	}
+}
+
+func TestPurgeDeletedUsersRemovesPersonalData(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(filepath.Join(dir, "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	jesse, _ := db.CreateUser("jesse@breakingbad.com", "hash")
+	hank, _ := db.CreateUser("hank@breakingbad.com", "hash")
+	waltChirp, _ := db.CreateChirp("say my name", walt.ID)
+	jesseChirp, _ := db.CreateChirp("yeah science", jesse.ID)
+
+	// Open reports about walt are dropped, resolved ones are kept without walt
+	openReport, _ := db.CreateReport(jesse.ID, ReportTargetChirp, waltChirp.ID, "harassment", "he is mean")
+	resolvedReport, _ := db.CreateReport(jesse.ID, ReportTargetUser, walt.ID, "harassment", "he is mean")
+	db.ClaimReport(resolvedReport.ID, hank.ID)
+	db.ResolveReport(resolvedReport.ID, hank.ID, ModerationDismiss, "")
+	// walt reported jesse, and moderated the report himself
+	waltsReport, _ := db.CreateReport(walt.ID, ReportTargetChirp, jesseChirp.ID, "spam", "")
+	db.ClaimReport(waltsReport.ID, walt.ID)
+	db.ResolveReport(waltsReport.ID, walt.ID, ModerationDismiss, "")
+
+	db.RecordWebhookDelivery(WebhookDelivery{Source: "polka", UserID: walt.ID, Outcome: WebhookProcessed, ReceivedAt: time.Now().UTC()})
+	db.RecordWebhookDelivery(WebhookDelivery{Source: "polka", UserID: jesse.ID, Outcome: WebhookProcessed, ReceivedAt: time.Now().UTC()})
+	db.ConsumeActionToken("walt-reset", walt.ID, time.Now().Add(time.Hour))
+
+	archive := filepath.Join(dir, "walt.zip")
+	os.WriteFile(archive, []byte("archive"), 0600)
+	e, _ := db.CreateDataExport(walt.ID)
+	db.CompleteDataExport(e.ID, archive, time.Now().Add(time.Hour), nil)
+
+	now := time.Now().UTC()
+	db.ScheduleUserDeletion(walt.ID, now)
+	_, err = db.PurgeDeletedUsers(now, DeleteChirps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GetReport(openReport.ID); err == nil {
+		t.Errorf("Open report %d about the purged user should be deleted", openReport.ID)
+	}
+
+	r, err := db.GetReport(resolvedReport.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.TargetID != 0 || r.Details != "" || r.ResolvedBy != hank.ID {
+		t.Errorf("Output %+v not equal to expected report without the purged user", r)
+	}
+
+	r, err = db.GetReport(waltsReport.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ReporterID != 0 || r.ClaimedBy != 0 || r.ResolvedBy != 0 || r.TargetID != jesseChirp.ID {
+		t.Errorf("Output %+v not equal to expected report without the purged user", r)
+	}
+
+	for _, reportID := range []int{resolvedReport.ID, waltsReport.ID} {
+		entries, _ := db.GetModerationAudit(reportID, 0)
+		for _, entry := range entries {
+			if entry.ModeratorID == walt.ID || (entry.TargetType == ReportTargetUser && entry.TargetID == walt.ID) {
+				t.Errorf("Audit entry %+v still refers to the purged user", entry)
+			}
+		}
+	}
+
+	deliveries, _ := db.GetWebhookDeliveries("polka", "", 0)
+	if len(deliveries) != 1 || deliveries[0].UserID != jesse.ID {
+		t.Errorf("Only the delivery of user %d should be left, got %v", jesse.ID, deliveries)
+	}
+
+	dbStructure, _ := db.loadDB()
+	if _, ok := dbStructure.UsedActionTokens["walt-reset"]; ok {
+		t.Errorf("Used action token of the purged user should be deleted")
+	}
+
+	if _, err := os.Stat(archive); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Data export archive should be removed, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// UsedActionToken remembers a single-use token that was used, until it would have expired anyway
+type UsedActionToken struct {
+	UserID    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (t *UsedActionToken) UnmarshalJSON(data []byte) error {
+	// Tokens used before the user was recorded were stored as their expiry only
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &t.ExpiresAt)
+	}
+
+	type usedActionToken UsedActionToken
+	return json.Unmarshal(data, (*usedActionToken)(t))
+}
+
+// ConsumeActionToken records the id of a single-use token, e.g. a password reset token, and fails if it was
+// already used. Entries are kept until the token would have expired anyway.
+func (db *DB) ConsumeActionToken(tokenID string, userID int, expiresAt time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if dbStructure.UsedActionTokens == nil {
+			dbStructure.UsedActionTokens = map[string]UsedActionToken{}
+		}
+
+		if _, ok := dbStructure.UsedActionTokens[tokenID]; ok {
+			return conflict("token has already been used")
+		}
+
+		now := time.Now().UTC()
+		for k, v := range dbStructure.UsedActionTokens {
+			if v.ExpiresAt.Before(now) {
+				delete(dbStructure.UsedActionTokens, k)
+			}
+		}
+
+		dbStructure.UsedActionTokens[tokenID] = UsedActionToken{UserID: userID, ExpiresAt: expiresAt}
+
+		return nil
+	})
+}
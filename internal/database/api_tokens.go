@@ -0,0 +1,103 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+type APIToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	Name      string     `json:"name"`
+	TokenHash string     `json:"token_hash,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (db *DB) CreateAPIToken(userID int, name, tokenHash string, scopes []string) (APIToken, error) {
+	var t APIToken
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		if dbStructure.APITokens == nil {
+			dbStructure.APITokens = map[int]APIToken{}
+		}
+
+		nextIndex := nextID(dbStructure, "api_tokens", dbStructure.APITokens)
+
+		t = APIToken{
+			ID:        nextIndex,
+			UserID:    userID,
+			Name:      name,
+			TokenHash: tokenHash,
+			Scopes:    scopes,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.APITokens[nextIndex] = t
+
+		return nil
+	})
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	t.TokenHash = "" // Don't return the hash :)
+
+	return t, nil
+}
+
+func (db *DB) GetAPITokens(userID int) ([]APIToken, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	tokenList := make([]APIToken, 0)
+	for _, v := range dbStructure.APITokens {
+		if v.UserID == userID {
+			v.TokenHash = ""
+			tokenList = append(tokenList, v)
+		}
+	}
+
+	sort.Slice(tokenList, func(i, j int) bool {
+		return tokenList[i].ID < tokenList[j].ID
+	})
+
+	return tokenList, nil
+}
+
+// GetAPITokenByHash returns the active (non-revoked) token matching the hash
+func (db *DB) GetAPITokenByHash(tokenHash string) (APIToken, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	for _, v := range dbStructure.APITokens {
+		if v.TokenHash == tokenHash && v.RevokedAt == nil {
+			return v, nil
+		}
+	}
+
+	return APIToken{}, notFound("api token does not exist")
+}
+
+func (db *DB) RevokeAPIToken(userID, tokenID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		t, ok := dbStructure.APITokens[tokenID]
+		if !ok || t.UserID != userID {
+			return notFound("api token does not exist")
+		}
+
+		if t.RevokedAt != nil {
+			return conflict("api token is already revoked")
+		}
+
+		now := time.Now().UTC()
+		t.RevokedAt = &now
+		dbStructure.APITokens[tokenID] = t
+
+		return nil
+	})
+}
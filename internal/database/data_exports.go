@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// DataExport tracks an asynchronous personal data export. Path points at the finished archive.
+type DataExport struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status"`
+	Path        string     `json:"path,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+func (db *DB) CreateDataExport(userID int) (DataExport, error) {
+	var e DataExport
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		if dbStructure.DataExports == nil {
+			dbStructure.DataExports = map[int]DataExport{}
+		}
+
+		for _, v := range dbStructure.DataExports {
+			if v.UserID == userID && v.Status == ExportPending {
+				return conflict("an export is already in progress")
+			}
+		}
+
+		nextIndex := nextID(dbStructure, "data_exports", dbStructure.DataExports)
+
+		e = DataExport{
+			ID:        nextIndex,
+			UserID:    userID,
+			Status:    ExportPending,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.DataExports[nextIndex] = e
+
+		return nil
+	})
+	if err != nil {
+		return DataExport{}, err
+	}
+
+	return e, nil
+}
+
+func (db *DB) GetDataExport(id int) (DataExport, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return DataExport{}, err
+	}
+
+	e, ok := dbStructure.DataExports[id]
+	if !ok {
+		return DataExport{}, notFound("export does not exist")
+	}
+
+	return e, nil
+}
+
+// CompleteDataExport marks the export as ready, or failed when exportErr is not nil
+func (db *DB) CompleteDataExport(id int, path string, expiresAt time.Time, exportErr error) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		e, ok := dbStructure.DataExports[id]
+		if !ok {
+			return notFound("export does not exist")
+		}
+
+		now := time.Now().UTC()
+		e.CompletedAt = &now
+
+		if exportErr != nil {
+			e.Status = ExportFailed
+			e.Error = exportErr.Error()
+		} else {
+			e.Status = ExportReady
+			e.Path = path
+			expiresAt = expiresAt.UTC()
+			e.ExpiresAt = &expiresAt
+		}
+
+		dbStructure.DataExports[id] = e
+
+		return nil
+	})
+}
+
+// FailPendingDataExports fails the exports that were still running when the server stopped, so users
+// can request a new one. The failed exports are returned so their partial archives can be removed.
+func (db *DB) FailPendingDataExports() ([]DataExport, error) {
+	failed := []DataExport{}
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+
+		for id, e := range dbStructure.DataExports {
+			if e.Status != ExportPending {
+				continue
+			}
+
+			e.Status = ExportFailed
+			e.Error = "export was interrupted by a restart"
+			e.CompletedAt = &now
+			dbStructure.DataExports[id] = e
+			failed = append(failed, e)
+		}
+
+		if len(failed) == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return failed, nil
+}
+
+// ExpireDataExports removes the archives of the exports that expired before now
+func (db *DB) ExpireDataExports(now time.Time) (int, error) {
+	expired := 0
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, e := range dbStructure.DataExports {
+			if e.Path == "" || e.ExpiresAt == nil || e.ExpiresAt.After(now) {
+				continue
+			}
+
+			err := os.Remove(e.Path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Error removing data export %d: %s", id, err)
+				continue
+			}
+
+			e.Path = ""
+			dbStructure.DataExports[id] = e
+			expired++
+		}
+
+		if expired == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
+
+// RunDataExportExpiryJob removes expired archives every interval until ctx is cancelled
+func (db *DB) RunDataExportExpiryJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := db.ExpireDataExports(time.Now().UTC())
+			if err != nil {
+				log.Printf("Error expiring data exports: %s", err)
+				continue
+			}
+			if expired > 0 {
+				log.Printf("Removed %d expired data exports", expired)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDataExportStatus(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(filepath.Join(dir, "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	jesse, _ := db.CreateUser("jesse@breakingbad.com", "hash")
+	skyler, _ := db.CreateUser("skyler@breakingbad.com", "hash")
+
+	ready, _ := db.CreateDataExport(walt.ID)
+	_, err = db.CreateDataExport(walt.ID)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("Output %v not equal to expected %v", err, ErrConflict)
+	}
+
+	path := filepath.Join(dir, "export.zip")
+	os.WriteFile(path, []byte("zip"), 0600)
+	db.CompleteDataExport(ready.ID, path, time.Now().Add(time.Hour), nil)
+
+	failed, _ := db.CreateDataExport(jesse.ID)
+	db.CompleteDataExport(failed.ID, "", time.Now().Add(time.Hour), errors.New("disk is full"))
+
+	// The server stops while skyler's export is running
+	interrupted, _ := db.CreateDataExport(skyler.ID)
+	failedOnStartup, err := db.FailPendingDataExports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failedOnStartup) != 1 || failedOnStartup[0].ID != interrupted.ID {
+		t.Errorf("Output %v not equal to expected export %d", failedOnStartup, interrupted.ID)
+	}
+
+	tests := []struct {
+		name     string
+		id       int
+		expected string
+	}{
+		{name: "ready", id: ready.ID, expected: ExportReady},
+		{name: "failed", id: failed.ID, expected: ExportFailed},
+		{name: "interrupted", id: interrupted.ID, expected: ExportFailed},
+	}
+
+	for _, test := range tests {
+		e, _ := db.GetDataExport(test.id)
+		if e.Status != test.expected {
+			t.Errorf("%s: output %s not equal to expected %s", test.name, e.Status, test.expected)
+		}
+	}
+
+	_, err = db.CreateDataExport(skyler.ID)
+	if err != nil {
+		t.Errorf("A new export should be allowed after a restart: %s", err)
+	}
+
+	expired, _ := db.ExpireDataExports(time.Now())
+	if expired != 0 {
+		t.Errorf("Output %d expired exports not equal to expected %d", expired, 0)
+	}
+
+	expired, _ = db.ExpireDataExports(time.Now().Add(2 * time.Hour))
+	if expired != 1 {
+		t.Errorf("Output %d expired exports not equal to expected %d", expired, 1)
+	}
+
+	_, err = os.Stat(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expired archive was not removed: %v", err)
+	}
+}
@@ -4,49 +4,95 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"golang.org/x/crypto/bcrypt"
 	"io/fs"
 	"log"
-	"net/http"
 	"os"
-	"strconv"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+var ErrEmailTaken = conflict("email already exist")
+
 type DB struct {
-	path string
-	mux  *sync.RWMutex
+	path   string
+	mux    *sync.RWMutex
+	health *healthTracker
 }
 
 type DBStructure struct {
 	Chirps                 map[int]Chirp                  `json:"chirps"`
 	Users                  map[int]User                   `json:"users"`
 	RefreshTokenRevocation map[int]RefreshTokenRevocation `json:"refresh_token_revocation"`
+	APITokens              map[int]APIToken               `json:"api_tokens"`
+	OAuthClients           map[string]OAuthClient         `json:"oauth_clients"`
+	OAuthCodes             map[string]OAuthCode           `json:"oauth_codes"`
+	TwoFactor              map[int]TwoFactor              `json:"two_factor"`
+	UsedActionTokens       map[string]UsedActionToken     `json:"used_action_tokens"`
+	Sequences              map[string]int                 `json:"sequences"`
+	DataExports            map[int]DataExport             `json:"data_exports"`
+	WebhookEvents          map[string]time.Time           `json:"webhook_events"`
+	WebhookDeliveries      map[int]WebhookDelivery        `json:"webhook_deliveries"`
+	WebhookEndpoints       map[int]WebhookEndpoint        `json:"webhook_endpoints"`
+	OutboundWebhooks       map[int]OutboundWebhook        `json:"outbound_webhooks"`
+	Reports                map[int]Report                 `json:"reports"`
+	ModerationAudit        map[int]ModerationAuditEntry   `json:"moderation_audit"`
+	Relationships          map[int]Relationship           `json:"relationships"`
 }
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	AuthorID int    `json:"author_id"`
-	Body     string `json:"body"`
+	ID       int        `json:"id"`
+	AuthorID int        `json:"author_id"`
+	Body     string     `json:"body"`
+	Media    []string   `json:"media,omitempty"`
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// HiddenAt is set when a moderator hid the chirp, it is then only visible to its author
+	HiddenAt *time.Time `json:"hidden_at,omitempty"`
+	// HeldAt is set while the chirp waits for a moderator to approve it, only its author can see it until then
+	HeldAt    *time.Time `json:"held_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
 }
 
 type User struct {
-	ID          int    `json:"id"`
-	Email       string `json:"email"`
-	Password    string `json:"password,omitempty"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	ID            int    `json:"id"`
+	Email         string `json:"email"`
+	Password      string `json:"password,omitempty"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
+	EmailVerified bool   `json:"email_verified"`
+	PendingEmail  string `json:"pending_email,omitempty"`
+	// DeletionScheduledAt is when the account will be permanently deleted, unless the user logs in before
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+	SubscriptionStatus  string     `json:"subscription_status,omitempty"`
+	ChirpyRedSince      *time.Time `json:"chirpy_red_since,omitempty"`
+	// ChirpyRedExpiresAt is nil for users upgraded before subscriptions expired
+	ChirpyRedExpiresAt *time.Time `json:"chirpy_red_expires_at,omitempty"`
+	SuspendedAt        *time.Time `json:"suspended_at,omitempty"`
+	// ShadowBannedAt is set when the user's chirps are only visible to themselves
+	ShadowBannedAt *time.Time `json:"shadow_banned_at,omitempty"`
+	// TokensRevokedAt is when the password last changed, refresh tokens issued until then are no longer accepted
+	TokensRevokedAt *time.Time `json:"tokens_revoked_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// TokenRevoked reports whether a token issued at the given time was revoked by a password change.
+// Token timestamps only have seconds, so tokens issued in the second of the change are revoked too.
+func (u User) TokenRevoked(issuedAt time.Time) bool {
+	return u.TokensRevokedAt != nil && !issuedAt.After(*u.TokensRevokedAt)
 }
 
 type RefreshTokenRevocation struct {
-	ID   string    `json:"id"`
-	Time time.Time `json:"time"`
+	ID     string    `json:"id"`
+	UserID int       `json:"user_id,omitempty"`
+	Time   time.Time `json:"time"`
 }
 
 func NewDB(path string) (*DB, error) {
 	db := DB{
-		path: path,
-		mux:  &sync.RWMutex{},
+		path:   path,
+		mux:    &sync.RWMutex{},
+		health: &healthTracker{},
 	}
 
 	err := db.ensureDB()
@@ -57,39 +103,23 @@ func NewDB(path string) (*DB, error) {
 	return &db, nil
 }
 
-func (db *DB) RevokeRefreshToken(token string) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	refreshTokenRevocation := dbStructure.RefreshTokenRevocation
-	nextIndex := len(refreshTokenRevocation) + 1
-
-	newTokenRevocation := RefreshTokenRevocation{
-		ID:   token,
-		Time: time.Now().UTC(),
-	}
+func (db *DB) RevokeRefreshToken(token string, userID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		nextIndex := nextID(dbStructure, "refresh_token_revocation", dbStructure.RefreshTokenRevocation)
 
-	if refreshTokenRevocation == nil {
-		refreshTokenRevocation = map[int]RefreshTokenRevocation{
-			nextIndex: newTokenRevocation,
+		newTokenRevocation := RefreshTokenRevocation{
+			ID:     token,
+			UserID: userID,
+			Time:   time.Now().UTC(),
 		}
-	} else {
-		refreshTokenRevocation[nextIndex] = newTokenRevocation
-	}
 
-	refreshTokenRevocation[nextIndex] = RefreshTokenRevocation{
-		ID:   token,
-		Time: time.Now().UTC(),
-	}
-
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
+		if dbStructure.RefreshTokenRevocation == nil {
+			dbStructure.RefreshTokenRevocation = map[int]RefreshTokenRevocation{}
+		}
+		dbStructure.RefreshTokenRevocation[nextIndex] = newTokenRevocation
 
-	return nil
+		return nil
+	})
 }
 
 func (db *DB) CheckTokenRevocation(token string) (bool, error) {
@@ -110,141 +140,243 @@ func (db *DB) CheckTokenRevocation(token string) (bool, error) {
 	return false, nil
 }
 
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
-	nextIndex := len(dbStructure.Chirps) + 1
+// CreateChirp stores a new chirp. media holds the URLs of attached images.
+func (db *DB) CreateChirp(body string, authorID int, media ...string) (Chirp, error) {
+	return db.CreateReviewedChirp(body, authorID, nil, media...)
+}
 
-	newChirp := Chirp{
-		ID:       nextIndex,
-		Body:     body,
-		AuthorID: authorID,
-	}
+// ChirpReview files an automatic report for a new chirp so it shows up in the moderation queue
+type ChirpReview struct {
+	Reason  string
+	Details string
+	// Hold keeps the chirp from everyone but its author until a moderator dismissed the report
+	Hold bool
+}
 
-	if dbStructure.Chirps == nil {
-		dbStructure.Chirps = map[int]Chirp{
-			nextIndex: newChirp,
+// CreateReviewedChirp stores a new chirp along with the report asked for by review, if any
+func (db *DB) CreateReviewedChirp(body string, authorID int, review *ChirpReview, media ...string) (Chirp, error) {
+	var newChirp Chirp
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		nextIndex := nextID(dbStructure, "chirps", dbStructure.Chirps)
+		now := time.Now().UTC()
+
+		newChirp = Chirp{
+			ID:        nextIndex,
+			Body:      body,
+			AuthorID:  authorID,
+			Media:     media,
+			CreatedAt: now,
+		}
+
+		if review != nil && review.Hold {
+			newChirp.HeldAt = &now
+		}
+
+		if dbStructure.Chirps == nil {
+			dbStructure.Chirps = map[int]Chirp{}
 		}
-	} else {
 		dbStructure.Chirps[nextIndex] = newChirp
-	}
 
+		if review != nil {
+			_, err := createReport(dbStructure, 0, ReportTargetChirp, nextIndex, review.Reason, review.Details)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Held chirps are announced once they are approved
+		if newChirp.HeldAt == nil {
+			return enqueueWebhookEvent(dbStructure, EventChirpCreated, authorID, newChirp)
+		}
+
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	err = db.writeDB(dbStructure)
+	return newChirp, nil
+}
+
+// GetRecentChirps returns every chirp of the author created since the given time, including hidden and held
+// ones, oldest first
+func (db *DB) GetRecentChirps(authorID int, since time.Time) ([]Chirp, error) {
+	dbStructure, err := db.loadDB()
 	if err != nil {
-		return Chirp{}, err
+		return nil, err
 	}
 
-	return newChirp, nil
+	chirpList := make([]Chirp, 0)
+	for _, v := range dbStructure.Chirps {
+		if v.AuthorID == authorID && v.CreatedAt.After(since) {
+			chirpList = append(chirpList, v)
+		}
+	}
+
+	sort.Slice(chirpList, func(i, j int) bool {
+		return chirpList[i].ID < chirpList[j].ID
+	})
+
+	return chirpList, nil
 }
 
-func (db *DB) GetChirps(authorID int) ([]Chirp, error) {
+// GetChirps returns the chirps viewerID may see, optionally only those of one author. viewerID is 0 for
+// anonymous requests.
+func (db *DB) GetChirps(authorID, viewerID int) ([]Chirp, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 
+	hidden := hiddenAuthors(&dbStructure, viewerID)
 	chirpList := make([]Chirp, 0)
-	i := 0
 
-	if authorID != 0 {
-		for _, v := range dbStructure.Chirps {
-			if v.AuthorID == authorID {
-				chirpList = append(chirpList, v)
-				i++
-			}
+	for _, v := range dbStructure.Chirps {
+		if authorID != 0 && v.AuthorID != authorID {
+			continue
 		}
-	} else {
-		for _, v := range dbStructure.Chirps {
+		if v.HiddenAt == nil && !heldFrom(v, viewerID) && !hidden[v.AuthorID] {
 			chirpList = append(chirpList, v)
-			i++
 		}
 	}
 
 	return chirpList, nil
 }
 
-func (db *DB) GetChirp(id int) (Chirp, error) {
+// GetUserChirps returns every chirp of the user, including hidden ones, e.g. for a data export
+func (db *DB) GetUserChirps(userID int) ([]Chirp, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
-		log.Fatal(err)
-		return Chirp{}, err
+		return nil, err
 	}
 
-	c, ok := dbStructure.Chirps[id]
-	if !ok {
-		return Chirp{}, errors.New("chirp does not exist")
+	chirpList := make([]Chirp, 0)
+	for _, v := range dbStructure.Chirps {
+		if v.AuthorID == userID {
+			chirpList = append(chirpList, v)
+		}
 	}
 
-	return c, nil
+	sort.Slice(chirpList, func(i, j int) bool {
+		return chirpList[i].ID < chirpList[j].ID
+	})
+
+	return chirpList, nil
 }
 
-func (db *DB) DeleteChirps(userID, chirpID int) (int, error) {
+// GetChirp returns the chirp if viewerID may see it, see GetChirps
+func (db *DB) GetChirp(id, viewerID int) (Chirp, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
-		return http.StatusBadRequest, err
+		return Chirp{}, err
 	}
 
-	chirps := dbStructure.Chirps
-	c, ok := chirps[chirpID]
-	if !ok {
-		return http.StatusBadRequest, errors.New(fmt.Sprintf("chirp id", strconv.Itoa(chirpID), "does not exist"))
+	c, ok := dbStructure.Chirps[id]
+	if !ok || c.HiddenAt != nil || heldFrom(c, viewerID) || hiddenAuthors(&dbStructure, viewerID)[c.AuthorID] {
+		return Chirp{}, notFound("chirp does not exist")
 	}
 
-	if c.AuthorID != userID {
-		return http.StatusForbidden, errors.New(fmt.Sprintf("user is not authorised to delete the chirp"))
-	}
+	return c, nil
+}
 
-	// Delete the chirp
-	delete(chirps, chirpID)
+// heldFrom reports whether a held chirp is kept from the viewer. Only the author sees it, anonymous viewers
+// never do, even when the chirp was anonymized after its author was deleted.
+func heldFrom(c Chirp, viewerID int) bool {
+	return c.HeldAt != nil && (viewerID == 0 || c.AuthorID != viewerID)
+}
 
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return http.StatusBadRequest, err
-	}
+func (db *DB) DeleteChirps(userID, chirpID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		c, ok := dbStructure.Chirps[chirpID]
+		if !ok {
+			return notFound("chirp id %d does not exist", chirpID)
+		}
+
+		if c.AuthorID != userID {
+			return forbidden("user is not authorised to delete the chirp")
+		}
 
-	return http.StatusOK, nil
+		// Delete the chirp
+		delete(dbStructure.Chirps, chirpID)
+
+		return enqueueWebhookEvent(dbStructure, EventChirpDeleted, userID, c)
+	})
 }
 
-func (db *DB) CreateUser(email, password string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
+// UpdateChirp replaces the body and media of a chirp written by userID and files the report asked for by review,
+// if any, like CreateReviewedChirp
+func (db *DB) UpdateChirp(userID, chirpID int, body string, media []string, review *ChirpReview) (Chirp, error) {
+	var c Chirp
 
-	users := dbStructure.Users
-	for _, v := range users {
-		if v.Email == email {
-			return User{}, errors.New(fmt.Sprintf("email already exist: %s", email))
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		c, ok = dbStructure.Chirps[chirpID]
+		if !ok {
+			return notFound("chirp id %d does not exist", chirpID)
+		}
+
+		if c.AuthorID != userID {
+			return forbidden("user is not authorised to edit the chirp")
 		}
-	}
 
-	nextIndex := len(users) + 1
+		now := time.Now().UTC()
+		c.Body = body
+		c.Media = media
+		c.EditedAt = &now
+		if review != nil && review.Hold && c.HeldAt == nil {
+			c.HeldAt = &now
+		}
+		dbStructure.Chirps[chirpID] = c
+
+		if review != nil {
+			_, err := createReport(dbStructure, 0, ReportTargetChirp, chirpID, review.Reason, review.Details)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Held chirps are announced once they are approved
+		if c.HeldAt != nil {
+			return nil
+		}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return enqueueWebhookEvent(dbStructure, EventChirpUpdated, userID, c)
+	})
 	if err != nil {
-		return User{}, err
+		return Chirp{}, err
 	}
 
-	u := User{
-		ID:          nextIndex,
-		Email:       email,
-		Password:    string(passwordHash),
-		IsChirpyRed: false,
-	}
+	return c, nil
+}
 
-	if users == nil {
-		users = map[int]User{
-			nextIndex: u,
+// CreateUser stores a new user. The password must already be hashed, see security.Passwords.
+func (db *DB) CreateUser(email, passwordHash string) (User, error) {
+	var u User
+	email = NormalizeEmail(email)
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		if emailTaken(dbStructure.Users, 0, email) {
+			return ErrEmailTaken
 		}
-	} else {
-		users[nextIndex] = u
-	}
 
-	err = db.writeDB(dbStructure)
+		nextIndex := nextID(dbStructure, "users", dbStructure.Users)
+
+		u = User{
+			ID:          nextIndex,
+			Email:       email,
+			Password:    passwordHash,
+			IsChirpyRed: false,
+			CreatedAt:   time.Now().UTC(),
+		}
+
+		if dbStructure.Users == nil {
+			dbStructure.Users = map[int]User{}
+		}
+		dbStructure.Users[nextIndex] = u
+
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -262,86 +394,212 @@ func (db *DB) GetUser(email string) (User, error) {
 
 	userId := -1
 
+	// Users created before emails were normalized may have been stored as they were typed
+	email = NormalizeEmail(email)
 	for k, v := range dbStructure.Users {
-		if v.Email == email {
+		if NormalizeEmail(v.Email) == email {
 			userId = k
 			break
 		}
 	}
 
 	if userId == -1 {
-		return User{}, errors.New(fmt.Sprintf("cannot find user with email: %s", email))
+		return User{}, notFound("cannot find user with email: %s", email)
 	}
 
 	return dbStructure.Users[userId], nil
 }
 
-func (db *DB) UpdateUser(id int, email, password string) (User, error) {
+func (db *DB) GetUserByID(id int) (User, error) {
 	dbStructure, err := db.loadDB()
 	if err != nil {
 		return User{}, err
 	}
 
-	users := dbStructure.Users
+	u, ok := dbStructure.Users[id]
+	if !ok {
+		return User{}, notFound("cannot find user with id: %d", id)
+	}
+
+	return u, nil
+}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// UpdateEmail switches the user to a confirmed new email address
+func (db *DB) UpdateEmail(userID int, email string) (User, error) {
+	var u User
+	email = NormalizeEmail(email)
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		u, ok = dbStructure.Users[userID]
+		if !ok {
+			return notFound("cannot find user with id: %d", userID)
+		}
+
+		if emailTaken(dbStructure.Users, userID, email) {
+			return ErrEmailTaken
+		}
+
+		u.Email = email
+		u.EmailVerified = true
+		u.PendingEmail = ""
+		dbStructure.Users[userID] = u
+
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	users[id] = User{
-		ID:          id,
-		Email:       email,
-		Password:    string(passwordHash),
-		IsChirpyRed: users[id].IsChirpyRed,
-	}
+	return u, nil
+}
+
+// SetPendingEmail records an email change that is waiting for the new address to be confirmed
+func (db *DB) SetPendingEmail(userID int, email string) (User, error) {
+	var u User
+	email = NormalizeEmail(email)
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		u, ok = dbStructure.Users[userID]
+		if !ok {
+			return notFound("cannot find user with id: %d", userID)
+		}
+
+		if emailTaken(dbStructure.Users, userID, email) {
+			return ErrEmailTaken
+		}
 
-	err = db.writeDB(dbStructure)
+		u.PendingEmail = email
+		dbStructure.Users[userID] = u
+
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	return users[id], nil
+	return u, nil
 }
 
-func (db *DB) UpdateChirpyRedStatus(userID int) (int, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return http.StatusBadRequest, err
+// NormalizeEmail is the form emails are stored and compared in, addresses differing only in case or surrounding
+// spaces belong to the same user
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func emailTaken(users map[int]User, userID int, email string) bool {
+	for k, v := range users {
+		if k != userID && NormalizeEmail(v.Email) == NormalizeEmail(email) {
+			return true
+		}
 	}
+	return false
+}
 
-	users := dbStructure.Users
+// UpdatePasswordHash replaces the stored hash, e.g. when it is upgraded to stronger parameters on login
+func (db *DB) UpdatePasswordHash(userID int, passwordHash string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		u, ok := dbStructure.Users[userID]
+		if !ok {
+			return notFound("cannot find user with id: %d", userID)
+		}
 
-	u, ok := users[userID]
-	if !ok {
-		return http.StatusNotFound, err
-	}
+		u.Password = passwordHash
+		dbStructure.Users[userID] = u
+
+		return nil
+	})
+}
+
+// ChangePassword replaces the password and revokes the user's refresh tokens and personal access tokens,
+// so sessions started with the old password end with it
+func (db *DB) ChangePassword(userID int, passwordHash string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		u, ok := dbStructure.Users[userID]
+		if !ok {
+			return notFound("cannot find user with id: %d", userID)
+		}
 
-	users[userID] = User{
-		ID:          u.ID,
-		Email:       u.Email,
-		Password:    u.Password,
-		IsChirpyRed: true,
+		now := time.Now().UTC().Truncate(time.Second)
+		u.Password = passwordHash
+		u.TokensRevokedAt = &now
+		dbStructure.Users[userID] = u
+
+		for id, t := range dbStructure.APITokens {
+			if t.UserID == userID && t.RevokedAt == nil {
+				t.RevokedAt = &now
+				dbStructure.APITokens[id] = t
+			}
+		}
+
+		return nil
+	})
+}
+
+func (db *DB) SetEmailVerified(userID int, email string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		u, ok := dbStructure.Users[userID]
+		if !ok {
+			return notFound("cannot find user with id: %d", userID)
+		}
+
+		if u.Email != email {
+			return conflict("email has changed since the verification was requested")
+		}
+
+		u.EmailVerified = true
+		dbStructure.Users[userID] = u
+
+		return nil
+	})
+}
+
+// nextID never hands out an id twice, even after the record with the highest id was deleted
+func nextID[T any](dbStructure *DBStructure, name string, records map[int]T) int {
+	if dbStructure.Sequences == nil {
+		dbStructure.Sequences = map[string]int{}
 	}
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return http.StatusBadRequest, err
+
+	id := dbStructure.Sequences[name]
+	for k := range records {
+		if k > id {
+			id = k
+		}
 	}
 
-	return http.StatusOK, nil
+	id++
+	dbStructure.Sequences[name] = id
 
+	return id
 }
 
 func (db *DB) ensureDB() error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
 	_, err := os.ReadFile(db.path)
 
 	if errors.Is(err, fs.ErrNotExist) {
 		log.Println("The database.json does not exist! Creating a new file...")
-		err = os.WriteFile(db.path, []byte(""), 0666)
 		err = db.writeDB(DBStructure{
 			Chirps:                 map[int]Chirp{},
 			Users:                  map[int]User{},
 			RefreshTokenRevocation: map[int]RefreshTokenRevocation{},
+			APITokens:              map[int]APIToken{},
+			OAuthClients:           map[string]OAuthClient{},
+			OAuthCodes:             map[string]OAuthCode{},
+			TwoFactor:              map[int]TwoFactor{},
+			UsedActionTokens:       map[string]UsedActionToken{},
+			Sequences:              map[string]int{},
+			DataExports:            map[int]DataExport{},
+			WebhookEvents:          map[string]time.Time{},
+			WebhookDeliveries:      map[int]WebhookDelivery{},
+			WebhookEndpoints:       map[int]WebhookEndpoint{},
+			OutboundWebhooks:       map[int]OutboundWebhook{},
+			Reports:                map[int]Report{},
+			ModerationAudit:        map[int]ModerationAuditEntry{},
+			Relationships:          map[int]Relationship{},
 		})
 	}
 
@@ -352,35 +610,92 @@ func (db *DB) ensureDB() error {
 	return nil
 }
 
+// errUnchanged is returned by the function given to update when there is nothing to save
+var errUnchanged = errors.New("database is unchanged")
+
+// update runs fn on the current data and saves the result. The lock is held from the read to the write, so
+// writes of other requests and background jobs can't be lost in between. Nothing is saved when fn fails.
+func (db *DB) update(fn func(dbStructure *DBStructure) error) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.readDB()
+	db.health.recordRead(err)
+	if err != nil {
+		return err
+	}
+
+	err = fn(&dbStructure)
+	if errors.Is(err, errUnchanged) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return db.writeDB(dbStructure)
+}
+
 func (db *DB) loadDB() (DBStructure, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure, err := db.readDB()
+	db.health.recordRead(err)
+	return dbStructure, err
+}
+
+func (db *DB) readDB() (DBStructure, error) {
 	file, err := os.ReadFile(db.path)
 	if err != nil {
-		log.Fatal(err)
-		return DBStructure{}, err
+		return DBStructure{}, fmt.Errorf("fail to read database: %w", err)
 	}
 
 	dbStructure := DBStructure{}
 
 	err = json.Unmarshal(file, &dbStructure)
 	if err != nil {
-		log.Fatal(err)
-		return DBStructure{}, err
+		return DBStructure{}, fmt.Errorf("%w: %v", ErrCorrupt, err)
 	}
 
 	return dbStructure, nil
 }
 
+// writeDB must be called with the lock held, see update
 func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mux.Lock()
-	defer db.mux.Unlock()
+	file, err := json.MarshalIndent(dbStructure, "", "    ")
+	if err == nil {
+		err = writeFileAtomic(db.path, file)
+		if err != nil {
+			err = fmt.Errorf("fail to write database: %w", err)
+		}
+	}
 
-	file, _ := json.MarshalIndent(dbStructure, "", "    ")
+	db.health.recordWrite(err)
+	return err
+}
 
-	err := os.WriteFile(db.path, file, 0644)
+// writeFileAtomic writes to a temporary file that then replaces path, so readers never see a partial file
+func writeFileAtomic(path string, dat []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	return nil
+	_, err = tmp.Write(dat)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
 }
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(filepath.Join(dir, "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+
+	// Background jobs write while requests do, none of the writes may be lost
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_, err := db.CreateChirp(fmt.Sprintf("chirp %d", i), walt.ID)
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, err := db.PurgeDeletedUsers(time.Now().UTC(), DeleteChirps)
+			if err != nil {
+				t.Error(err)
+			}
+			_, err = db.GetChirps(0, 0)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	chirps, _ := db.GetChirps(0, 0)
+	if len(chirps) != 20 {
+		t.Errorf("Output %d chirps not equal to expected %d", len(chirps), 20)
+	}
+
+	if db.Health().Degraded {
+		t.Errorf("Health should not be degraded: %+v", db.Health())
+	}
+
+	// Temporary files are renamed over the database, none may be left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Output %d files not equal to expected %d", len(entries), 1)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	jesse, _ := db.CreateUser("jesse@breakingbad.com", "hash")
+	db.CreateAPIToken(walt.ID, "cli", "walt-token", []string{"chirps:read"})
+	db.CreateAPIToken(jesse.ID, "cli", "jesse-token", []string{"chirps:read"})
+
+	issuedAt := time.Now().UTC().Truncate(time.Second)
+	err = db.ChangePassword(walt.ID, "new-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ = db.GetUserByID(walt.ID)
+	if walt.Password != "new-hash" {
+		t.Errorf("Output %s not equal to expected %s", walt.Password, "new-hash")
+	}
+	if !walt.TokenRevoked(issuedAt) {
+		t.Errorf("Token issued at %v should be revoked", issuedAt)
+	}
+	if walt.TokenRevoked(issuedAt.Add(time.Second * 2)) {
+		t.Errorf("Token issued after the change should not be revoked")
+	}
+
+	if _, err := db.GetAPITokenByHash("walt-token"); err == nil {
+		t.Errorf("Personal access token of walt should be revoked")
+	}
+	// Other users keep their tokens
+	if _, err := db.GetAPITokenByHash("jesse-token"); err != nil {
+		t.Errorf("Personal access token of jesse should not be revoked: %s", err)
+	}
+}
+
+func TestUserEmailNormalized(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser(" Walt@BreakingBad.com ", "hash")
+	if walt.Email != "walt@breakingbad.com" {
+		t.Errorf("Output %q not equal to expected %q", walt.Email, "walt@breakingbad.com")
+	}
+
+	if _, err := db.CreateUser("WALT@breakingbad.com", "hash"); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("Output %v not equal to expected %v", err, ErrEmailTaken)
+	}
+
+	// Users stored before emails were normalized are still found
+	db.update(func(dbStructure *DBStructure) error {
+		dbStructure.Users[2] = User{ID: 2, Email: "Jesse@BreakingBad.com"}
+		return nil
+	})
+
+	tests := []struct {
+		email    string
+		expected int
+	}{
+		{email: "walt@breakingbad.com", expected: walt.ID},
+		{email: "WALT@BREAKINGBAD.COM", expected: walt.ID},
+		{email: "jesse@breakingbad.com ", expected: 2},
+	}
+
+	for _, test := range tests {
+		u, err := db.GetUser(test.email)
+		if err != nil || u.ID != test.expected {
+			t.Errorf("%s: output %d (%v) not equal to expected %d", test.email, u.ID, err, test.expected)
+		}
+	}
+}
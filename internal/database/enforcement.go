@@ -0,0 +1,182 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	ModerationUnsuspendUser = "unsuspend_user"
+	ModerationShadowBan     = "shadow_ban"
+	ModerationUnshadowBan   = "unshadow_ban"
+
+	// RelationshipBlock hides the chirps of both users from each other
+	RelationshipBlock = "block"
+	// RelationshipMute only hides the muted user's chirps from the user who muted them
+	RelationshipMute = "mute"
+)
+
+// Relationship is a block or mute of TargetID by UserID
+type Relationship struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	TargetID  int       `json:"target_id"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ModerateUser suspends or shadow-bans a user, or lifts it, outside of a report. The decision is added to the
+// moderation audit.
+func (db *DB) ModerateUser(userID, moderatorID int, action, note string) (User, error) {
+	var u User
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		err := applyUserAction(dbStructure, userID, action, now)
+		if err != nil {
+			return err
+		}
+
+		addAuditEntry(dbStructure, 0, moderatorID, action, ReportTargetUser, userID, note, now)
+		u = dbStructure.Users[userID]
+
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	u.Password = ""
+	return u, nil
+}
+
+func applyUserAction(dbStructure *DBStructure, userID int, action string, at time.Time) error {
+	u, ok := dbStructure.Users[userID]
+	if !ok {
+		return notFound("user does not exist")
+	}
+
+	switch action {
+	case ModerationSuspendUser:
+		u.SuspendedAt = &at
+	case ModerationUnsuspendUser:
+		u.SuspendedAt = nil
+	case ModerationShadowBan:
+		u.ShadowBannedAt = &at
+	case ModerationUnshadowBan:
+		u.ShadowBannedAt = nil
+	default:
+		return invalid("invalid moderation action: %s", action)
+	}
+
+	dbStructure.Users[userID] = u
+	return nil
+}
+
+// AddRelationship blocks or mutes targetID for userID. Doing it again keeps the existing one.
+func (db *DB) AddRelationship(userID, targetID int, kind string) (Relationship, error) {
+	if kind != RelationshipBlock && kind != RelationshipMute {
+		return Relationship{}, invalid("invalid relationship: %s", kind)
+	}
+
+	if userID == targetID {
+		return Relationship{}, invalid("you can't %s yourself", kind)
+	}
+
+	var rel Relationship
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[targetID]; !ok {
+			return notFound("user does not exist")
+		}
+
+		for _, v := range dbStructure.Relationships {
+			if v.UserID == userID && v.TargetID == targetID && v.Kind == kind {
+				rel = v
+				return errUnchanged
+			}
+		}
+
+		if dbStructure.Relationships == nil {
+			dbStructure.Relationships = map[int]Relationship{}
+		}
+
+		nextIndex := nextID(dbStructure, "relationships", dbStructure.Relationships)
+		rel = Relationship{
+			ID:        nextIndex,
+			UserID:    userID,
+			TargetID:  targetID,
+			Kind:      kind,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.Relationships[nextIndex] = rel
+
+		return nil
+	})
+	if err != nil {
+		return Relationship{}, err
+	}
+
+	return rel, nil
+}
+
+func (db *DB) RemoveRelationship(userID, targetID int, kind string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		for id, v := range dbStructure.Relationships {
+			if v.UserID == userID && v.TargetID == targetID && v.Kind == kind {
+				delete(dbStructure.Relationships, id)
+				return nil
+			}
+		}
+
+		return notFound("user is not in your %s list", kind)
+	})
+}
+
+// GetRelationships returns who the user blocked or muted, oldest first
+func (db *DB) GetRelationships(userID int, kind string) ([]Relationship, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	relationships := make([]Relationship, 0)
+	for _, v := range dbStructure.Relationships {
+		if v.UserID == userID && v.Kind == kind {
+			relationships = append(relationships, v)
+		}
+	}
+
+	sort.Slice(relationships, func(i, j int) bool {
+		return relationships[i].ID < relationships[j].ID
+	})
+
+	return relationships, nil
+}
+
+// hiddenAuthors returns the authors whose chirps viewerID must not see: suspended users, shadow-banned users
+// other than the viewer, and users blocked either way or muted by the viewer. viewerID is 0 for anonymous
+// requests.
+func hiddenAuthors(dbStructure *DBStructure, viewerID int) map[int]bool {
+	hidden := map[int]bool{}
+
+	for id, u := range dbStructure.Users {
+		if u.SuspendedAt != nil || (u.ShadowBannedAt != nil && id != viewerID) {
+			hidden[id] = true
+		}
+	}
+
+	if viewerID == 0 {
+		return hidden
+	}
+
+	for _, v := range dbStructure.Relationships {
+		if v.UserID == viewerID {
+			hidden[v.TargetID] = true
+		} else if v.TargetID == viewerID && v.Kind == RelationshipBlock {
+			hidden[v.UserID] = true
+		}
+	}
+
+	return hidden
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of errors returned by the database, so callers can tell them apart with errors.Is without knowing
+// about HTTP, e.g. errors.Is(err, database.ErrNotFound).
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrForbidden = errors.New("forbidden")
+	ErrConflict  = errors.New("conflict")
+	// ErrInvalid is a request that can never succeed, e.g. reporting yourself
+	ErrInvalid = errors.New("invalid")
+	// ErrCorrupt means the database file can't be parsed
+	ErrCorrupt = errors.New("database is corrupt")
+)
+
+// Error is an error of one of the kinds above with a message that can be shown to users
+type Error struct {
+	Kind    error
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+func newError(kind error, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+func notFound(format string, a ...interface{}) error {
+	return newError(ErrNotFound, format, a...)
+}
+
+func forbidden(format string, a ...interface{}) error {
+	return newError(ErrForbidden, format, a...)
+}
+
+func conflict(format string, a ...interface{}) error {
+	return newError(ErrConflict, format, a...)
+}
+
+func invalid(format string, a ...interface{}) error {
+	return newError(ErrInvalid, format, a...)
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestErrorKinds(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	jesse, _ := db.CreateUser("jesse@breakingbad.com", "hash")
+	c, _ := db.CreateChirp("say my name", walt.ID)
+
+	_, err = db.CreateUser("walt@breakingbad.com", "hash")
+	if !errors.Is(err, ErrEmailTaken) || !errors.Is(err, ErrConflict) {
+		t.Errorf("Output %v should be ErrEmailTaken and ErrConflict", err)
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{name: "delete missing chirp", err: db.DeleteChirps(walt.ID, c.ID+1), expected: ErrNotFound},
+		{name: "delete chirp of someone else", err: db.DeleteChirps(jesse.ID, c.ID), expected: ErrForbidden},
+		{name: "subscribe missing user", err: db.UpdateChirpyRedStatus(jesse.ID+1, SubscriptionEvent{Type: SubscriptionStarted, At: time.Now()}), expected: ErrNotFound},
+		{name: "block yourself", err: func() error { _, err := db.AddRelationship(walt.ID, walt.ID, RelationshipBlock); return err }(), expected: ErrInvalid},
+	}
+
+	for _, test := range tests {
+		if !errors.Is(test.err, test.expected) {
+			t.Errorf("%s: output %v is not %v", test.name, test.err, test.expected)
+		}
+	}
+
+	_, err = db.UpdateChirp(jesse.ID, c.ID, "yeah science", nil, nil)
+	if !errors.Is(err, ErrForbidden) || err.Error() != "user is not authorised to edit the chirp" {
+		t.Errorf("Output %v not equal to expected forbidden error", err)
+	}
+}
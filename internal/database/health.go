@@ -0,0 +1,67 @@
+package database
+
+import (
+	"sync"
+	"time"
+)
+
+// Health is the state of the storage, based on the most recent read and write of the database file. It is
+// degraded while the last read or the last write failed.
+type Health struct {
+	Degraded    bool       `json:"degraded"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	// ReadFailures and WriteFailures count the failures in a row
+	ReadFailures  int `json:"read_failures"`
+	WriteFailures int `json:"write_failures"`
+}
+
+type healthTracker struct {
+	mux    sync.Mutex
+	health Health
+}
+
+func (t *healthTracker) record(failures *int, err error) {
+	if err == nil {
+		*failures = 0
+	} else {
+		now := time.Now().UTC()
+		*failures++
+		t.health.LastError = err.Error()
+		t.health.LastErrorAt = &now
+	}
+
+	t.health.Degraded = t.health.ReadFailures > 0 || t.health.WriteFailures > 0
+}
+
+func (t *healthTracker) recordRead(err error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.record(&t.health.ReadFailures, err)
+}
+
+func (t *healthTracker) recordWrite(err error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.record(&t.health.WriteFailures, err)
+}
+
+// Health reports the state of the storage
+func (db *DB) Health() Health {
+	db.health.mux.Lock()
+	defer db.health.mux.Unlock()
+	return db.health.health
+}
+
+// Ping reads the database file to check that the storage is working, which also updates Health. After a failed
+// write it writes the file back as well, so Health recovers with the storage rather than on the next change.
+func (db *DB) Ping() error {
+	if db.Health().WriteFailures == 0 {
+		_, err := db.loadDB()
+		return err
+	}
+
+	return db.update(func(dbStructure *DBStructure) error {
+		return nil
+	})
+}
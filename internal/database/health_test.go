@@ -0,0 +1,72 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	if db.Health().Degraded {
+		t.Errorf("Health should not be degraded: %+v", db.Health())
+	}
+
+	valid, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte("{not json"), 0644)
+
+	_, err = db.GetChirps(0, 0)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Output %v should be ErrCorrupt", err)
+	}
+	_, err = db.GetUserByID(walt.ID)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Output %v should be a storage error", err)
+	}
+
+	health := db.Health()
+	if !health.Degraded || health.ReadFailures != 2 || health.LastErrorAt == nil {
+		t.Errorf("Health should be degraded after 2 read failures: %+v", health)
+	}
+
+	os.WriteFile(path, valid, 0644)
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if db.Health().Degraded {
+		t.Errorf("Health should have recovered: %+v", db.Health())
+	}
+}
+
+func TestPingAfterWriteFailure(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+
+	// The disk was full for a moment, nobody wrote since it was freed
+	db.health.recordWrite(errors.New("no space left on device"))
+	if !db.Health().Degraded {
+		t.Errorf("Health should be degraded after a write failure: %+v", db.Health())
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if health := db.Health(); health.Degraded || health.WriteFailures != 0 {
+		t.Errorf("Health should have recovered: %+v", health)
+	}
+
+	if _, err := db.GetUserByID(walt.ID); err != nil {
+		t.Errorf("Ping must not change the data: %s", err)
+	}
+}
@@ -0,0 +1,350 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	ReportTargetChirp = "chirp"
+	ReportTargetUser  = "user"
+
+	ReportOpen     = "open"
+	ReportClaimed  = "claimed"
+	ReportResolved = "resolved"
+
+	// ReportAutomatic is used for chirps flagged by the profanity filter rather than by a user
+	ReportAutomatic = "automatic"
+	// ReportSpam is used for chirps held by the spam detector, it is also a reason users can pick
+	ReportSpam = "spam"
+
+	ModerationClaim       = "claim"
+	ModerationHideChirp   = "hide_chirp"
+	ModerationSuspendUser = "suspend_user"
+	ModerationDismiss     = "dismiss"
+)
+
+// ReportReasons are the categories users can pick from
+var ReportReasons = []string{ReportSpam, "harassment", "hate", "violence", "sexual", "impersonation", "other"}
+
+var (
+	ErrAlreadyReported  = conflict("you have already reported this")
+	ErrReportClaimed    = conflict("report is claimed by another moderator")
+	ErrReportResolved   = conflict("report is already resolved")
+	ErrInvalidModAction = invalid("action does not apply to the report")
+)
+
+type Report struct {
+	ID         int    `json:"id"`
+	TargetType string `json:"target_type"`
+	TargetID   int    `json:"target_id"`
+	// ReporterID is 0 for automatic reports
+	ReporterID int    `json:"reporter_id"`
+	Reason     string `json:"reason"`
+	Details    string `json:"details,omitempty"`
+	// Snapshot is the chirp body when it was reported, in case it is edited or deleted afterwards
+	Snapshot   string     `json:"snapshot,omitempty"`
+	Status     string     `json:"status"`
+	ClaimedBy  int        `json:"claimed_by,omitempty"`
+	ClaimedAt  *time.Time `json:"claimed_at,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+	ResolvedBy int        `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ModerationAuditEntry records a moderator decision. Entries are never changed or deleted.
+type ModerationAuditEntry struct {
+	ID          int       `json:"id"`
+	ReportID    int       `json:"report_id"`
+	ModeratorID int       `json:"moderator_id"`
+	Action      string    `json:"action"`
+	TargetType  string    `json:"target_type"`
+	TargetID    int       `json:"target_id"`
+	Note        string    `json:"note,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+func IsValidReportReason(reason string) bool {
+	for _, r := range ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateReport files a report against a chirp or a user. Reporting the same thing twice while the first
+// report is unresolved returns ErrAlreadyReported.
+func (db *DB) CreateReport(reporterID int, targetType string, targetID int, reason, details string) (Report, error) {
+	var r Report
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var err error
+		r, err = createReport(dbStructure, reporterID, targetType, targetID, reason, details)
+		return err
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	return r, nil
+}
+
+func createReport(dbStructure *DBStructure, reporterID int, targetType string, targetID int, reason, details string) (Report, error) {
+	snapshot := ""
+	switch targetType {
+	case ReportTargetChirp:
+		c, ok := dbStructure.Chirps[targetID]
+		if !ok || c.HiddenAt != nil {
+			return Report{}, notFound("chirp does not exist")
+		}
+		if c.AuthorID == reporterID {
+			return Report{}, invalid("you can't report your own chirp")
+		}
+		snapshot = c.Body
+	case ReportTargetUser:
+		if _, ok := dbStructure.Users[targetID]; !ok {
+			return Report{}, notFound("user does not exist")
+		}
+		if targetID == reporterID {
+			return Report{}, invalid("you can't report yourself")
+		}
+	default:
+		return Report{}, invalid("invalid report target: %s", targetType)
+	}
+
+	for _, v := range dbStructure.Reports {
+		if v.ReporterID == reporterID && v.TargetType == targetType && v.TargetID == targetID && v.Status != ReportResolved {
+			return Report{}, ErrAlreadyReported
+		}
+	}
+
+	if dbStructure.Reports == nil {
+		dbStructure.Reports = map[int]Report{}
+	}
+
+	nextIndex := nextID(dbStructure, "reports", dbStructure.Reports)
+
+	r := Report{
+		ID:         nextIndex,
+		TargetType: targetType,
+		TargetID:   targetID,
+		ReporterID: reporterID,
+		Reason:     reason,
+		Details:    details,
+		Snapshot:   snapshot,
+		Status:     ReportOpen,
+		CreatedAt:  time.Now().UTC(),
+	}
+	dbStructure.Reports[nextIndex] = r
+
+	return r, nil
+}
+
+func (db *DB) GetReport(id int) (Report, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return Report{}, err
+	}
+
+	r, ok := dbStructure.Reports[id]
+	if !ok {
+		return Report{}, notFound("report does not exist")
+	}
+
+	return r, nil
+}
+
+// GetReports returns the moderation queue oldest first, optionally filtered by status and target type
+func (db *DB) GetReports(status, targetType string, limit int) ([]Report, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]Report, 0)
+	for _, v := range dbStructure.Reports {
+		if (status != "" && v.Status != status) || (targetType != "" && v.TargetType != targetType) {
+			continue
+		}
+		reports = append(reports, v)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].ID < reports[j].ID
+	})
+
+	if limit > 0 && len(reports) > limit {
+		reports = reports[:limit]
+	}
+
+	return reports, nil
+}
+
+// ClaimReport assigns an unresolved report to the moderator so others don't work on it at the same time
+func (db *DB) ClaimReport(id, moderatorID int) (Report, error) {
+	var r Report
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		r, ok = dbStructure.Reports[id]
+		if !ok {
+			return notFound("report does not exist")
+		}
+
+		if r.Status == ReportResolved {
+			return ErrReportResolved
+		}
+
+		if r.Status == ReportClaimed && r.ClaimedBy != moderatorID {
+			return ErrReportClaimed
+		}
+
+		now := time.Now().UTC()
+		r.Status = ReportClaimed
+		r.ClaimedBy = moderatorID
+		r.ClaimedAt = &now
+		dbStructure.Reports[id] = r
+
+		addAuditEntry(dbStructure, r.ID, moderatorID, ModerationClaim, r.TargetType, r.TargetID, "", now)
+
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	return r, nil
+}
+
+// ResolveReport applies the moderator's decision and resolves the report. Hiding only applies to chirps,
+// suspending or shadow-banning on a chirp report applies to its author, and dismissing the last open report on
+// a held chirp releases it.
+func (db *DB) ResolveReport(id, moderatorID int, action, note string) (Report, error) {
+	var r Report
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		r, ok = dbStructure.Reports[id]
+		if !ok {
+			return notFound("report does not exist")
+		}
+
+		if r.Status == ReportResolved {
+			return ErrReportResolved
+		}
+
+		if r.Status == ReportClaimed && r.ClaimedBy != moderatorID {
+			return ErrReportClaimed
+		}
+
+		now := time.Now().UTC()
+
+		switch action {
+		case ModerationHideChirp:
+			c, ok := dbStructure.Chirps[r.TargetID]
+			if r.TargetType != ReportTargetChirp || !ok {
+				return ErrInvalidModAction
+			}
+			c.HiddenAt = &now
+			dbStructure.Chirps[c.ID] = c
+		case ModerationSuspendUser, ModerationShadowBan:
+			userID := r.TargetID
+			if r.TargetType == ReportTargetChirp {
+				c, ok := dbStructure.Chirps[r.TargetID]
+				if !ok {
+					return ErrInvalidModAction
+				}
+				userID = c.AuthorID
+			}
+
+			if applyUserAction(dbStructure, userID, action, now) != nil {
+				return ErrInvalidModAction
+			}
+		case ModerationDismiss:
+			// Dismissing the last report on a held chirp approves it
+			c, ok := dbStructure.Chirps[r.TargetID]
+			if r.TargetType == ReportTargetChirp && ok && c.HeldAt != nil && !hasUnresolvedReport(dbStructure, r.TargetType, r.TargetID, r.ID) {
+				c.HeldAt = nil
+				dbStructure.Chirps[c.ID] = c
+
+				err := enqueueWebhookEvent(dbStructure, EventChirpCreated, c.AuthorID, c)
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			return invalid("invalid moderation action: %s", action)
+		}
+
+		r.Status = ReportResolved
+		r.Resolution = action
+		r.ResolvedBy = moderatorID
+		r.ResolvedAt = &now
+		dbStructure.Reports[id] = r
+
+		addAuditEntry(dbStructure, r.ID, moderatorID, action, r.TargetType, r.TargetID, note, now)
+
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	return r, nil
+}
+
+// hasUnresolvedReport reports whether the target has a report other than exceptID that is not resolved yet
+func hasUnresolvedReport(dbStructure *DBStructure, targetType string, targetID, exceptID int) bool {
+	for _, r := range dbStructure.Reports {
+		if r.ID != exceptID && r.TargetType == targetType && r.TargetID == targetID && r.Status != ReportResolved {
+			return true
+		}
+	}
+	return false
+}
+
+// addAuditEntry records a decision, reportID is 0 for actions taken without a report
+func addAuditEntry(dbStructure *DBStructure, reportID, moderatorID int, action, targetType string, targetID int, note string, at time.Time) {
+	if dbStructure.ModerationAudit == nil {
+		dbStructure.ModerationAudit = map[int]ModerationAuditEntry{}
+	}
+
+	nextIndex := nextID(dbStructure, "moderation_audit", dbStructure.ModerationAudit)
+	dbStructure.ModerationAudit[nextIndex] = ModerationAuditEntry{
+		ID:          nextIndex,
+		ReportID:    reportID,
+		ModeratorID: moderatorID,
+		Action:      action,
+		TargetType:  targetType,
+		TargetID:    targetID,
+		Note:        note,
+		At:          at,
+	}
+}
+
+// GetModerationAudit returns the decisions on a report, or on every report when reportID is 0, newest first
+func (db *DB) GetModerationAudit(reportID, limit int) ([]ModerationAuditEntry, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ModerationAuditEntry, 0)
+	for _, v := range dbStructure.ModerationAudit {
+		if reportID == 0 || v.ReportID == reportID {
+			entries = append(entries, v)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID > entries[j].ID
+	})
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries, nil
+}
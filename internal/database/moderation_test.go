@@ -0,0 +1,230 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModerationQueue(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	jesse, _ := db.CreateUser("jesse@breakingbad.com", "hash")
+	chirp, _ := db.CreateChirp("Say my name", walt.ID)
+
+	report, err := db.CreateReport(jesse.ID, ReportTargetChirp, chirp.ID, "harassment", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.CreateReport(jesse.ID, ReportTargetChirp, chirp.ID, "spam", ""); !errors.Is(err, ErrAlreadyReported) {
+		t.Errorf("Output %v not equal to expected %v", err, ErrAlreadyReported)
+	}
+
+	if _, err := db.ClaimReport(report.ID, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the moderator who claimed the report can resolve it
+	if _, err := db.ResolveReport(report.ID, 101, ModerationDismiss, ""); !errors.Is(err, ErrReportClaimed) {
+		t.Errorf("Output %v not equal to expected %v", err, ErrReportClaimed)
+	}
+
+	if _, err := db.ResolveReport(report.ID, 100, ModerationHideChirp, "abusive"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GetChirp(chirp.ID, 0); err == nil {
+		t.Errorf("Chirp %d should be hidden", chirp.ID)
+	}
+
+	if _, err := db.ResolveReport(report.ID, 100, ModerationDismiss, ""); !errors.Is(err, ErrReportResolved) {
+		t.Errorf("Output %v not equal to expected %v", err, ErrReportResolved)
+	}
+
+	entries, _ := db.GetModerationAudit(report.ID, 0)
+	if len(entries) != 2 || entries[0].Action != ModerationHideChirp || entries[1].Action != ModerationClaim {
+		t.Errorf("Unexpected audit trail %v", entries)
+	}
+}
+
+func TestChirpVisibility(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	jesse, _ := db.CreateUser("jesse@breakingbad.com", "hash")
+	hank, _ := db.CreateUser("hank@breakingbad.com", "hash")
+	db.CreateChirp("Say my name", walt.ID)
+	db.CreateChirp("Yeah science", jesse.ID)
+	db.CreateChirp("Minerals", hank.ID)
+
+	db.ModerateUser(walt.ID, 100, ModerationShadowBan, "")
+	db.AddRelationship(jesse.ID, hank.ID, RelationshipBlock)
+
+	tests := []struct {
+		viewerID int
+		expected int
+	}{
+		// Shadow-banned chirps are only visible to their author
+		{viewerID: 0, expected: 2},
+		{viewerID: walt.ID, expected: 3},
+		// Blocks apply both ways
+		{viewerID: jesse.ID, expected: 1},
+		{viewerID: hank.ID, expected: 1},
+	}
+
+	for _, test := range tests {
+		chirps, _ := db.GetChirps(0, test.viewerID)
+		if len(chirps) != test.expected {
+			t.Errorf("Viewer %d: output %d not equal to expected %d", test.viewerID, len(chirps), test.expected)
+		}
+	}
+
+	db.ModerateUser(jesse.ID, 100, ModerationSuspendUser, "")
+	if chirps, _ := db.GetChirps(jesse.ID, 0); len(chirps) != 0 {
+		t.Errorf("Chirps of suspended user %d should be hidden", jesse.ID)
+	}
+
+	entries, _ := db.GetModerationAudit(0, 0)
+	if len(entries) != 2 {
+		t.Errorf("Output %d not equal to expected %d", len(entries), 2)
+	}
+}
+
+func TestHeldChirp(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	chirp, err := db.CreateReviewedChirp("Buy blue crystals", walt.ID, &ChirpReview{Reason: ReportSpam, Hold: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GetChirp(chirp.ID, 0); err == nil {
+		t.Errorf("Held chirp %d should only be visible to its author", chirp.ID)
+	}
+	if _, err := db.GetChirp(chirp.ID, walt.ID); err != nil {
+		t.Errorf("Held chirp %d should be visible to its author", chirp.ID)
+	}
+
+	reports, _ := db.GetReports(ReportOpen, ReportTargetChirp, 0)
+	if len(reports) != 1 || reports[0].Reason != ReportSpam {
+		t.Fatalf("Unexpected moderation queue %v", reports)
+	}
+
+	if _, err := db.ResolveReport(reports[0].ID, 100, ModerationDismiss, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetChirp(chirp.ID, 0); err != nil {
+		t.Errorf("Chirp %d should be visible once approved", chirp.ID)
+	}
+}
+
+func TestEditHeldChirp(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	endpoint, _ := db.CreateWebhookEndpoint(walt.ID, "https://hooks.example/walt", []string{EventChirpCreated, EventChirpUpdated}, "secret")
+	chirp, _ := db.CreateChirp("I am the one who knocks", walt.ID)
+
+	// The chirp is edited into spam after it was published
+	edited, err := db.UpdateChirp(walt.ID, chirp.ID, "Buy blue crystals", nil, &ChirpReview{Reason: ReportSpam, Hold: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.HeldAt == nil {
+		t.Errorf("Edited chirp %d should be held", chirp.ID)
+	}
+	if _, err := db.GetChirp(chirp.ID, 0); err == nil {
+		t.Errorf("Held chirp %d should only be visible to its author", chirp.ID)
+	}
+
+	// Edits of a held chirp are not announced either
+	db.UpdateChirp(walt.ID, chirp.ID, "Buy blue crystals now", nil, nil)
+
+	deliveries, _ := db.GetOutboundWebhooks(endpoint.ID, "", 0)
+	if len(deliveries) != 1 || deliveries[0].Event != EventChirpCreated {
+		t.Errorf("Output %v not equal to expected one %s event", deliveries, EventChirpCreated)
+	}
+
+	reports, _ := db.GetReports(ReportOpen, ReportTargetChirp, 0)
+	if len(reports) != 1 || reports[0].TargetID != chirp.ID {
+		t.Errorf("Unexpected moderation queue %v", reports)
+	}
+}
+
+func TestHeldChirpOfDeletedUser(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	chirp, _ := db.CreateReviewedChirp("Buy blue crystals", walt.ID, &ChirpReview{Reason: ReportSpam, Hold: true})
+
+	// Anonymized chirps have no author, they must not become visible to anonymous viewers
+	db.ScheduleUserDeletion(walt.ID, time.Now().Add(-time.Minute))
+	db.PurgeDeletedUsers(time.Now().UTC(), AnonymizeChirps)
+
+	if _, err := db.GetChirp(chirp.ID, 0); err == nil {
+		t.Errorf("Held chirp %d should not be visible once its author was deleted", chirp.ID)
+	}
+	if chirps, _ := db.GetChirps(0, 0); len(chirps) != 0 {
+		t.Errorf("Output %d not equal to expected %d", len(chirps), 0)
+	}
+}
+
+func TestDismissHeldChirp(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	jesse, _ := db.CreateUser("jesse@breakingbad.com", "hash")
+	chirp, _ := db.CreateChirp("I am the one who knocks", walt.ID)
+	userReport, _ := db.CreateReport(jesse.ID, ReportTargetChirp, chirp.ID, "harassment", "")
+	db.UpdateChirp(walt.ID, chirp.ID, "Buy blue crystals", nil, &ChirpReview{Reason: ReportSpam, Hold: true})
+
+	// The queue is oldest first, the spam report was filed by the edit
+	reports, _ := db.GetReports(ReportOpen, ReportTargetChirp, 0)
+	if len(reports) != 2 || reports[0].ID != userReport.ID {
+		t.Fatalf("Unexpected moderation queue %v", reports)
+	}
+
+	tests := []struct {
+		name     string
+		reportID int
+		visible  bool
+	}{
+		// The chirp stays held while another report on it is open
+		{name: "spam report", reportID: reports[1].ID, visible: false},
+		{name: "user report", reportID: userReport.ID, visible: true},
+	}
+
+	for _, test := range tests {
+		_, err := db.ResolveReport(test.reportID, 100, ModerationDismiss, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.GetChirp(chirp.ID, 0)
+		if visible := err == nil; visible != test.visible {
+			t.Errorf("%s: output %v not equal to expected %v", test.name, visible, test.visible)
+		}
+	}
+}
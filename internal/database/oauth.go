@@ -0,0 +1,121 @@
+package database
+
+import (
+	"time"
+)
+
+type OAuthClient struct {
+	ID           string    `json:"id"`
+	SecretHash   string    `json:"secret_hash,omitempty"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	OwnerID      int       `json:"owner_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// IsConfidential reports whether the client was issued a secret
+func (c OAuthClient) IsConfidential() bool {
+	return c.SecretHash != ""
+}
+
+func (c OAuthClient) HasRedirectURI(uri string) bool {
+	for _, v := range c.RedirectURIs {
+		if v == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthCode is an authorization code issued by the authorization endpoint, keyed by its hash
+type OAuthCode struct {
+	ClientID      string    `json:"client_id"`
+	UserID        int       `json:"user_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scope         string    `json:"scope"`
+	Nonce         string    `json:"nonce,omitempty"`
+	CodeChallenge string    `json:"code_challenge"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+func (db *DB) CreateOAuthClient(client OAuthClient) (OAuthClient, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		if dbStructure.OAuthClients == nil {
+			dbStructure.OAuthClients = map[string]OAuthClient{}
+		}
+
+		if _, ok := dbStructure.OAuthClients[client.ID]; ok {
+			return conflict("client id already exist")
+		}
+
+		client.CreatedAt = time.Now().UTC()
+		dbStructure.OAuthClients[client.ID] = client
+
+		return nil
+	})
+	if err != nil {
+		return OAuthClient{}, err
+	}
+
+	return client, nil
+}
+
+func (db *DB) GetOAuthClient(clientID string) (OAuthClient, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return OAuthClient{}, err
+	}
+
+	c, ok := dbStructure.OAuthClients[clientID]
+	if !ok {
+		return OAuthClient{}, notFound("client does not exist")
+	}
+
+	return c, nil
+}
+
+func (db *DB) CreateOAuthCode(codeHash string, code OAuthCode) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if dbStructure.OAuthCodes == nil {
+			dbStructure.OAuthCodes = map[string]OAuthCode{}
+		}
+
+		// Drop expired codes so the table doesn't grow forever
+		now := time.Now().UTC()
+		for k, v := range dbStructure.OAuthCodes {
+			if v.ExpiresAt.Before(now) {
+				delete(dbStructure.OAuthCodes, k)
+			}
+		}
+
+		dbStructure.OAuthCodes[codeHash] = code
+
+		return nil
+	})
+}
+
+// ConsumeOAuthCode returns the authorization code and deletes it, so each code can only be redeemed once
+func (db *DB) ConsumeOAuthCode(codeHash string) (OAuthCode, error) {
+	var c OAuthCode
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		c, ok = dbStructure.OAuthCodes[codeHash]
+		if !ok {
+			return notFound("authorization code does not exist")
+		}
+
+		delete(dbStructure.OAuthCodes, codeHash)
+
+		return nil
+	})
+	if err != nil {
+		return OAuthCode{}, err
+	}
+
+	if c.ExpiresAt.Before(time.Now().UTC()) {
+		return OAuthCode{}, invalid("authorization code has expired")
+	}
+
+	return c, nil
+}
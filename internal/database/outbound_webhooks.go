@@ -0,0 +1,334 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	EventChirpCreated   = "chirp.created"
+	EventChirpUpdated   = "chirp.updated"
+	EventChirpDeleted   = "chirp.deleted"
+	EventUserUpgraded   = "user.upgraded"
+	EventUserDowngraded = "user.downgraded"
+
+	OutboundPending   = "pending"
+	OutboundDelivered = "delivered"
+	OutboundDead      = "dead"
+)
+
+var OutboundEvents = []string{EventChirpCreated, EventChirpUpdated, EventChirpDeleted, EventUserUpgraded, EventUserDowngraded}
+
+// WebhookEndpoint receives the events it subscribed to. Endpoints registered by a user only receive events
+// about that user and their chirps, endpoints registered by an admin (OwnerID 0) receive all of them.
+type WebhookEndpoint struct {
+	ID        int       `json:"id"`
+	OwnerID   int       `json:"owner_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// OutboundWebhook is an event queued for one endpoint. It is created in the same write as the change that
+// caused it, so events aren't lost if the server stops before they are sent.
+type OutboundWebhook struct {
+	ID            int              `json:"id"`
+	EndpointID    int              `json:"endpoint_id"`
+	EventID       string           `json:"event_id"`
+	Event         string           `json:"event"`
+	Payload       json.RawMessage  `json:"payload"`
+	Status        string           `json:"status"`
+	Attempts      []WebhookAttempt `json:"attempts"`
+	NextAttemptAt *time.Time       `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	DeliveredAt   *time.Time       `json:"delivered_at,omitempty"`
+}
+
+func IsValidOutboundEvent(event string) bool {
+	for _, e := range OutboundEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (e WebhookEndpoint) subscribes(event string, ownerID int) bool {
+	if e.OwnerID != 0 && e.OwnerID != ownerID {
+		return false
+	}
+
+	for _, v := range e.Events {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DB) CreateWebhookEndpoint(ownerID int, url string, events []string, secret string) (WebhookEndpoint, error) {
+	var e WebhookEndpoint
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		if dbStructure.WebhookEndpoints == nil {
+			dbStructure.WebhookEndpoints = map[int]WebhookEndpoint{}
+		}
+
+		nextIndex := nextID(dbStructure, "webhook_endpoints", dbStructure.WebhookEndpoints)
+
+		e = WebhookEndpoint{
+			ID:        nextIndex,
+			OwnerID:   ownerID,
+			URL:       url,
+			Events:    events,
+			Secret:    secret,
+			CreatedAt: time.Now().UTC(),
+		}
+		dbStructure.WebhookEndpoints[nextIndex] = e
+
+		return nil
+	})
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+
+	return e, nil
+}
+
+// GetWebhookEndpoint returns the endpoint including its signing secret
+func (db *DB) GetWebhookEndpoint(id int) (WebhookEndpoint, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+
+	e, ok := dbStructure.WebhookEndpoints[id]
+	if !ok {
+		return WebhookEndpoint{}, notFound("webhook endpoint does not exist")
+	}
+
+	return e, nil
+}
+
+func (db *DB) GetWebhookEndpoints(ownerID int) ([]WebhookEndpoint, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]WebhookEndpoint, 0)
+	for _, v := range dbStructure.WebhookEndpoints {
+		if v.OwnerID == ownerID {
+			v.Secret = ""
+			endpoints = append(endpoints, v)
+		}
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].ID < endpoints[j].ID
+	})
+
+	return endpoints, nil
+}
+
+// DeleteWebhookEndpoint removes the endpoint along with its queued events and delivery logs
+func (db *DB) DeleteWebhookEndpoint(ownerID, id int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		e, ok := dbStructure.WebhookEndpoints[id]
+		if !ok || e.OwnerID != ownerID {
+			return notFound("webhook endpoint does not exist")
+		}
+
+		deleteWebhookEndpoint(dbStructure, id)
+
+		return nil
+	})
+}
+
+func deleteWebhookEndpoint(dbStructure *DBStructure, id int) {
+	for k, v := range dbStructure.OutboundWebhooks {
+		if v.EndpointID == id {
+			delete(dbStructure.OutboundWebhooks, k)
+		}
+	}
+
+	delete(dbStructure.WebhookEndpoints, id)
+}
+
+// GetOutboundWebhooks returns the newest deliveries first, optionally filtered by endpoint and status
+func (db *DB) GetOutboundWebhooks(endpointID int, status string, limit int) ([]OutboundWebhook, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]OutboundWebhook, 0)
+	for _, v := range dbStructure.OutboundWebhooks {
+		if (endpointID != 0 && v.EndpointID != endpointID) || (status != "" && v.Status != status) {
+			continue
+		}
+		deliveries = append(deliveries, v)
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries, nil
+}
+
+// DueOutboundWebhooks returns pending deliveries whose next attempt is due, oldest first
+func (db *DB) DueOutboundWebhooks(now time.Time, limit int) ([]OutboundWebhook, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	due := make([]OutboundWebhook, 0)
+	for _, v := range dbStructure.OutboundWebhooks {
+		if v.Status == OutboundPending && v.NextAttemptAt != nil && !v.NextAttemptAt.After(now) {
+			due = append(due, v)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].ID < due[j].ID
+	})
+
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+// RecordOutboundAttempt logs an attempt. A failed attempt without a next attempt time moves the delivery to
+// the dead letters.
+func (db *DB) RecordOutboundAttempt(id int, attempt WebhookAttempt, delivered bool, nextAttemptAt *time.Time) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		// The endpoint may have been deleted while the request was in flight
+		v, ok := dbStructure.OutboundWebhooks[id]
+		if !ok {
+			return errUnchanged
+		}
+
+		v.Attempts = append(v.Attempts, attempt)
+		v.NextAttemptAt = nextAttemptAt
+
+		switch {
+		case delivered:
+			v.Status = OutboundDelivered
+			v.DeliveredAt = &attempt.At
+			v.NextAttemptAt = nil
+		case nextAttemptAt == nil:
+			v.Status = OutboundDead
+		}
+
+		dbStructure.OutboundWebhooks[id] = v
+
+		return nil
+	})
+}
+
+// RetryOutboundWebhook puts a dead delivery back in the queue
+func (db *DB) RetryOutboundWebhook(id int) (OutboundWebhook, error) {
+	var v OutboundWebhook
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		v, ok = dbStructure.OutboundWebhooks[id]
+		if !ok || v.Status != OutboundDead {
+			return notFound("dead webhook delivery does not exist")
+		}
+
+		now := time.Now().UTC()
+		v.Status = OutboundPending
+		v.NextAttemptAt = &now
+		dbStructure.OutboundWebhooks[id] = v
+
+		return nil
+	})
+	if err != nil {
+		return OutboundWebhook{}, err
+	}
+
+	return v, nil
+}
+
+// enqueueWebhookEvent queues the event for every subscribed endpoint. ownerID is the user the event is about.
+// It must be called within update so the event is stored together with the change.
+func enqueueWebhookEvent(dbStructure *DBStructure, event string, ownerID int, data any) error {
+	now := time.Now().UTC()
+
+	endpoints := make([]WebhookEndpoint, 0)
+	for _, e := range dbStructure.WebhookEndpoints {
+		if e.subscribes(event, ownerID) {
+			endpoints = append(endpoints, e)
+		}
+	}
+
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].ID < endpoints[j].ID
+	})
+
+	eventID := fmt.Sprintf("evt_%d", nextID(dbStructure, "outbound_events", map[int]struct{}{}))
+
+	payload, err := json.Marshal(struct {
+		ID        string    `json:"id"`
+		Event     string    `json:"event"`
+		CreatedAt time.Time `json:"created_at"`
+		Data      any       `json:"data"`
+	}{
+		ID:        eventID,
+		Event:     event,
+		CreatedAt: now,
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	if dbStructure.OutboundWebhooks == nil {
+		dbStructure.OutboundWebhooks = map[int]OutboundWebhook{}
+	}
+
+	for id, v := range dbStructure.OutboundWebhooks {
+		if v.Status == OutboundDelivered && now.Sub(v.CreatedAt) > webhookRetention {
+			delete(dbStructure.OutboundWebhooks, id)
+		}
+	}
+
+	for _, e := range endpoints {
+		nextIndex := nextID(dbStructure, "outbound_webhooks", dbStructure.OutboundWebhooks)
+		dbStructure.OutboundWebhooks[nextIndex] = OutboundWebhook{
+			ID:            nextIndex,
+			EndpointID:    e.ID,
+			EventID:       eventID,
+			Event:         event,
+			Payload:       payload,
+			Status:        OutboundPending,
+			Attempts:      []WebhookAttempt{},
+			NextAttemptAt: &now,
+			CreatedAt:     now,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	SubscriptionActive    = "active"
+	SubscriptionPastDue   = "past_due"
+	SubscriptionCancelled = "cancelled"
+	SubscriptionExpired   = "expired"
+)
+
+type SubscriptionEventType string
+
+const (
+	SubscriptionStarted       SubscriptionEventType = "started"
+	SubscriptionRenewed       SubscriptionEventType = "renewed"
+	SubscriptionPaymentFailed SubscriptionEventType = "payment_failed"
+	SubscriptionCancelledBy   SubscriptionEventType = "cancelled"
+	SubscriptionDowngraded    SubscriptionEventType = "downgraded"
+)
+
+type SubscriptionEvent struct {
+	Type SubscriptionEventType
+	At   time.Time
+	// ExpiresAt is optional, the end of the paid period according to the payment provider. Without it the
+	// subscription has no end date and lasts until it is downgraded.
+	ExpiresAt *time.Time
+}
+
+// ChirpyRedActive reports whether the user currently has Chirpy Red, taking expiry into account
+func (u User) ChirpyRedActive(now time.Time) bool {
+	return u.IsChirpyRed && (u.ChirpyRedExpiresAt == nil || now.Before(*u.ChirpyRedExpiresAt))
+}
+
+// UpdateChirpyRedStatus applies a subscription lifecycle event to the user. Cancelled and past due
+// subscriptions keep Chirpy Red until the paid period is over; a downgrade removes it right away.
+func (db *DB) UpdateChirpyRedStatus(userID int, event SubscriptionEvent) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		users := dbStructure.Users
+
+		u, ok := users[userID]
+		if !ok {
+			return notFound("cannot find user with id: %d", userID)
+		}
+
+		at := event.At.UTC()
+		var expiresAt *time.Time
+		if event.ExpiresAt != nil {
+			t := event.ExpiresAt.UTC()
+			expiresAt = &t
+		}
+
+		wasActive := u.ChirpyRedActive(at)
+
+		switch event.Type {
+		case SubscriptionStarted:
+			if !u.ChirpyRedActive(at) || u.ChirpyRedSince == nil {
+				u.ChirpyRedSince = &at
+			}
+			u.IsChirpyRed = true
+			u.SubscriptionStatus = SubscriptionActive
+			u.ChirpyRedExpiresAt = expiresAt
+		case SubscriptionRenewed:
+			if u.ChirpyRedSince == nil {
+				u.ChirpyRedSince = &at
+			}
+			u.IsChirpyRed = true
+			u.SubscriptionStatus = SubscriptionActive
+			u.ChirpyRedExpiresAt = expiresAt
+		case SubscriptionPaymentFailed:
+			u.SubscriptionStatus = SubscriptionPastDue
+		case SubscriptionCancelledBy:
+			u.SubscriptionStatus = SubscriptionCancelled
+		case SubscriptionDowngraded:
+			u.IsChirpyRed = false
+			u.SubscriptionStatus = SubscriptionExpired
+			u.ChirpyRedExpiresAt = &at
+		}
+
+		users[userID] = u
+
+		return enqueueSubscriptionChange(dbStructure, u, wasActive, at)
+	})
+}
+
+// enqueueSubscriptionChange notifies webhook endpoints when the user gains or loses Chirpy Red
+func enqueueSubscriptionChange(dbStructure *DBStructure, u User, wasActive bool, at time.Time) error {
+	isActive := u.ChirpyRedActive(at)
+	if isActive == wasActive {
+		return nil
+	}
+
+	event := EventUserUpgraded
+	if !isActive {
+		event = EventUserDowngraded
+	}
+
+	return enqueueWebhookEvent(dbStructure, event, u.ID, struct {
+		ID                 int        `json:"id"`
+		IsChirpyRed        bool       `json:"is_chirpy_red"`
+		SubscriptionStatus string     `json:"subscription_status"`
+		ChirpyRedExpiresAt *time.Time `json:"chirpy_red_expires_at,omitempty"`
+	}{
+		ID:                 u.ID,
+		IsChirpyRed:        isActive,
+		SubscriptionStatus: u.SubscriptionStatus,
+		ChirpyRedExpiresAt: u.ChirpyRedExpiresAt,
+	})
+}
+
+// ExpireSubscriptions removes Chirpy Red from users whose paid period is over
+func (db *DB) ExpireSubscriptions(now time.Time) (int, error) {
+	expired := 0
+
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, u := range dbStructure.Users {
+			if !u.IsChirpyRed || u.ChirpyRedActive(now) {
+				continue
+			}
+
+			u.IsChirpyRed = false
+			u.SubscriptionStatus = SubscriptionExpired
+			dbStructure.Users[id] = u
+			expired++
+
+			err := enqueueSubscriptionChange(dbStructure, u, true, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		if expired == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
+
+// RunSubscriptionExpiryJob expires subscriptions every interval until ctx is cancelled
+func (db *DB) RunSubscriptionExpiryJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := db.ExpireSubscriptions(time.Now().UTC())
+			if err != nil {
+				log.Printf("Error expiring subscriptions: %s", err)
+				continue
+			}
+			if expired > 0 {
+				log.Printf("Expired %d Chirpy Red subscriptions", expired)
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	jesse, _ := db.CreateUser("jesse@breakingbad.com", "hash")
+	skyler, _ := db.CreateUser("skyler@breakingbad.com", "hash")
+
+	now := time.Now().UTC()
+	start := now.Add(-time.Hour * 24 * 31)
+	periodEnd := start.Add(time.Hour * 24 * 30)
+	renewedEnd := now.Add(time.Hour * 24 * 30)
+
+	db.UpdateChirpyRedStatus(walt.ID, SubscriptionEvent{Type: SubscriptionStarted, At: start, ExpiresAt: &periodEnd})
+	db.UpdateChirpyRedStatus(jesse.ID, SubscriptionEvent{Type: SubscriptionStarted, At: start, ExpiresAt: &periodEnd})
+	db.UpdateChirpyRedStatus(jesse.ID, SubscriptionEvent{Type: SubscriptionRenewed, At: start.Add(time.Hour), ExpiresAt: &renewedEnd})
+	db.UpdateChirpyRedStatus(jesse.ID, SubscriptionEvent{Type: SubscriptionCancelledBy, At: start.Add(time.Hour * 2)})
+	// Legacy events don't say when the subscription ends
+	db.UpdateChirpyRedStatus(skyler.ID, SubscriptionEvent{Type: SubscriptionStarted, At: start})
+
+	expired, err := db.ExpireSubscriptions(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 1 {
+		t.Errorf("Output %d not equal to expected %d", expired, 1)
+	}
+
+	walt, _ = db.GetUserByID(walt.ID)
+	if walt.ChirpyRedActive(now) || walt.SubscriptionStatus != SubscriptionExpired {
+		t.Errorf("Subscription of user %d should have expired, got %q", walt.ID, walt.SubscriptionStatus)
+	}
+
+	// Cancelled subscriptions keep Chirpy Red until the renewed period is over
+	jesse, _ = db.GetUserByID(jesse.ID)
+	if !jesse.ChirpyRedActive(now) || jesse.SubscriptionStatus != SubscriptionCancelled {
+		t.Errorf("Subscription of user %d should still be active, got %q", jesse.ID, jesse.SubscriptionStatus)
+	}
+
+	// Without an end date the subscription lasts until it is downgraded
+	skyler, _ = db.GetUserByID(skyler.ID)
+	if !skyler.ChirpyRedActive(now) || skyler.ChirpyRedExpiresAt != nil {
+		t.Errorf("Subscription of user %d should be active without an end date, got %v", skyler.ID, skyler.ChirpyRedExpiresAt)
+	}
+
+	db.UpdateChirpyRedStatus(jesse.ID, SubscriptionEvent{Type: SubscriptionDowngraded, At: now})
+	jesse, _ = db.GetUserByID(jesse.ID)
+	if jesse.ChirpyRedActive(now) {
+		t.Errorf("User %d should have been downgraded", jesse.ID)
+	}
+}
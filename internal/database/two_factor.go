@@ -0,0 +1,114 @@
+package database
+
+import (
+	"time"
+)
+
+// TwoFactor holds a user's TOTP enrollment, keyed by user id. It is kept apart from User so the secret is
+// never returned with the user.
+type TwoFactor struct {
+	UserID             int        `json:"user_id"`
+	Secret             string     `json:"secret"`
+	Enabled            bool       `json:"enabled"`
+	RecoveryCodeHashes []string   `json:"recovery_code_hashes"`
+	LastUsedStep       int64      `json:"last_used_step"`
+	EnabledAt          *time.Time `json:"enabled_at,omitempty"`
+}
+
+// SaveTwoFactorSecret starts a new enrollment. Any previous enrollment is replaced but stays disabled until verified.
+func (db *DB) SaveTwoFactorSecret(userID int, secret string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if dbStructure.TwoFactor == nil {
+			dbStructure.TwoFactor = map[int]TwoFactor{}
+		}
+
+		if dbStructure.TwoFactor[userID].Enabled {
+			return conflict("two-factor authentication is already enabled")
+		}
+
+		dbStructure.TwoFactor[userID] = TwoFactor{
+			UserID: userID,
+			Secret: secret,
+		}
+
+		return nil
+	})
+}
+
+func (db *DB) GetTwoFactor(userID int) (TwoFactor, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return TwoFactor{}, err
+	}
+
+	tf, ok := dbStructure.TwoFactor[userID]
+	if !ok {
+		return TwoFactor{}, notFound("two-factor authentication is not set up")
+	}
+
+	return tf, nil
+}
+
+func (db *DB) EnableTwoFactor(userID int, step int64, recoveryCodeHashes []string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		tf, ok := dbStructure.TwoFactor[userID]
+		if !ok {
+			return notFound("two-factor authentication is not set up")
+		}
+
+		now := time.Now().UTC()
+		tf.Enabled = true
+		tf.EnabledAt = &now
+		tf.LastUsedStep = step
+		tf.RecoveryCodeHashes = recoveryCodeHashes
+		dbStructure.TwoFactor[userID] = tf
+
+		return nil
+	})
+}
+
+// UseTwoFactorStep records the time step of an accepted code. It fails if the step, or a later one, was already used.
+func (db *DB) UseTwoFactorStep(userID int, step int64) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		tf, ok := dbStructure.TwoFactor[userID]
+		if !ok || !tf.Enabled {
+			return conflict("two-factor authentication is not enabled")
+		}
+
+		if step <= tf.LastUsedStep {
+			return conflict("code has already been used")
+		}
+
+		tf.LastUsedStep = step
+		dbStructure.TwoFactor[userID] = tf
+
+		return nil
+	})
+}
+
+// UseRecoveryCode removes the recovery code so it cannot be used again
+func (db *DB) UseRecoveryCode(userID int, codeHash string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		tf, ok := dbStructure.TwoFactor[userID]
+		if !ok || !tf.Enabled {
+			return conflict("two-factor authentication is not enabled")
+		}
+
+		for i, v := range tf.RecoveryCodeHashes {
+			if v == codeHash {
+				tf.RecoveryCodeHashes = append(tf.RecoveryCodeHashes[:i], tf.RecoveryCodeHashes[i+1:]...)
+				dbStructure.TwoFactor[userID] = tf
+				return nil
+			}
+		}
+
+		return invalid("recovery code is invalid")
+	})
+}
+
+func (db *DB) DisableTwoFactor(userID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.TwoFactor, userID)
+		return nil
+	})
+}
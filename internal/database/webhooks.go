@@ -0,0 +1,130 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	WebhookProcessed = "processed"
+	WebhookDuplicate = "duplicate"
+	WebhookIgnored   = "ignored"
+	WebhookRejected  = "rejected"
+	WebhookFailed    = "failed"
+
+	// webhookRetention is how long processed event ids and delivery logs are kept
+	webhookRetention = time.Hour * 24 * 30
+	// maxWebhookDeliveries is how many delivery logs are kept at most, the oldest are dropped first
+	maxWebhookDeliveries = 1000
+)
+
+var ErrWebhookEventProcessed = conflict("webhook event has already been processed")
+
+// WebhookDelivery is the log entry of a received webhook request and what we did with it
+type WebhookDelivery struct {
+	ID         int       `json:"id"`
+	Source     string    `json:"source"`
+	EventID    string    `json:"event_id,omitempty"`
+	Event      string    `json:"event,omitempty"`
+	UserID     int       `json:"user_id,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Status     int       `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// ClaimWebhookEvent marks the event as processed and returns ErrWebhookEventProcessed if it already was,
+// so retried deliveries are only applied once.
+func (db *DB) ClaimWebhookEvent(eventID string) error {
+	// The check and the insert happen under one lock, so concurrent retries can't both claim the event
+	return db.update(func(dbStructure *DBStructure) error {
+		if dbStructure.WebhookEvents == nil {
+			dbStructure.WebhookEvents = map[string]time.Time{}
+		}
+
+		if _, ok := dbStructure.WebhookEvents[eventID]; ok {
+			return ErrWebhookEventProcessed
+		}
+
+		now := time.Now().UTC()
+		for k, v := range dbStructure.WebhookEvents {
+			if now.Sub(v) > webhookRetention {
+				delete(dbStructure.WebhookEvents, k)
+			}
+		}
+
+		dbStructure.WebhookEvents[eventID] = now
+
+		return nil
+	})
+}
+
+// ReleaseWebhookEvent forgets a claimed event whose processing failed, so a retry can be applied
+func (db *DB) ReleaseWebhookEvent(eventID string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.WebhookEvents, eventID)
+		return nil
+	})
+}
+
+func (db *DB) RecordWebhookDelivery(d WebhookDelivery) (WebhookDelivery, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		if dbStructure.WebhookDeliveries == nil {
+			dbStructure.WebhookDeliveries = map[int]WebhookDelivery{}
+		}
+
+		for id, v := range dbStructure.WebhookDeliveries {
+			if d.ReceivedAt.Sub(v.ReceivedAt) > webhookRetention {
+				delete(dbStructure.WebhookDeliveries, id)
+			}
+		}
+
+		d.ID = nextID(dbStructure, "webhook_deliveries", dbStructure.WebhookDeliveries)
+		dbStructure.WebhookDeliveries[d.ID] = d
+
+		if len(dbStructure.WebhookDeliveries) > maxWebhookDeliveries {
+			ids := make([]int, 0, len(dbStructure.WebhookDeliveries))
+			for id := range dbStructure.WebhookDeliveries {
+				ids = append(ids, id)
+			}
+			sort.Ints(ids)
+
+			for _, id := range ids[:len(ids)-maxWebhookDeliveries] {
+				delete(dbStructure.WebhookDeliveries, id)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	return d, nil
+}
+
+// GetWebhookDeliveries returns the newest deliveries first, optionally only those with the given outcome
+func (db *DB) GetWebhookDeliveries(source, outcome string, limit int) ([]WebhookDelivery, error) {
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]WebhookDelivery, 0)
+	for _, d := range dbStructure.WebhookDeliveries {
+		if (source != "" && d.Source != source) || (outcome != "" && d.Outcome != outcome) {
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries, nil
+}
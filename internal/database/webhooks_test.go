@@ -0,0 +1,83 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClaimWebhookEvent(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent retries of the same event, only one of them may be applied
+	var claimed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := db.ClaimWebhookEvent("evt_1")
+			if err == nil {
+				claimed.Add(1)
+			} else if !errors.Is(err, ErrWebhookEventProcessed) {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if claimed.Load() != 1 {
+		t.Errorf("Output %d claims not equal to expected %d", claimed.Load(), 1)
+	}
+
+	err = db.ReleaseWebhookEvent("evt_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ClaimWebhookEvent("evt_1"); err != nil {
+		t.Errorf("Released event should be claimed again: %v", err)
+	}
+}
+
+func TestRecordWebhookDeliveryCap(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill the log in one write, then go over the cap
+	now := time.Now().UTC()
+	db.update(func(dbStructure *DBStructure) error {
+		dbStructure.WebhookDeliveries = map[int]WebhookDelivery{}
+		for i := 0; i < maxWebhookDeliveries; i++ {
+			id := nextID(dbStructure, "webhook_deliveries", dbStructure.WebhookDeliveries)
+			dbStructure.WebhookDeliveries[id] = WebhookDelivery{ID: id, Source: "polka", Outcome: WebhookProcessed, ReceivedAt: now}
+		}
+		return nil
+	})
+
+	for i := 0; i < 5; i++ {
+		_, err := db.RecordWebhookDelivery(WebhookDelivery{Source: "polka", Outcome: WebhookProcessed, ReceivedAt: now})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, err := db.GetWebhookDeliveries("polka", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != maxWebhookDeliveries {
+		t.Errorf("Output %d deliveries not equal to expected %d", len(deliveries), maxWebhookDeliveries)
+	}
+	// The oldest are dropped first
+	if oldest := deliveries[len(deliveries)-1].ID; oldest != 6 {
+		t.Errorf("Output oldest id %d not equal to expected %d", oldest, 6)
+	}
+}
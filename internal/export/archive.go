@@ -0,0 +1,102 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteArchive writes a ZIP with the user's profile and all their chirps as JSON and CSV
+func WriteArchive(w io.Writer, user database.User, chirps []database.Chirp) error {
+	zw := zip.NewWriter(w)
+
+	user.Password = "" // Never export the password hash
+
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].ID < chirps[j].ID
+	})
+
+	err := writeJSON(zw, "profile.json", user)
+	if err != nil {
+		return err
+	}
+
+	err = writeJSON(zw, "chirps.json", chirps)
+	if err != nil {
+		return err
+	}
+
+	err = writeChirpsCSV(zw, chirps)
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func create(zw *zip.Writer, name string) (io.Writer, error) {
+	return zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now().UTC(),
+	})
+}
+
+func writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	f, err := create(zw, name)
+	if err != nil {
+		return err
+	}
+
+	dat, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(dat)
+	return err
+}
+
+func writeChirpsCSV(zw *zip.Writer, chirps []database.Chirp) error {
+	f, err := create(zw, "chirps.csv")
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(f)
+	err = cw.Write([]string{"id", "author_id", "created_at", "body", "media"})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range chirps {
+		err = cw.Write([]string{
+			strconv.Itoa(c.ID),
+			strconv.Itoa(c.AuthorID),
+			c.CreatedAt.UTC().Format(time.RFC3339),
+			csvText(c.Body),
+			csvText(strings.Join(c.Media, " ")),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvText keeps spreadsheets from running user text as a formula, by prefixing the characters that start one
+// with a quote (CSV injection)
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
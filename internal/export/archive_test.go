@@ -0,0 +1,93 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWriteArchive(t *testing.T) {
+	user := database.User{ID: 1, Email: "walt@breakingbad.com", Password: "hash"}
+	createdAt := time.Date(2008, time.January, 20, 21, 0, 0, 0, time.UTC)
+	chirps := []database.Chirp{
+		{ID: 2, AuthorID: 1, Body: "Say my name, \"Heisenberg\"", CreatedAt: createdAt},
+		{ID: 1, AuthorID: 1, Body: "I am the one who knocks", Media: []string{"https://cdn.example/1.png", "https://cdn.example/2.png"}, CreatedAt: createdAt},
+		{ID: 3, AuthorID: 1, Body: "=HYPERLINK(\"https://evil.example\")", CreatedAt: createdAt},
+	}
+
+	var buf bytes.Buffer
+	err := WriteArchive(&buf, user, chirps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dat, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(dat)
+	}
+
+	var profile database.User
+	json.Unmarshal([]byte(files["profile.json"]), &profile)
+
+	var exported []database.Chirp
+	json.Unmarshal([]byte(files["chirps.json"]), &exported)
+
+	tests := []struct {
+		name     string
+		output   interface{}
+		expected interface{}
+	}{
+		{name: "files", output: len(files), expected: 3},
+		{name: "profile email", output: profile.Email, expected: "walt@breakingbad.com"},
+		{name: "profile password", output: profile.Password, expected: ""},
+		{name: "chirps", output: len(exported), expected: 3},
+		{name: "chirps sorted", output: exported[0].ID, expected: 1},
+		{name: "csv", output: files["chirps.csv"], expected: "id,author_id,created_at,body,media\n" +
+			"1,1,2008-01-20T21:00:00Z,I am the one who knocks,https://cdn.example/1.png https://cdn.example/2.png\n" +
+			"2,1,2008-01-20T21:00:00Z,\"Say my name, \"\"Heisenberg\"\"\",\n" +
+			"3,1,2008-01-20T21:00:00Z,\"'=HYPERLINK(\"\"https://evil.example\"\")\",\n"},
+	}
+
+	for _, test := range tests {
+		if test.output != test.expected {
+			t.Errorf("%s: output %v not equal to expected %v", test.name, test.output, test.expected)
+		}
+	}
+}
+
+func TestCSVText(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "Say my name", expected: "Say my name"},
+		{input: "", expected: ""},
+		{input: "=1+1", expected: "'=1+1"},
+		{input: "+1 for science", expected: "'+1 for science"},
+		{input: "-2+3", expected: "'-2+3"},
+		{input: "@SUM(A1)", expected: "'@SUM(A1)"},
+		{input: "\t=1+1", expected: "'\t=1+1"},
+		{input: "a = b", expected: "a = b"},
+	}
+
+	for _, test := range tests {
+		if output := csvText(test.input); output != test.expected {
+			t.Errorf("%q: output %q not equal to expected %q", test.input, output, test.expected)
+		}
+	}
+}
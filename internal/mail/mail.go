@@ -0,0 +1,84 @@
+package mail
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{msg.To}, format(m.From, msg))
+}
+
+// WriterMailer writes messages to a file or stdout instead of sending them, for local testing
+type WriterMailer struct {
+	mux  *sync.Mutex
+	w    io.Writer
+	From string
+}
+
+func NewWriterMailer(w io.Writer, from string) *WriterMailer {
+	return &WriterMailer{
+		mux:  &sync.Mutex{},
+		w:    w,
+		From: from,
+	}
+}
+
+// NewFileMailer appends messages to the file at path
+func NewFileMailer(path, from string) (*WriterMailer, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWriterMailer(file, from), nil
+}
+
+func (m *WriterMailer) Send(msg Message) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	_, err := m.w.Write(append(format(m.From, msg), []byte("\r\n")...))
+	return err
+}
+
+func format(from string, msg Message) []byte {
+	headers := []string{
+		"From: " + from,
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"Date: " + time.Now().UTC().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+
+	return []byte(fmt.Sprintf("%s\r\n\r\n%s\r\n", strings.Join(headers, "\r\n"), msg.Body))
+}
@@ -0,0 +1,205 @@
+package openapi
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Version is the version of the OpenAPI specification documents are written against
+const Version = "3.1.0"
+
+// Document is an OpenAPI document, with only the parts of the specification Chirpy uses
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Servers    []Server            `json:"servers,omitempty"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+// PathItem maps lower case methods to their operation
+type PathItem map[string]*Operation
+
+// SecurityRequirement maps the name of a security scheme to the scopes it needs
+type SecurityRequirement map[string][]string
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Security    []SecurityRequirement `json:"security,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Name        string `json:"name,omitempty"`
+	In          string `json:"in,omitempty"`
+	Scheme      string `json:"scheme,omitempty"`
+}
+
+// Schema is a JSON Schema. Nullable schemas are written as a list of types, as OpenAPI 3.1 does.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"-"`
+	Nullable             bool               `json:"-"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	// OneOf is only used to document responses, requests are not validated against it
+	OneOf []*Schema `json:"oneOf,omitempty"`
+}
+
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type schema Schema
+	out := struct {
+		Type interface{} `json:"type,omitempty"`
+		*schema
+	}{schema: (*schema)(s)}
+
+	if s.Type != "" {
+		out.Type = s.Type
+		if s.Nullable {
+			out.Type = []string{s.Type, "null"}
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// New creates an empty document
+func New(info Info) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]PathItem{},
+		Components: Components{
+			Schemas:         map[string]*Schema{},
+			SecuritySchemes: map[string]SecurityScheme{},
+		},
+	}
+}
+
+// Add documents the operation of method on path, e.g. Add("GET", "/api/chirps/{chirpID}", op)
+func (d *Document) Add(method, path string, op *Operation) {
+	item, ok := d.Paths[path]
+	if !ok {
+		item = PathItem{}
+		d.Paths[path] = item
+	}
+	item[strings.ToLower(method)] = op
+}
+
+// Operations lists every "METHOD path" of the document, sorted
+func (d *Document) Operations() []string {
+	result := make([]string, 0)
+	for path, item := range d.Paths {
+		for method := range item {
+			result = append(result, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Find returns the operation of a request path along with the values of its path parameters. Paths with
+// more fixed segments win, so /users/me matches before /users/{userID}.
+func (d *Document) Find(method, path string) (*Operation, map[string]string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	method = strings.ToLower(method)
+
+	var found *Operation
+	var foundParams map[string]string
+	best := -1
+
+	for template, item := range d.Paths {
+		op, ok := item[method]
+		if !ok {
+			continue
+		}
+
+		params, fixed, ok := matchPath(strings.Split(strings.Trim(template, "/"), "/"), segments)
+		if ok && fixed > best {
+			found, foundParams, best = op, params, fixed
+		}
+	}
+
+	return found, foundParams, found != nil
+}
+
+func matchPath(template, segments []string) (map[string]string, int, bool) {
+	if len(template) != len(segments) {
+		return nil, 0, false
+	}
+
+	params := map[string]string{}
+	fixed := 0
+	for i, t := range template {
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") {
+			if segments[i] == "" {
+				return nil, 0, false
+			}
+			params[t[1:len(t)-1]] = segments[i]
+			continue
+		}
+		if t != segments[i] {
+			return nil, 0, false
+		}
+		fixed++
+	}
+
+	return params, fixed, true
+}
@@ -0,0 +1,123 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+func String() *Schema {
+	return &Schema{Type: "string"}
+}
+
+func Integer() *Schema {
+	return &Schema{Type: "integer"}
+}
+
+func Boolean() *Schema {
+	return &Schema{Type: "boolean"}
+}
+
+func DateTime() *Schema {
+	return &Schema{Type: "string", Format: "date-time"}
+}
+
+// Enum is a string that must be one of values
+func Enum(values ...string) *Schema {
+	return &Schema{Type: "string", Enum: values}
+}
+
+func ArrayOf(items *Schema) *Schema {
+	return &Schema{Type: "array", Items: items}
+}
+
+// Object is an object with the given properties, of which required must be present
+func Object(properties map[string]*Schema, required ...string) *Schema {
+	return &Schema{Type: "object", Properties: properties, Required: required}
+}
+
+// OneOf is a value matching exactly one of schemas
+func OneOf(schemas ...*Schema) *Schema {
+	return &Schema{OneOf: schemas}
+}
+
+// Ref points to a schema of the components
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaOf describes the JSON encoding of v. Named structs are added to the components and referenced, so
+// the document follows the types the API actually returns. Fields without omitempty are required.
+func (d *Document) SchemaOf(v interface{}) *Schema {
+	return d.schemaOfType(reflect.TypeOf(v))
+}
+
+func (d *Document) schemaOfType(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Pointer {
+		s := d.schemaOfType(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	}
+
+	if t == timeType {
+		return DateTime()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return String()
+	case reflect.Bool:
+		return Boolean()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Integer()
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return ArrayOf(d.schemaOfType(t.Elem()))
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schemaOfType(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		if _, ok := d.Components.Schemas[t.Name()]; !ok {
+			// Registered before the fields so recursive types end
+			d.Components.Schemas[t.Name()] = &Schema{}
+			*d.Components.Schemas[t.Name()] = *d.structSchema(t)
+		}
+		return Ref(t.Name())
+	}
+
+	return &Schema{}
+}
+
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := Object(map[string]*Schema{})
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		s.Properties[name] = d.schemaOfType(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,248 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/bobby-lin/chirpy/internal/utils"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ValidateRequest checks the parameters and the body of a request against the operation it matches. Problems
+// are reported as a *utils.ValidationError; requests without an operation are left to the router. The body is
+// restored so handlers can still read it.
+func (d *Document) ValidateRequest(r *http.Request) error {
+	op, pathParams, ok := d.Find(r.Method, r.URL.Path)
+	if !ok {
+		return nil
+	}
+
+	errs := &utils.ValidationError{}
+
+	for _, p := range op.Parameters {
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value, present = pathParams[p.Name]
+		case "query":
+			present = r.URL.Query().Has(p.Name)
+			value = r.URL.Query().Get(p.Name)
+		case "header":
+			value = r.Header.Get(p.Name)
+			present = value != ""
+		default:
+			continue
+		}
+
+		if !present {
+			if p.Required {
+				errs.Add(p.Name, "required", p.Name+" is required")
+			}
+			continue
+		}
+		d.validateParameter(p.Name, p.Schema, value, errs)
+	}
+
+	if op.RequestBody != nil {
+		err := d.validateBody(r, op.RequestBody, errs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return errs.Err()
+}
+
+func (d *Document) validateParameter(name string, s *Schema, value string, errs *utils.ValidationError) {
+	s = d.resolve(s)
+
+	switch s.Type {
+	case "integer":
+		if _, err := strconv.Atoi(value); err != nil {
+			errs.Add(name, "type", name+" must be an integer")
+			return
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			errs.Add(name, "type", name+" must be a boolean")
+			return
+		}
+	}
+
+	if len(s.Enum) > 0 && !contains(s.Enum, value) {
+		errs.Add(name, "enum", name+" must be one of: "+strings.Join(s.Enum, ", "))
+	}
+}
+
+func (d *Document) validateBody(r *http.Request, body *RequestBody, errs *utils.ValidationError) error {
+	dat, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(dat))
+
+	if len(bytes.TrimSpace(dat)) == 0 {
+		if body.Required {
+			errs.Add("body", "required", "request body is required")
+		}
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	form, isForm := body.Content["application/x-www-form-urlencoded"]
+	jsonBody, isJSON := body.Content["application/json"]
+
+	// Handlers decode JSON whatever the content type says, clients like curl send JSON as a form by default
+	if isJSON && (!isForm || mediaType != "application/x-www-form-urlencoded") {
+		var value interface{}
+		err = json.Unmarshal(dat, &value)
+		if err != nil {
+			errs.Add("body", "invalid_json", "request body is not valid JSON")
+			return nil
+		}
+		d.validateValue("", jsonBody.Schema, value, errs)
+		return nil
+	}
+
+	if isForm {
+		values, err := parseForm(dat)
+		if err != nil {
+			errs.Add("body", "invalid_form", "request body is not a valid form")
+			return nil
+		}
+		d.validateValue("", form.Schema, values, errs)
+		return nil
+	}
+
+	errs.Add("body", "unsupported_media_type", "unsupported content type: "+mediaType)
+	return nil
+}
+
+// validateValue checks a decoded JSON value. Form values are all strings so they are only checked for presence
+// and enums.
+func (d *Document) validateValue(field string, s *Schema, value interface{}, errs *utils.ValidationError) {
+	s = d.resolve(s)
+	name := field
+	if name == "" {
+		name = "body"
+	}
+
+	if value == nil {
+		if !s.Nullable && s.Type != "" {
+			errs.Add(name, "type", name+" must not be null")
+		}
+		return
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if s.Type != "" && s.Type != "object" {
+			errs.Add(name, "type", name+" must be "+article(s.Type))
+			return
+		}
+		for _, r := range s.Required {
+			if _, ok := v[r]; !ok {
+				errs.Add(join(field, r), "required", join(field, r)+" is required")
+			}
+		}
+		for k, item := range v {
+			if p, ok := s.Properties[k]; ok {
+				d.validateValue(join(field, k), p, item, errs)
+			} else if s.AdditionalProperties != nil {
+				d.validateValue(join(field, k), s.AdditionalProperties, item, errs)
+			}
+		}
+	case []interface{}:
+		if s.Type != "" && s.Type != "array" {
+			errs.Add(name, "type", name+" must be "+article(s.Type))
+			return
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				d.validateValue(name+"["+strconv.Itoa(i)+"]", s.Items, item, errs)
+			}
+		}
+	case string:
+		if s.Type != "" && s.Type != "string" {
+			errs.Add(name, "type", name+" must be "+article(s.Type))
+			return
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, v) {
+			errs.Add(name, "enum", name+" must be one of: "+strings.Join(s.Enum, ", "))
+		}
+	case formValue:
+		if len(s.Enum) > 0 && !contains(s.Enum, string(v)) {
+			errs.Add(name, "enum", name+" must be one of: "+strings.Join(s.Enum, ", "))
+		}
+	case float64:
+		if s.Type == "integer" && v != float64(int64(v)) {
+			errs.Add(name, "type", name+" must be an integer")
+		} else if s.Type != "" && s.Type != "integer" && s.Type != "number" {
+			errs.Add(name, "type", name+" must be "+article(s.Type))
+		}
+	case bool:
+		if s.Type != "" && s.Type != "boolean" {
+			errs.Add(name, "type", name+" must be "+article(s.Type))
+		}
+	}
+}
+
+// resolve follows a $ref to the schema of the components
+func (d *Document) resolve(s *Schema) *Schema {
+	if s == nil {
+		return &Schema{}
+	}
+	if s.Ref != "" {
+		if target, ok := d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]; ok {
+			return target
+		}
+	}
+	return s
+}
+
+// formValue is a value of a form, which has no type of its own
+type formValue string
+
+func parseForm(dat []byte) (map[string]interface{}, error) {
+	r, _ := http.NewRequest(http.MethodPost, "/", bytes.NewReader(dat))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+
+	form := map[string]interface{}{}
+	for k := range r.PostForm {
+		form[k] = formValue(r.PostForm.Get(k))
+	}
+	return form, nil
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func article(t string) string {
+	switch t {
+	case "integer", "object", "array":
+		return "an " + t
+	}
+	return "a " + t
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
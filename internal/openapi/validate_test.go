@@ -0,0 +1,82 @@
+package openapi
+
+import (
+	"errors"
+	"github.com/bobby-lin/chirpy/internal/utils"
+	"io"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestValidateRequest(t *testing.T) {
+	doc := New(Info{Title: "test", Version: "1"})
+	doc.Add("POST", "/api/chirps/{chirpID}/report", &Operation{
+		Parameters: []Parameter{{Name: "chirpID", In: "path", Required: true, Schema: Integer()}},
+		RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: Object(map[string]*Schema{
+			"reason": Enum("spam", "other"),
+			"tags":   ArrayOf(String()),
+			"count":  Integer(),
+		}, "reason")}}},
+	})
+	doc.Add("GET", "/api/chirps", &Operation{
+		Parameters: []Parameter{{Name: "sort", In: "query", Schema: Enum("asc", "desc")}},
+	})
+	doc.Add("POST", "/api/oauth/token", &Operation{
+		RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{"application/x-www-form-urlencoded": {Schema: Object(map[string]*Schema{
+			"grant_type": Enum("authorization_code"),
+		}, "grant_type")}}},
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		form     bool
+		expected []string
+	}{
+		{name: "valid", method: "POST", target: "/api/chirps/1/report", body: `{"reason":"spam","tags":["a"],"count":2}`},
+		{name: "bad path parameter", method: "POST", target: "/api/chirps/one/report", body: `{"reason":"spam"}`, expected: []string{"chirpID"}},
+		{name: "missing body", method: "POST", target: "/api/chirps/1/report", expected: []string{"body"}},
+		{name: "invalid json", method: "POST", target: "/api/chirps/1/report", body: `{"reason":`, expected: []string{"body"}},
+		{name: "missing field", method: "POST", target: "/api/chirps/1/report", body: `{}`, expected: []string{"reason"}},
+		{name: "wrong types", method: "POST", target: "/api/chirps/1/report", body: `{"reason":"rude","tags":[1],"count":1.5}`, expected: []string{"count", "reason", "tags[0]"}},
+		{name: "query enum", method: "GET", target: "/api/chirps?sort=up", expected: []string{"sort"}},
+		{name: "valid query", method: "GET", target: "/api/chirps?sort=asc"},
+		{name: "undocumented", method: "GET", target: "/api/unknown"},
+		{name: "form", method: "POST", target: "/api/oauth/token", body: "grant_type=authorization_code", form: true},
+		{name: "bad form", method: "POST", target: "/api/oauth/token", body: "grant_type=password", form: true, expected: []string{"grant_type"}},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		if test.form {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+
+		err := doc.ValidateRequest(r)
+
+		fields := make([]string, 0)
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			for _, f := range validationErr.Fields {
+				fields = append(fields, f.Field)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		sort.Strings(fields)
+		got := strings.Join(fields, ",")
+		if got != strings.Join(test.expected, ",") {
+			t.Errorf("%s: output %q not equal to expected %q", test.name, got, strings.Join(test.expected, ","))
+		}
+
+		// Handlers must still be able to read the body
+		if dat, _ := io.ReadAll(r.Body); string(dat) != test.body {
+			t.Errorf("%s: body %q was not restored", test.name, dat)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package profanity
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Engine holds the filter built from a config file and swaps it when the file changes, so word lists can
+// be updated without restarting. Without a path it always uses the default filter.
+type Engine struct {
+	path    string
+	mux     *sync.RWMutex
+	filter  *Filter
+	modTime time.Time
+}
+
+func NewEngine(path string) (*Engine, error) {
+	e := &Engine{
+		path:   path,
+		mux:    &sync.RWMutex{},
+		filter: Default(),
+	}
+
+	if path == "" {
+		return e, nil
+	}
+
+	_, err := e.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *Engine) Filter() *Filter {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+
+	return e.filter
+}
+
+func (e *Engine) Check(text string) Result {
+	return e.Filter().Check(text)
+}
+
+// Reload reads the config file if it changed since the last load. An invalid file keeps the current filter.
+func (e *Engine) Reload() (bool, error) {
+	if e.path == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return false, err
+	}
+
+	e.mux.RLock()
+	unchanged := info.ModTime().Equal(e.modTime)
+	e.mux.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	f, err := Load(e.path)
+	if err != nil {
+		return false, err
+	}
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	e.filter = f
+	e.modTime = info.ModTime()
+
+	return true, nil
+}
+
+// Watch checks the config file for changes every interval until ctx is cancelled
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	if e.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := e.Reload()
+			if err != nil {
+				log.Printf("Error reloading profanity config: %s", err)
+				continue
+			}
+			if reloaded {
+				log.Printf("Reloaded profanity config from %s", e.path)
+			}
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package profanity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type Action string
+
+const (
+	// ActionMask replaces the match with the mask
+	ActionMask Action = "mask"
+	// ActionReject refuses the whole text
+	ActionReject Action = "reject"
+	// ActionFlag keeps the text as is but marks it for review by a moderator
+	ActionFlag Action = "flag"
+
+	defaultMask = "****"
+)
+
+// RuleConfig is either a list of words or a regular expression. Both are matched against the normalized
+// text, so they should be written in lowercase latin letters, see Normalize.
+type RuleConfig struct {
+	Name    string   `json:"name"`
+	Words   []string `json:"words,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+	Action  Action   `json:"action"`
+}
+
+// Config is the format of the filter config file, e.g.
+//
+//	{"mask": "****", "rules": [{"name": "default", "words": ["kerfuffle"], "action": "mask"}]}
+type Config struct {
+	Mask  string       `json:"mask"`
+	Rules []RuleConfig `json:"rules"`
+}
+
+// DefaultConfig masks the words Chirpy has always filtered
+var DefaultConfig = Config{
+	Mask: defaultMask,
+	Rules: []RuleConfig{
+		{Name: "default", Words: []string{"kerfuffle", "sharbert", "fornax"}, Action: ActionMask},
+	},
+}
+
+type rule struct {
+	name   string
+	action Action
+	re     *regexp.Regexp
+	// words only match whole words, patterns are free to match anywhere
+	words bool
+}
+
+type Match struct {
+	Rule   string `json:"rule"`
+	Action Action `json:"action"`
+	// Text is the matched part of the original text
+	Text  string `json:"text"`
+	start int
+	end   int
+}
+
+type Result struct {
+	// Text is the original text with the masked matches replaced
+	Text    string
+	Matches []Match
+}
+
+func (r Result) has(action Action) bool {
+	for _, m := range r.Matches {
+		if m.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Result) Masked() bool {
+	return r.has(ActionMask)
+}
+
+func (r Result) Rejected() bool {
+	return r.has(ActionReject)
+}
+
+func (r Result) Flagged() bool {
+	return r.has(ActionFlag)
+}
+
+type Filter struct {
+	mask  string
+	rules []rule
+}
+
+func New(config Config) (*Filter, error) {
+	f := &Filter{
+		mask: config.Mask,
+	}
+	if f.mask == "" {
+		f.mask = defaultMask
+	}
+
+	for i, rc := range config.Rules {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		if rc.Action != ActionMask && rc.Action != ActionReject && rc.Action != ActionFlag {
+			return nil, fmt.Errorf("%s: invalid action %q", name, rc.Action)
+		}
+
+		if (len(rc.Words) == 0) == (rc.Pattern == "") {
+			return nil, fmt.Errorf("%s: either words or pattern is required", name)
+		}
+
+		if rc.Pattern != "" {
+			re, err := regexp.Compile(rc.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			f.rules = append(f.rules, rule{name: name, action: rc.Action, re: re})
+			continue
+		}
+
+		alternatives := make([]string, 0, len(rc.Words))
+		for _, w := range rc.Words {
+			if p := wordPattern(w); p != "" {
+				alternatives = append(alternatives, p)
+			}
+		}
+
+		// Longer words first so the longest alternative wins
+		sort.Slice(alternatives, func(i, j int) bool {
+			return len(alternatives[i]) > len(alternatives[j])
+		})
+
+		re, err := regexp.Compile("(?:" + strings.Join(alternatives, "|") + ")")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		f.rules = append(f.rules, rule{name: name, action: rc.Action, re: re, words: true})
+	}
+
+	return f, nil
+}
+
+// wordPattern matches the normalized word with every letter repeated any number of times, e.g. "kerrfufffle"
+func wordPattern(word string) string {
+	b := strings.Builder{}
+	for _, r := range Normalize(strings.TrimSpace(word)) {
+		b.WriteString(regexp.QuoteMeta(string(r)))
+		b.WriteString("+")
+	}
+	return b.String()
+}
+
+// Default returns a filter using DefaultConfig
+func Default() *Filter {
+	f, _ := New(DefaultConfig)
+	return f
+}
+
+// Load reads a JSON config file, see Config
+func Load(path string) (*Filter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := Config{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profanity config: %w", err)
+	}
+
+	return New(config)
+}
+
+// Check finds every rule match in text and masks those whose rule says so
+func (f *Filter) Check(text string) Result {
+	n := normalize(text)
+	matches := make([]Match, 0)
+
+	for _, r := range f.rules {
+		for _, loc := range r.re.FindAllStringIndex(n.text, -1) {
+			if loc[0] == loc[1] || (r.words && !isWordBoundary(n.text, loc[0], loc[1])) {
+				continue
+			}
+
+			start, end := n.offsets[loc[0]], n.offsets[loc[1]]
+			matches = append(matches, Match{
+				Rule:   r.name,
+				Action: r.action,
+				Text:   text[start:end],
+				start:  start,
+				end:    end,
+			})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].start < matches[j].start
+	})
+
+	b := strings.Builder{}
+	last := 0
+	for _, m := range matches {
+		// Overlapping matches are reported but only masked once
+		if m.Action != ActionMask || m.start < last {
+			continue
+		}
+
+		b.WriteString(text[last:m.start])
+		b.WriteString(f.mask)
+		last = m.end
+	}
+	b.WriteString(text[last:])
+
+	return Result{
+		Text:    b.String(),
+		Matches: matches,
+	}
+}
+
+// isWordBoundary reports whether text[start:end] is not part of a longer word, so punctuation next to a
+// word doesn't stop it from matching while e.g. "fornaxes" is left alone.
+func isWordBoundary(text string, start, end int) bool {
+	if start > 0 {
+		r, _ := utf8.DecodeLastRuneInString(text[:start])
+		if isWordRune(r) {
+			return false
+		}
+	}
+
+	if end < len(text) {
+		r, _ := utf8.DecodeRuneInString(text[end:])
+		if isWordRune(r) {
+			return false
+		}
+	}
+
+	return true
+}
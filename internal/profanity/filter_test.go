@@ -0,0 +1,118 @@
+package profanity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type checkTest struct {
+	body             string
+	expectedText     string
+	expectedRejected bool
+	expectedFlagged  bool
+}
+
+var testConfig = Config{
+	Rules: []RuleConfig{
+		{Name: "default", Words: []string{"kerfuffle", "sharbert", "fornax"}, Action: ActionMask},
+		{Name: "spam", Pattern: `free\s+crypto`, Action: ActionReject},
+		{Name: "review", Words: []string{"darn"}, Action: ActionFlag},
+	},
+}
+
+var checkTests = []checkTest{
+	{body: "lorem ipsum dolor", expectedText: "lorem ipsum dolor"},
+	{body: "i love kerfuffle!", expectedText: "i love ****!"},
+	{body: "(Sharbert), kerfuffle.", expectedText: "(****), ****."},
+	{body: "KERFUFFLE", expectedText: "****"},
+	// Leetspeak, repeated letters, confusables, accents and zero width spaces
+	{body: "k3rfuffl3", expectedText: "****"},
+	{body: "kerrrfuuuffle", expectedText: "****"},
+	{body: "ѕharbеrt", expectedText: "****"},
+	{body: "fórnax", expectedText: "****"},
+	{body: "ｆｏｒｎａｘ", expectedText: "****"},
+	{body: "for\u200bnax", expectedText: "****"},
+	// Words only match on their own
+	{body: "fornaxes", expectedText: "fornaxes"},
+	{body: "Get FREE   crypto now", expectedText: "Get FREE   crypto now", expectedRejected: true},
+	{body: "darn it", expectedText: "darn it", expectedFlagged: true},
+}
+
+func TestCheck(t *testing.T) {
+	f, err := New(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range checkTests {
+		result := f.Check(test.body)
+		if result.Text != test.expectedText {
+			t.Errorf("%q: output %q not equal to expected %q", test.body, result.Text, test.expectedText)
+		}
+		if result.Rejected() != test.expectedRejected {
+			t.Errorf("%q: output %t not equal to expected %t", test.body, result.Rejected(), test.expectedRejected)
+		}
+		if result.Flagged() != test.expectedFlagged {
+			t.Errorf("%q: output %t not equal to expected %t", test.body, result.Flagged(), test.expectedFlagged)
+		}
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	configs := []Config{
+		{Rules: []RuleConfig{{Words: []string{"a"}, Action: "delete"}}},
+		{Rules: []RuleConfig{{Action: ActionMask}}},
+		{Rules: []RuleConfig{{Pattern: "(", Action: ActionMask}}},
+	}
+
+	for _, c := range configs {
+		if _, err := New(c); err == nil {
+			t.Errorf("Config %v should be invalid", c)
+		}
+	}
+}
+
+func TestEngineReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profanity.json")
+	err := os.WriteFile(path, []byte(`{"rules": [{"words": ["kerfuffle"], "action": "mask"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output := e.Check("kerfuffle sharbert").Text; output != "**** sharbert" {
+		t.Errorf("Output %q not equal to expected %q", output, "**** sharbert")
+	}
+
+	err = os.WriteFile(path, []byte(`{"mask": "#", "rules": [{"words": ["sharbert"], "action": "mask"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+
+	reloaded, err := e.Reload()
+	if err != nil || !reloaded {
+		t.Fatalf("Config should have been reloaded, got %t, %v", reloaded, err)
+	}
+
+	if output := e.Check("kerfuffle sharbert").Text; output != "kerfuffle #" {
+		t.Errorf("Output %q not equal to expected %q", output, "kerfuffle #")
+	}
+
+	// An invalid config keeps the current filter
+	os.WriteFile(path, []byte(`{"rules": [{"words": ["x"], "action": "nope"}]}`), 0644)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second*2))
+
+	if _, err := e.Reload(); err == nil {
+		t.Errorf("Invalid config should fail to reload")
+	}
+	if output := e.Check("sharbert").Text; output != "#" {
+		t.Errorf("Output %q not equal to expected %q", output, "#")
+	}
+}
@@ -0,0 +1,88 @@
+package profanity
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// confusables maps characters that look like latin letters to them. Only the common ones from the
+// Cyrillic and Greek scripts are listed, see Unicode TR39 for the full table.
+var confusables = map[rune]rune{
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c',
+	'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ї': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't',
+	'υ': 'u', 'χ': 'x', 'ω': 'w', 'ϲ': 'c', 'ı': 'i', 'ł': 'l', 'ø': 'o', 'ß': 's',
+}
+
+// leetspeak maps symbols and digits commonly used in place of letters
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '@': 'a', '$': 's',
+}
+
+// normalized is text where every rune of the original became at most one rune, so matches can be mapped back
+type normalized struct {
+	text string
+	// offsets holds for every byte offset of text where a rune starts the byte offset of the original rune,
+	// plus one entry for the end of the text
+	offsets map[int]int
+}
+
+// normalizeRune folds case, strips diacritics and replaces confusables and leetspeak. It returns false
+// for invisible characters, e.g. zero width spaces, which are dropped.
+func normalizeRune(r rune) (rune, bool) {
+	if unicode.Is(unicode.Cf, r) {
+		return 0, false
+	}
+
+	// Compatibility decomposition turns e.g. fullwidth letters into plain ones and splits off accents
+	if d := norm.NFKD.String(string(r)); d != "" {
+		base, _ := utf8.DecodeRuneInString(d)
+		if !unicode.Is(unicode.Mn, base) {
+			r = base
+		}
+	}
+
+	r = unicode.ToLower(r)
+
+	if c, ok := confusables[r]; ok {
+		return c, true
+	}
+	if l, ok := leetspeak[r]; ok {
+		return l, true
+	}
+
+	return r, true
+}
+
+func normalize(s string) normalized {
+	b := strings.Builder{}
+	offsets := make(map[int]int, len(s)+1)
+
+	for i, r := range s {
+		n, ok := normalizeRune(r)
+		if !ok {
+			continue
+		}
+
+		offsets[b.Len()] = i
+		b.WriteRune(n)
+	}
+
+	offsets[b.Len()] = len(s)
+
+	return normalized{
+		text:    b.String(),
+		offsets: offsets,
+	}
+}
+
+// Normalize returns the form text is matched in, which is also how words in the config are compared
+func Normalize(s string) string {
+	return normalize(s).text
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
@@ -0,0 +1,65 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	ScopeChirpsRead  = "chirps:read"
+	ScopeChirpsWrite = "chirps:write"
+	ScopeUsersRead   = "users:read"
+	ScopeUsersWrite  = "users:write"
+
+	// APITokenPrefix marks personal access tokens so they can be told apart from JWTs
+	APITokenPrefix = "chirpy_pat_"
+)
+
+var validScopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeUsersRead, ScopeUsersWrite}
+
+func GenerateAPIToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return APITokenPrefix + hex.EncodeToString(b), nil
+}
+
+// HashToken returns the value stored in the database for random tokens. They have enough entropy that a plain
+// SHA-256 is fine.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CompareTokenHash checks a plain token against a stored hash in constant time
+func CompareTokenHash(token, tokenHash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(tokenHash)) == 1
+}
+
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+func IsValidScope(scope string) bool {
+	for _, s := range validScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
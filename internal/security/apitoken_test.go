@@ -0,0 +1,95 @@
+package security
+
+import (
+	"strings"
+	"testing"
+)
+
+type isAPITokenTest struct {
+	token          string
+	expectedResult bool
+}
+
+var isAPITokenTests = []isAPITokenTest{
+	{token: "chirpy_pat_0123456789abcdef", expectedResult: true},
+	{token: "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.e30.sig", expectedResult: false},
+	{token: "", expectedResult: false},
+}
+
+func TestIsAPIToken(t *testing.T) {
+	for _, test := range isAPITokenTests {
+		result := IsAPIToken(test.token)
+		if result != test.expectedResult {
+			t.Errorf("%q: output %t not equal to expected %t", test.token, result, test.expectedResult)
+		}
+	}
+}
+
+type hasScopeTest struct {
+	scopes         []string
+	scope          string
+	expectedResult bool
+}
+
+var hasScopeTests = []hasScopeTest{
+	{scopes: []string{ScopeChirpsRead, ScopeChirpsWrite}, scope: ScopeChirpsWrite, expectedResult: true},
+	{scopes: []string{ScopeChirpsRead}, scope: ScopeChirpsWrite, expectedResult: false},
+	{scopes: []string{ScopeUsersWrite}, scope: ScopeUsersRead, expectedResult: false},
+	{scopes: nil, scope: ScopeChirpsRead, expectedResult: false},
+}
+
+func TestHasScope(t *testing.T) {
+	for _, test := range hasScopeTests {
+		result := HasScope(test.scopes, test.scope)
+		if result != test.expectedResult {
+			t.Errorf("%v has %s: output %t not equal to expected %t", test.scopes, test.scope, result, test.expectedResult)
+		}
+	}
+}
+
+type isValidScopeTest struct {
+	scope          string
+	expectedResult bool
+}
+
+var isValidScopeTests = []isValidScopeTest{
+	{scope: ScopeChirpsRead, expectedResult: true},
+	{scope: ScopeUsersWrite, expectedResult: true},
+	{scope: "openid", expectedResult: false},
+	{scope: "chirps:*", expectedResult: false},
+}
+
+func TestIsValidScope(t *testing.T) {
+	for _, test := range isValidScopeTests {
+		result := IsValidScope(test.scope)
+		if result != test.expectedResult {
+			t.Errorf("%q: output %t not equal to expected %t", test.scope, result, test.expectedResult)
+		}
+	}
+}
+
+func TestGenerateAPIToken(t *testing.T) {
+	token, err := GenerateAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := GenerateAPIToken()
+
+	if !strings.HasPrefix(token, APITokenPrefix) || len(token) != len(APITokenPrefix)+64 {
+		t.Errorf("Token %q should be the prefix followed by 32 random bytes", token)
+	}
+	if token == other {
+		t.Errorf("Tokens should be random, got %q twice", token)
+	}
+
+	hash := HashToken(token)
+	if hash == token || len(hash) != 64 {
+		t.Errorf("Hash %q should be a hex SHA-256", hash)
+	}
+	if !CompareTokenHash(token, hash) {
+		t.Errorf("Token should match its hash")
+	}
+	if CompareTokenHash(other, hash) {
+		t.Errorf("Other token should not match the hash")
+	}
+}
@@ -9,15 +9,45 @@ import (
 	"time"
 )
 
+type ChirpyClaims struct {
+	jwt.RegisteredClaims
+	// Scope is empty for first-party tokens, which are allowed to do everything
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	// Email binds email verification tokens to the address they were sent to
+	Email string `json:"email,omitempty"`
+	// Entitlements is only set on access tokens
+	Entitlements *Entitlements `json:"entitlements,omitempty"`
+}
+
 func CreateJwtToken(userId, expiresInSeconds int, issuer string) (string, error) {
+	return CreateScopedJwtToken(userId, expiresInSeconds, issuer, "", "")
+}
+
+// CreateScopedJwtToken issues a token on behalf of an OAuth client limited to the space separated scope
+func CreateScopedJwtToken(userId, expiresInSeconds int, issuer, scope, clientID string) (string, error) {
+	return createJwtToken(userId, expiresInSeconds, issuer, scope, clientID, nil)
+}
+
+// CreateAccessToken issues a chirpy-access token carrying the user's entitlements
+func CreateAccessToken(userId, expiresInSeconds int, scope, clientID string, entitlements Entitlements) (string, error) {
+	return createJwtToken(userId, expiresInSeconds, "chirpy-access", scope, clientID, &entitlements)
+}
+
+func createJwtToken(userId, expiresInSeconds int, issuer, scope, clientID string, entitlements *Entitlements) (string, error) {
 	signingKey := getJwtSecret()
 	nowUTC := time.Now().UTC()
 
-	claims := &jwt.RegisteredClaims{
-		Issuer:    issuer,
-		Subject:   strconv.Itoa(userId),
-		ExpiresAt: jwt.NewNumericDate(nowUTC.Add(time.Second * time.Duration(expiresInSeconds))),
-		IssuedAt:  jwt.NewNumericDate(nowUTC),
+	claims := &ChirpyClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   strconv.Itoa(userId),
+			ExpiresAt: jwt.NewNumericDate(nowUTC.Add(time.Second * time.Duration(expiresInSeconds))),
+			IssuedAt:  jwt.NewNumericDate(nowUTC),
+		},
+		Scope:        scope,
+		ClientID:     clientID,
+		Entitlements: entitlements,
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -30,23 +60,49 @@ func CreateJwtToken(userId, expiresInSeconds int, issuer string) (string, error)
 	return ss, nil
 }
 
-func GetTokenClaims(tokenString string) (jwt.Claims, error) {
+// CreateActionToken issues a single-use token, e.g. for password resets. The token id (jti) is
+// what callers record once the token has been used.
+func CreateActionToken(userId, expiresInSeconds int, issuer, email string) (string, error) {
+	signingKey := getJwtSecret()
+	nowUTC := time.Now().UTC()
+
+	jti, err := GenerateRandomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := &ChirpyClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    issuer,
+			Subject:   strconv.Itoa(userId),
+			ExpiresAt: jwt.NewNumericDate(nowUTC.Add(time.Second * time.Duration(expiresInSeconds))),
+			IssuedAt:  jwt.NewNumericDate(nowUTC),
+		},
+		Email: email,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(signingKey))
+}
+
+func GetTokenClaims(tokenString string) (*ChirpyClaims, error) {
 	// Validate Token
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &ChirpyClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(getJwtSecret()), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 
 	if err != nil {
 		log.Print(err)
-		return jwt.RegisteredClaims{}, err
+		return &ChirpyClaims{}, err
 	}
 
 	if !token.Valid {
 		log.Print("invalid token")
-		return jwt.RegisteredClaims{}, errors.New("token is invalid")
+		return &ChirpyClaims{}, errors.New("token is invalid")
 	}
 
-	return token.Claims, nil
+	return token.Claims.(*ChirpyClaims), nil
 }
 
 func getJwtSecret() string {
@@ -0,0 +1,88 @@
+package security
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies whose X-Forwarded-For header can be believed
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies reads a comma separated list of IPs and CIDRs, e.g. "10.0.0.0/8,127.0.0.1"
+func ParseTrustedProxies(s string) (TrustedProxies, error) {
+	proxies := TrustedProxies{}
+
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", v)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", v)
+		}
+		proxies = append(proxies, ipNet)
+	}
+
+	return proxies, nil
+}
+
+func (p TrustedProxies) contains(ip net.IP) bool {
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client. X-Forwarded-For is only used when the request comes from a trusted
+// proxy, and is read from the right so clients can't spoof it by sending their own header: the first address
+// that isn't a trusted proxy is the client.
+func (p TrustedProxies) ClientIP(remoteAddr string, forwardedFor []string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !p.contains(ip) {
+		return host
+	}
+
+	hops := make([]string, 0)
+	for _, v := range forwardedFor {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// Whatever comes before a malformed entry can't be trusted either
+			return host
+		}
+
+		host = hop.String()
+		if !p.contains(hop) {
+			return host
+		}
+	}
+
+	return host
+}
@@ -0,0 +1,44 @@
+package security
+
+const (
+	PlanFree      = "free"
+	PlanChirpyRed = "chirpy_red"
+)
+
+// Entitlements are the limits and features of a user's plan. They are embedded in access tokens so
+// handlers don't have to load the user; a change of plan takes effect once the token is refreshed.
+type Entitlements struct {
+	Plan             string `json:"plan"`
+	MaxChirpLength   int    `json:"max_chirp_length"`
+	EditChirps       bool   `json:"edit_chirps"`
+	ChirpsPerHour    int    `json:"chirps_per_hour"`
+	MaxMediaPerChirp int    `json:"max_media_per_chirp"`
+	// RateLimitMultiplier scales the API rate limits of the user's account
+	RateLimitMultiplier int `json:"rate_limit_multiplier"`
+}
+
+var (
+	FreeEntitlements = Entitlements{
+		Plan:                PlanFree,
+		MaxChirpLength:      140,
+		EditChirps:          false,
+		ChirpsPerHour:       30,
+		MaxMediaPerChirp:    1,
+		RateLimitMultiplier: 1,
+	}
+	ChirpyRedEntitlements = Entitlements{
+		Plan:                PlanChirpyRed,
+		MaxChirpLength:      1000,
+		EditChirps:          true,
+		ChirpsPerHour:       300,
+		MaxMediaPerChirp:    4,
+		RateLimitMultiplier: 5,
+	}
+)
+
+func EntitlementsFor(isChirpyRed bool) Entitlements {
+	if isChirpyRed {
+		return ChirpyRedEntitlements
+	}
+	return FreeEntitlements
+}
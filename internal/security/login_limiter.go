@@ -0,0 +1,188 @@
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+// AttemptRecord tracks failed login attempts for a single key, e.g. an account or a client IP
+type AttemptRecord struct {
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// AttemptStore persists attempt records. The in-memory store only works for a single instance;
+// a shared store (e.g. Redis) can be plugged in when running several.
+type AttemptStore interface {
+	Get(key string) (AttemptRecord, bool)
+	Set(key string, record AttemptRecord)
+	Delete(key string)
+}
+
+type MemoryAttemptStore struct {
+	mux     *sync.Mutex
+	records map[string]AttemptRecord
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{
+		mux:     &sync.Mutex{},
+		records: map[string]AttemptRecord{},
+	}
+}
+
+func (s *MemoryAttemptStore) Get(key string) (AttemptRecord, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	r, ok := s.records[key]
+	return r, ok
+}
+
+func (s *MemoryAttemptStore) Set(key string, record AttemptRecord) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.records[key] = record
+}
+
+func (s *MemoryAttemptStore) Delete(key string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	delete(s.records, key)
+}
+
+type LoginLimiterConfig struct {
+	// FreeAttempts is the number of failures allowed before backoff kicks in
+	FreeAttempts int
+	// LockoutThreshold is the number of failures after which the key is locked out
+	LockoutThreshold int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutDuration  time.Duration
+	// ResetAfter forgets failures once no new ones happened for this long
+	ResetAfter time.Duration
+}
+
+type LoginLimiter struct {
+	// mux makes Reserve atomic, a store shared by several instances has to provide that itself
+	mux    *sync.Mutex
+	store  AttemptStore
+	config LoginLimiterConfig
+	prefix string
+	now    func() time.Time
+}
+
+// NewLoginLimiter creates a limiter whose keys are namespaced by prefix so several limiters can share a store
+func NewLoginLimiter(store AttemptStore, prefix string, config LoginLimiterConfig) *LoginLimiter {
+	return &LoginLimiter{
+		mux:    &sync.Mutex{},
+		store:  store,
+		config: config,
+		prefix: prefix,
+		now:    time.Now,
+	}
+}
+
+// Check returns how long the caller has to wait before the next attempt is allowed, zero if it is allowed now
+func (l *LoginLimiter) Check(key string) time.Duration {
+	r, ok := l.store.Get(l.prefix + key)
+	if !ok {
+		return 0
+	}
+
+	now := l.now()
+
+	if now.Before(r.LockedUntil) {
+		return r.LockedUntil.Sub(now)
+	}
+
+	if r.Failures < l.config.FreeAttempts {
+		return 0
+	}
+
+	nextAttempt := r.LastFailure.Add(l.backoff(r.Failures))
+	if now.Before(nextAttempt) {
+		return nextAttempt.Sub(now)
+	}
+
+	return 0
+}
+
+// Reserve checks whether an attempt is allowed like Check and, when it is, counts it as a failure right away so
+// concurrent attempts can't all pass the check before any of them failed. Release refunds an attempt that didn't fail.
+func (l *LoginLimiter) Reserve(key string) time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	wait := l.Check(key)
+	if wait > 0 {
+		return wait
+	}
+
+	l.recordFailure(key)
+	return 0
+}
+
+// Release refunds an attempt counted by Reserve, including the lockout it may have triggered
+func (l *LoginLimiter) Release(key string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	r, ok := l.store.Get(l.prefix + key)
+	if !ok || r.Failures == 0 {
+		return
+	}
+
+	r.Failures--
+	if r.Failures < l.config.LockoutThreshold {
+		r.LockedUntil = time.Time{}
+	}
+
+	l.store.Set(l.prefix+key, r)
+}
+
+func (l *LoginLimiter) RecordFailure(key string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.recordFailure(key)
+}
+
+func (l *LoginLimiter) recordFailure(key string) {
+	now := l.now()
+
+	r, ok := l.store.Get(l.prefix + key)
+	if !ok || now.Sub(r.LastFailure) > l.config.ResetAfter {
+		r = AttemptRecord{}
+	}
+
+	r.Failures++
+	r.LastFailure = now
+
+	if r.Failures >= l.config.LockoutThreshold {
+		r.LockedUntil = now.Add(l.config.LockoutDuration)
+	}
+
+	l.store.Set(l.prefix+key, r)
+}
+
+// Reset clears the failures for key, after a successful login or when an admin unlocks an account
+func (l *LoginLimiter) Reset(key string) {
+	l.store.Delete(l.prefix + key)
+}
+
+// backoff doubles the delay for every failure past the free attempts, capped at MaxDelay
+func (l *LoginLimiter) backoff(failures int) time.Duration {
+	delay := l.config.BaseDelay
+	for i := l.config.FreeAttempts; i < failures; i++ {
+		delay *= 2
+		if delay >= l.config.MaxDelay {
+			return l.config.MaxDelay
+		}
+	}
+
+	return delay
+}
@@ -0,0 +1,99 @@
+package security
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := NewLoginLimiter(NewMemoryAttemptStore(), "account:", LoginLimiterConfig{
+		FreeAttempts:     3,
+		LockoutThreshold: 6,
+		BaseDelay:        time.Second,
+		MaxDelay:         4 * time.Second,
+		LockoutDuration:  time.Minute,
+		ResetAfter:       time.Hour,
+	})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if wait := l.Check("a@b.c"); wait != 0 {
+			t.Fatalf("Attempt %d should be allowed, got wait %s", i+1, wait)
+		}
+		l.RecordFailure("a@b.c")
+	}
+
+	if wait := l.Check("a@b.c"); wait != time.Second {
+		t.Errorf("Output %s not equal to expected %s", wait, time.Second)
+	}
+
+	l.RecordFailure("a@b.c")
+	if wait := l.Check("a@b.c"); wait != 2*time.Second {
+		t.Errorf("Output %s not equal to expected %s", wait, 2*time.Second)
+	}
+
+	l.RecordFailure("a@b.c")
+	l.RecordFailure("a@b.c")
+	if wait := l.Check("a@b.c"); wait != time.Minute {
+		t.Errorf("Output %s not equal to expected %s", wait, time.Minute)
+	}
+
+	if wait := l.Check("other@b.c"); wait != 0 {
+		t.Errorf("Other keys should not be affected, got wait %s", wait)
+	}
+
+	l.Reset("a@b.c")
+	if wait := l.Check("a@b.c"); wait != 0 {
+		t.Errorf("Reset key should be allowed, got wait %s", wait)
+	}
+}
+
+func TestLoginLimiterReserve(t *testing.T) {
+	l := NewLoginLimiter(NewMemoryAttemptStore(), "account:", LoginLimiterConfig{
+		FreeAttempts:     3,
+		LockoutThreshold: 6,
+		BaseDelay:        time.Second,
+		MaxDelay:         4 * time.Second,
+		LockoutDuration:  time.Minute,
+		ResetAfter:       time.Hour,
+	})
+
+	// Guesses sent in parallel must not all pass before the first of them failed
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.Reserve("a@b.c") == 0 {
+				mux.Lock()
+				allowed++
+				mux.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 3 {
+		t.Errorf("Output %d allowed attempts not equal to expected %d", allowed, 3)
+	}
+
+	// Attempts that didn't fail are refunded
+	l.Release("a@b.c")
+	if wait := l.Reserve("a@b.c"); wait != 0 {
+		t.Errorf("Released attempt should be allowed again, got wait %s", wait)
+	}
+
+	l.Reset("a@b.c")
+	for i := 0; i < 6; i++ {
+		l.RecordFailure("a@b.c")
+	}
+	l.Release("a@b.c")
+	if r, _ := l.store.Get("account:a@b.c"); r.Failures != 5 || !r.LockedUntil.IsZero() {
+		t.Errorf("Releasing the attempt that locked the key should lift the lockout: %+v", r)
+	}
+}
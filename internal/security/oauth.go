@@ -0,0 +1,141 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeOpenID = "openid"
+	ScopeEmail  = "email"
+
+	// OIDCKeyID identifies the signing key in the JWKS document
+	OIDCKeyID = "chirpy-oidc-1"
+)
+
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce string `json:"nonce,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// GenerateRandomString returns a URL-safe random string, used for client ids, secrets and authorization codes
+func GenerateRandomString(numBytes int) (string, error) {
+	b := make([]byte, numBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// VerifyPKCE checks the code_verifier against the code_challenge sent to the authorization endpoint.
+// Only S256 is supported.
+func VerifyPKCE(codeVerifier, codeChallenge, method string) bool {
+	if method != "S256" || codeVerifier == "" {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(codeVerifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(codeChallenge)) == 1
+}
+
+// IsValidOAuthScope reports whether every space separated scope can be granted to an OAuth client
+func IsValidOAuthScope(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s != ScopeOpenID && s != ScopeEmail && !IsValidScope(s) {
+			return false
+		}
+	}
+	return scope != ""
+}
+
+// LoadOIDCSigningKey reads a PEM encoded RSA key from path. When path is empty an ephemeral key is
+// generated, which means ID tokens cannot be verified across restarts.
+func LoadOIDCSigningKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		log.Println("OIDC_SIGNING_KEY_FILE is not set! Generating an ephemeral signing key...")
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(file)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an RSA key")
+	}
+
+	return key, nil
+}
+
+func CreateIDToken(key *rsa.PrivateKey, issuer string, userId int, clientID, nonce, email string, expiresInSeconds int) (string, error) {
+	nowUTC := time.Now().UTC()
+
+	claims := &IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   strconv.Itoa(userId),
+			Audience:  jwt.ClaimStrings{clientID},
+			ExpiresAt: jwt.NewNumericDate(nowUTC.Add(time.Second * time.Duration(expiresInSeconds))),
+			IssuedAt:  jwt.NewNumericDate(nowUTC),
+		},
+		Nonce: nonce,
+		Email: email,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = OIDCKeyID
+
+	return token.SignedString(key)
+}
+
+// JWKS returns the JSON Web Key Set publishing the public half of the ID token signing key
+func JWKS(key *rsa.PrivateKey) map[string]interface{} {
+	pub := key.PublicKey
+
+	return map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": OIDCKeyID,
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			},
+		},
+	}
+}
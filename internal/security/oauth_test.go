@@ -0,0 +1,43 @@
+package security
+
+import (
+	"testing"
+)
+
+type verifyPKCETest struct {
+	codeVerifier   string
+	codeChallenge  string
+	method         string
+	expectedResult bool
+}
+
+// Test vector from RFC 7636 appendix B
+var verifyPKCETests = []verifyPKCETest{
+	{
+		codeVerifier:   "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+		codeChallenge:  "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		method:         "S256",
+		expectedResult: true,
+	},
+	{
+		codeVerifier:   "wrong-verifier",
+		codeChallenge:  "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		method:         "S256",
+		expectedResult: false,
+	},
+	{
+		codeVerifier:   "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		codeChallenge:  "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		method:         "plain",
+		expectedResult: false,
+	},
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	for _, test := range verifyPKCETests {
+		result := VerifyPKCE(test.codeVerifier, test.codeChallenge, test.method)
+		if result != test.expectedResult {
+			t.Errorf("Output %t not equal to expected %t", result, test.expectedResult)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// PasswordHasher produces and verifies stored password hashes. Hashes are self-describing (PHC string
+// format for argon2id, modular crypt format for bcrypt) so the algorithm and parameters can change over time.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Matches reports whether encoded was produced by this algorithm
+	Matches(encoded string) bool
+	// Verify returns ErrPasswordMismatch when the password is wrong
+	Verify(password, encoded string) error
+	// NeedsRehash reports whether encoded was produced with different parameters than the hasher's
+	NeedsRehash(encoded string) bool
+}
+
+type Argon2idHasher struct {
+	MemoryKiB   uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+// NewArgon2idHasher uses the parameters recommended by OWASP as a baseline
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		MemoryKiB:   19 * 1024,
+		Iterations:  2,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.MemoryKiB, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.MemoryKiB, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.MemoryKiB, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.MemoryKiB != h.MemoryKiB || params.Iterations != h.Iterations || params.Parallelism != h.Parallelism ||
+		len(salt) != h.SaltLength || uint32(len(key)) != h.KeyLength
+}
+
+func decodeArgon2id(encoded string) (Argon2idHasher, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2idHasher{}, nil, nil, errors.New("hash is not argon2id")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2idHasher{}, nil, nil, errors.New("unsupported argon2 version")
+	}
+
+	params := Argon2idHasher{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.MemoryKiB, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return Argon2idHasher{}, nil, nil, errors.New("invalid argon2 parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2idHasher{}, nil, nil, errors.New("invalid argon2 salt")
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2idHasher{}, nil, nil, errors.New("invalid argon2 hash")
+	}
+
+	return params, salt, key, nil
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{
+		Cost: cost,
+	}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+
+	return err
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// Passwords hashes new passwords with the current hasher and still verifies hashes made by the others,
+// so stored hashes can be upgraded when users log in.
+type Passwords struct {
+	current PasswordHasher
+	hashers []PasswordHasher
+	dummy   string
+}
+
+func NewPasswords(current PasswordHasher, others ...PasswordHasher) *Passwords {
+	// The dummy hash only has to cost as much to verify as a real one, its password is never checked
+	dummy, _ := current.Hash("chirpy dummy password")
+
+	return &Passwords{
+		current: current,
+		hashers: append([]PasswordHasher{current}, others...),
+		dummy:   dummy,
+	}
+}
+
+// DummyHash is a hash made with the current hasher. Verifying against it when there is no user takes as long
+// as a wrong password, so response times don't reveal which accounts exist.
+func (p *Passwords) DummyHash() string {
+	return p.dummy
+}
+
+func (p *Passwords) Hash(password string) (string, error) {
+	return p.current.Hash(password)
+}
+
+// Verify checks the password and reports whether the stored hash should be replaced by a new one
+func (p *Passwords) Verify(password, encoded string) (bool, error) {
+	for _, h := range p.hashers {
+		if !h.Matches(encoded) {
+			continue
+		}
+
+		err := h.Verify(password, encoded)
+		if err != nil {
+			return false, err
+		}
+
+		return h != p.current || p.current.NeedsRehash(encoded), nil
+	}
+
+	return false, errors.New("unsupported password hash format")
+}
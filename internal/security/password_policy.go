@@ -0,0 +1,141 @@
+package security
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/bobby-lin/chirpy/internal/utils"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BreachedPasswords holds SHA-1 hashes of known breached passwords, bucketed by the 5 character hash
+// prefix the same way as the Have I Been Pwned k-anonymity range API.
+type BreachedPasswords struct {
+	ranges map[string]map[string]int
+}
+
+// LoadBreachedPasswords reads a file with one uppercase SHA-1 hash per line, optionally followed by
+// ":count", e.g. the HIBP "ordered by hash" download.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	b := &BreachedPasswords{
+		ranges: map[string]map[string]int{},
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		hash, countValue, _ := strings.Cut(line, ":")
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("invalid breached password hash: %s", hash)
+		}
+
+		count := 1
+		if countValue != "" {
+			count, err = strconv.Atoi(countValue)
+			if err != nil {
+				return nil, fmt.Errorf("invalid breached password count: %s", countValue)
+			}
+		}
+
+		hash = strings.ToUpper(hash)
+		prefix, suffix := hash[:5], hash[5:]
+		if b.ranges[prefix] == nil {
+			b.ranges[prefix] = map[string]int{}
+		}
+		b.ranges[prefix][suffix] = count
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Count returns how many times the password appeared in breaches
+func (b *BreachedPasswords) Count(password string) int {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	return b.ranges[hash[:5]][hash[5:]]
+}
+
+type PasswordPolicy struct {
+	MinLength int
+	// MaxBytes defaults to 72 since bcrypt ignores everything after that
+	MaxBytes int
+	// MinCharClasses is how many of lowercase, uppercase, digits and symbols must be used
+	MinCharClasses int
+	// Breached is optional
+	Breached *BreachedPasswords
+}
+
+func NewPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:      8,
+		MaxBytes:       72,
+		MinCharClasses: 0,
+	}
+}
+
+// Validate returns a utils.ValidationError describing every rule the password breaks, or nil
+func (p *PasswordPolicy) Validate(password, email string) error {
+	errs := &utils.ValidationError{}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		errs.Add("password", "too_short", fmt.Sprintf("password must be at least %d characters", p.MinLength))
+	}
+
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		errs.Add("password", "too_long", fmt.Sprintf("password must be at most %d bytes", p.MaxBytes))
+	}
+
+	if charClasses(password) < p.MinCharClasses {
+		errs.Add("password", "too_simple",
+			fmt.Sprintf("password must use at least %d of lowercase, uppercase, digits and symbols", p.MinCharClasses))
+	}
+
+	if email != "" && strings.EqualFold(password, email) {
+		errs.Add("password", "equals_email", "password must not be the same as the email")
+	}
+
+	if p.Breached != nil && p.Breached.Count(password) > 0 {
+		errs.Add("password", "breached", "password has appeared in a data breach, choose another one")
+	}
+
+	return errs.Err()
+}
+
+func charClasses(password string) int {
+	var lower, upper, digit, symbol int
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+
+	return lower + upper + digit + symbol
+}
@@ -0,0 +1,57 @@
+package security
+
+import (
+	"errors"
+	"github.com/bobby-lin/chirpy/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type passwordPolicyTest struct {
+	password      string
+	email         string
+	expectedCodes []string
+}
+
+var passwordPolicyTests = []passwordPolicyTest{
+	{password: "correct horse battery", email: "walt@breakingbad.com", expectedCodes: nil},
+	{password: "short", email: "walt@breakingbad.com", expectedCodes: []string{"too_short"}},
+	{password: strings.Repeat("a", 73), email: "walt@breakingbad.com", expectedCodes: []string{"too_long"}},
+	{password: "walt@breakingbad.com", email: "WALT@breakingbad.com", expectedCodes: []string{"equals_email"}},
+	{password: "password", email: "walt@breakingbad.com", expectedCodes: []string{"breached"}},
+	{password: "", email: "walt@breakingbad.com", expectedCodes: []string{"too_short"}},
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	// SHA-1 of "password"
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(path, []byte("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	breached, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := NewPasswordPolicy()
+	policy.Breached = breached
+
+	for _, test := range passwordPolicyTests {
+		codes := make([]string, 0)
+
+		var validationErr *utils.ValidationError
+		if errors.As(policy.Validate(test.password, test.email), &validationErr) {
+			for _, f := range validationErr.Fields {
+				codes = append(codes, f.Code)
+			}
+		}
+
+		if strings.Join(codes, ",") != strings.Join(test.expectedCodes, ",") {
+			t.Errorf("Output %v not equal to expected %v for %q", codes, test.expectedCodes, test.password)
+		}
+	}
+}
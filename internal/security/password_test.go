@@ -0,0 +1,51 @@
+package security
+
+import (
+	"testing"
+)
+
+func TestPasswords(t *testing.T) {
+	argon := &Argon2idHasher{MemoryKiB: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	bcryptHasher := NewBcryptHasher(4)
+	passwords := NewPasswords(argon, bcryptHasher)
+
+	legacyHash, err := bcryptHasher.Hash("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	needsRehash, err := passwords.Verify("hunter2", legacyHash)
+	if err != nil || !needsRehash {
+		t.Errorf("bcrypt hash should verify and need a rehash, got %t %v", needsRehash, err)
+	}
+
+	hash, err := passwords.Hash("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	needsRehash, err = passwords.Verify("hunter2", hash)
+	if err != nil || needsRehash {
+		t.Errorf("argon2id hash should verify without a rehash, got %t %v", needsRehash, err)
+	}
+
+	_, err = passwords.Verify("hunter3", hash)
+	if err != ErrPasswordMismatch {
+		t.Errorf("Output %v not equal to expected %v", err, ErrPasswordMismatch)
+	}
+
+	// Unknown accounts are checked against the dummy hash, which costs as much as a real one
+	if !argon.Matches(passwords.DummyHash()) {
+		t.Errorf("Dummy hash %q should be made by the current hasher", passwords.DummyHash())
+	}
+	_, err = passwords.Verify("hunter2", passwords.DummyHash())
+	if err != ErrPasswordMismatch {
+		t.Errorf("Output %v not equal to expected %v", err, ErrPasswordMismatch)
+	}
+
+	argon.Iterations = 2
+	needsRehash, err = passwords.Verify("hunter2", hash)
+	if err != nil || !needsRehash {
+		t.Errorf("argon2id hash with old parameters should need a rehash, got %t %v", needsRehash, err)
+	}
+}
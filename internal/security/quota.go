@@ -0,0 +1,58 @@
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+type quotaWindow struct {
+	start time.Time
+	count int
+}
+
+// Quota counts actions per key in fixed windows, e.g. chirps posted per hour. The limit is passed on
+// every call since it depends on the user's entitlements.
+type Quota struct {
+	mux     *sync.Mutex
+	window  time.Duration
+	windows map[string]quotaWindow
+	now     func() time.Time
+}
+
+func NewQuota(window time.Duration) *Quota {
+	return &Quota{
+		mux:     &sync.Mutex{},
+		window:  window,
+		windows: map[string]quotaWindow{},
+		now:     time.Now,
+	}
+}
+
+// Allow counts the action and reports whether it is within the limit, and if not how long until the window resets
+func (q *Quota) Allow(key string, limit int) (bool, time.Duration) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	now := q.now()
+
+	// Forget finished windows so the map doesn't grow forever
+	for k, v := range q.windows {
+		if now.Sub(v.start) >= q.window {
+			delete(q.windows, k)
+		}
+	}
+
+	w, ok := q.windows[key]
+	if !ok {
+		w = quotaWindow{start: now}
+	}
+
+	if w.count >= limit {
+		return false, w.start.Add(q.window).Sub(now)
+	}
+
+	w.count++
+	q.windows[key] = w
+
+	return true, 0
+}
@@ -0,0 +1,37 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	q := NewQuota(time.Hour)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	q.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := q.Allow("user:1", 3); !ok {
+			t.Fatalf("Action %d should be allowed", i+1)
+		}
+	}
+
+	ok, retryAfter := q.Allow("user:1", 3)
+	if ok || retryAfter != time.Hour {
+		t.Errorf("Output %v, %s not equal to expected false, %s", ok, retryAfter, time.Hour)
+	}
+
+	// A higher limit, e.g. after upgrading, applies to the current window
+	if ok, _ := q.Allow("user:1", 10); !ok {
+		t.Errorf("Action should be allowed with a higher limit")
+	}
+
+	if ok, _ := q.Allow("user:2", 3); !ok {
+		t.Errorf("Keys should be counted separately")
+	}
+
+	now = now.Add(time.Hour)
+	if ok, _ := q.Allow("user:1", 3); !ok {
+		t.Errorf("Action should be allowed in a new window")
+	}
+}
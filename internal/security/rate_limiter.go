@@ -0,0 +1,190 @@
+package security
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Requests per Window on average, with bursts of up to Burst requests
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+	Burst    int
+}
+
+// ParseRateLimit reads limits like "60/1m" or "60/1m,burst=10". The burst defaults to the number of requests.
+func ParseRateLimit(s string) (RateLimit, error) {
+	l := RateLimit{}
+
+	spec, options, _ := strings.Cut(strings.TrimSpace(s), ",")
+	requests, window, ok := strings.Cut(spec, "/")
+	if !ok {
+		return l, fmt.Errorf("invalid rate limit %q, expected e.g. 60/1m", s)
+	}
+
+	var err error
+	l.Requests, err = strconv.Atoi(requests)
+	if err != nil || l.Requests <= 0 {
+		return l, fmt.Errorf("invalid rate limit %q: requests must be a positive number", s)
+	}
+
+	l.Window, err = time.ParseDuration(window)
+	if err != nil || l.Window <= 0 {
+		return l, fmt.Errorf("invalid rate limit %q: window must be a positive duration", s)
+	}
+
+	l.Burst = l.Requests
+	if options != "" {
+		burst, ok := strings.CutPrefix(strings.TrimSpace(options), "burst=")
+		if !ok {
+			return l, fmt.Errorf("invalid rate limit %q: unknown option %q", s, options)
+		}
+		l.Burst, err = strconv.Atoi(burst)
+		if err != nil || l.Burst <= 0 {
+			return l, fmt.Errorf("invalid rate limit %q: burst must be a positive number", s)
+		}
+	}
+
+	return l, nil
+}
+
+// Scale multiplies the requests and the burst, e.g. for plans with higher limits. Factors below 2 leave it as it is.
+func (l RateLimit) Scale(factor int) RateLimit {
+	if factor < 2 {
+		return l
+	}
+
+	return RateLimit{Requests: l.Requests * factor, Window: l.Window, Burst: l.Burst * factor}
+}
+
+// refillRate is the number of tokens added per second
+func (l RateLimit) refillRate() float64 {
+	return float64(l.Requests) / l.Window.Seconds()
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next request is allowed, zero when it is allowed now
+	RetryAfter time.Duration
+}
+
+// BucketStore keeps the token buckets. Take must be atomic so that several instances sharing a store
+// (e.g. Redis running a script) can't spend the same token twice.
+type BucketStore interface {
+	Take(key string, limit RateLimit, now time.Time) RateLimitResult
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// fullAt is when the bucket will have refilled completely, so it can be forgotten
+	fullAt time.Time
+}
+
+// MemoryBucketStore only works for a single instance
+type MemoryBucketStore struct {
+	mux       *sync.Mutex
+	buckets   map[string]bucket
+	lastSweep time.Time
+}
+
+// bucketSweepInterval is how often buckets that refilled completely are forgotten
+const bucketSweepInterval = time.Minute
+
+func NewMemoryBucketStore() *MemoryBucketStore {
+	return &MemoryBucketStore{
+		mux:     &sync.Mutex{},
+		buckets: map[string]bucket{},
+	}
+}
+
+func (s *MemoryBucketStore) Take(key string, limit RateLimit, now time.Time) RateLimitResult {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	rate := limit.refillRate()
+	capacity := float64(limit.Burst)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = bucket{tokens: capacity, updatedAt: now}
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updatedAt).Seconds()*rate)
+	b.updatedAt = now
+
+	result := RateLimitResult{
+		Limit: limit.Burst,
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = secondsToDuration((capacity - b.tokens) / rate)
+	b.fullAt = now.Add(result.Reset)
+	s.buckets[key] = b
+
+	if now.Sub(s.lastSweep) > bucketSweepInterval {
+		s.sweep(now)
+	}
+
+	return result
+}
+
+// sweep drops the buckets that are full by now, they behave the same as missing ones
+func (s *MemoryBucketStore) sweep(now time.Time) {
+	for k, b := range s.buckets {
+		if !now.Before(b.fullAt) {
+			delete(s.buckets, k)
+		}
+	}
+	s.lastSweep = now
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+type RateLimiter struct {
+	store  BucketStore
+	limit  RateLimit
+	prefix string
+	now    func() time.Time
+}
+
+// NewRateLimiter creates a limiter whose keys are namespaced by prefix so several limiters can share a store
+func NewRateLimiter(store BucketStore, prefix string, limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		store:  store,
+		limit:  limit,
+		prefix: prefix,
+		now:    time.Now,
+	}
+}
+
+func (l *RateLimiter) Limit() RateLimit {
+	return l.limit
+}
+
+// Allow takes a token from the bucket of key
+func (l *RateLimiter) Allow(key string) RateLimitResult {
+	return l.store.Take(l.prefix+key, l.limit, l.now())
+}
+
+// AllowScaled takes a token from the bucket of key, whose limit is scaled by factor
+func (l *RateLimiter) AllowScaled(key string, factor int) RateLimitResult {
+	return l.store.Take(l.prefix+key, l.limit.Scale(factor), l.now())
+}
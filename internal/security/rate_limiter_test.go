@@ -0,0 +1,96 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(NewMemoryBucketStore(), "test:", RateLimit{Requests: 60, Window: time.Minute, Burst: 3})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		result := l.Allow("user:1")
+		if !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf("Request %d should be allowed with %d remaining, got %+v", i+1, 2-i, result)
+		}
+	}
+
+	result := l.Allow("user:1")
+	if result.Allowed || result.RetryAfter != time.Second || result.Reset != time.Second*3 {
+		t.Errorf("Request should be limited for 1s, got %+v", result)
+	}
+
+	if !l.Allow("user:2").Allowed {
+		t.Errorf("Keys should be limited separately")
+	}
+
+	// One token is added every second
+	now = now.Add(time.Second)
+	if !l.Allow("user:1").Allowed {
+		t.Errorf("Request should be allowed after a token was added")
+	}
+	if l.Allow("user:1").Allowed {
+		t.Errorf("Request should be limited until the next token")
+	}
+
+	// Plans with a multiplier get a bigger bucket
+	for i := 0; i < 6; i++ {
+		if !l.AllowScaled("user:3", 2).Allowed {
+			t.Fatalf("Request %d should be allowed with a scaled limit", i+1)
+		}
+	}
+	if l.AllowScaled("user:3", 2).Allowed {
+		t.Errorf("Request should be limited once the scaled burst is used")
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected RateLimit
+	}{
+		{input: "60/1m", expected: RateLimit{Requests: 60, Window: time.Minute, Burst: 60}},
+		{input: "10/1s,burst=20", expected: RateLimit{Requests: 10, Window: time.Second, Burst: 20}},
+	}
+
+	for _, test := range tests {
+		output, err := ParseRateLimit(test.input)
+		if err != nil || output != test.expected {
+			t.Errorf("%q: output %+v, %v not equal to expected %+v", test.input, output, err, test.expected)
+		}
+	}
+
+	for _, input := range []string{"60", "0/1m", "60/x", "60/1m,size=2"} {
+		if _, err := ParseRateLimit(input); err == nil {
+			t.Errorf("%q should be invalid", input)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remoteAddr   string
+		forwardedFor []string
+		expected     string
+	}{
+		{remoteAddr: "203.0.113.7:5000", forwardedFor: []string{"1.2.3.4"}, expected: "203.0.113.7"},
+		{remoteAddr: "127.0.0.1:5000", forwardedFor: nil, expected: "127.0.0.1"},
+		{remoteAddr: "127.0.0.1:5000", forwardedFor: []string{"203.0.113.7"}, expected: "203.0.113.7"},
+		// The client can prepend anything, only the hops added by trusted proxies count
+		{remoteAddr: "127.0.0.1:5000", forwardedFor: []string{"1.2.3.4, 203.0.113.7", "10.0.0.2"}, expected: "203.0.113.7"},
+		{remoteAddr: "127.0.0.1:5000", forwardedFor: []string{"203.0.113.7, nonsense"}, expected: "127.0.0.1"},
+	}
+
+	for _, test := range tests {
+		if output := proxies.ClientIP(test.remoteAddr, test.forwardedFor); output != test.expected {
+			t.Errorf("%s %v: output %q not equal to expected %q", test.remoteAddr, test.forwardedFor, output, test.expected)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package security
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+type totpCodeTest struct {
+	unixTime     int64
+	expectedCode string
+}
+
+// Test vectors from RFC 6238 appendix B (SHA1), truncated to 6 digits
+var totpCodeTests = []totpCodeTest{
+	{unixTime: 59, expectedCode: "287082"},
+	{unixTime: 1111111109, expectedCode: "081804"},
+	{unixTime: 1234567890, expectedCode: "005924"},
+	{unixTime: 2000000000, expectedCode: "279037"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	for _, test := range totpCodeTests {
+		code, err := TOTPCode(secret, test.unixTime/totpPeriodSeconds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code != test.expectedCode {
+			t.Errorf("Output %s not equal to expected %s", code, test.expectedCode)
+		}
+
+		_, ok := ValidateTOTP(secret, test.expectedCode, time.Unix(test.unixTime+totpPeriodSeconds, 0))
+		if !ok {
+			t.Errorf("Code %s should be accepted one period later", test.expectedCode)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrWebhookSignatureInvalid = errors.New("webhook signature is invalid")
+	ErrWebhookTimestampExpired = errors.New("webhook timestamp is outside the tolerance")
+)
+
+// SignWebhook computes the signature header value for body, in the form "t=<unix time>,v1=<hex HMAC-SHA256>".
+// The timestamp is part of the signed payload so old requests can't be replayed with a new timestamp.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", t, webhookHMAC(secret, t, body))
+}
+
+// VerifyWebhookSignature checks a header produced by SignWebhook. Several v1 values are accepted so the
+// secret can be rotated without dropping deliveries.
+func VerifyWebhookSignature(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp string
+	var signatures []string
+
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrWebhookSignatureInvalid
+	}
+
+	age := now.Sub(time.Unix(unix, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrWebhookTimestampExpired
+	}
+
+	expected := []byte(webhookHMAC(secret, timestamp, body))
+	for _, s := range signatures {
+		if hmac.Equal(expected, []byte(s)) {
+			return nil
+		}
+	}
+
+	return ErrWebhookSignatureInvalid
+}
+
+func webhookHMAC(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
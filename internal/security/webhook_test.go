@@ -0,0 +1,39 @@
+package security
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":3}}`)
+	now := time.Unix(1700000000, 0)
+	tolerance := time.Minute * 5
+
+	header := SignWebhook("secret", now, body)
+
+	tests := []struct {
+		name     string
+		secret   string
+		header   string
+		body     []byte
+		now      time.Time
+		expected error
+	}{
+		{name: "valid", secret: "secret", header: header, body: body, now: now, expected: nil},
+		{name: "rotated secret", secret: "secret", header: header + ",v1=deadbeef", body: body, now: now, expected: nil},
+		{name: "wrong secret", secret: "other", header: header, body: body, now: now, expected: ErrWebhookSignatureInvalid},
+		{name: "tampered body", secret: "secret", header: header, body: []byte(`{"event":"user.upgraded","data":{"user_id":4}}`), now: now, expected: ErrWebhookSignatureInvalid},
+		{name: "replayed", secret: "secret", header: header, body: body, now: now.Add(time.Minute * 6), expected: ErrWebhookTimestampExpired},
+		{name: "missing signature", secret: "secret", header: "t=1700000000", body: body, now: now, expected: ErrWebhookSignatureInvalid},
+		{name: "empty header", secret: "secret", header: "", body: body, now: now, expected: ErrWebhookSignatureInvalid},
+	}
+
+	for _, test := range tests {
+		err := VerifyWebhookSignature(test.secret, test.header, test.body, test.now, tolerance)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%s: output %v not equal to expected %v", test.name, err, test.expected)
+		}
+	}
+}
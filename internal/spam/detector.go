@@ -0,0 +1,187 @@
+package spam
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Action string
+
+const (
+	ActionAllow Action = "allow"
+	// ActionQueue publishes the chirp only once a moderator approved it
+	ActionQueue Action = "queue"
+	// ActionThrottle asks the author to wait before posting again
+	ActionThrottle Action = "throttle"
+	ActionReject   Action = "reject"
+)
+
+type Config struct {
+	// DuplicateWindow is how far back chirps are compared with the new one
+	DuplicateWindow time.Duration
+	// DuplicateDistance is the largest simhash distance at which two chirps count as copies
+	DuplicateDistance int
+	// MaxDuplicates is the number of copies within the window after which new ones are rejected
+	MaxDuplicates int
+	// MinDuplicateLength is the number of letters and digits under which chirps aren't compared, short replies
+	// like "ok" or an emoji are legitimately repeated and their fingerprints are all alike
+	MinDuplicateLength int
+
+	// VelocityWindow and MaxVelocity throttle authors posting more than MaxVelocity chirps per window
+	VelocityWindow time.Duration
+	MaxVelocity    int
+
+	// MaxLinks and MaxLinkDensity, the share of words that are links, mark link heavy chirps
+	MaxLinks       int
+	MaxLinkDensity float64
+
+	// NewAccountAge is the age under which accounts are trusted less
+	NewAccountAge time.Duration
+
+	// QueueScore and RejectScore are the scores from which chirps are queued or rejected
+	QueueScore  int
+	RejectScore int
+}
+
+var DefaultConfig = Config{
+	DuplicateWindow:    time.Hour * 24,
+	DuplicateDistance:  6,
+	MaxDuplicates:      3,
+	MinDuplicateLength: 16,
+	VelocityWindow:     time.Minute,
+	MaxVelocity:        5,
+	MaxLinks:           3,
+	MaxLinkDensity:     0.5,
+	NewAccountAge:      time.Hour * 24,
+	QueueScore:         50,
+	RejectScore:        100,
+}
+
+// Scores added by each heuristic
+const (
+	duplicateScore  = 40
+	linkScore       = 30
+	newAccountScore = 20
+)
+
+// Post is a chirp by the author within the duplicate window
+type Post struct {
+	Body      string
+	CreatedAt time.Time
+}
+
+// Input describes the chirp about to be created
+type Input struct {
+	Body string
+	// AccountCreatedAt is zero for accounts created before it was recorded, they aren't considered new
+	AccountCreatedAt time.Time
+	// Recent are the author's chirps created within the duplicate window, including held and hidden ones
+	Recent []Post
+	Now    time.Time
+}
+
+type Verdict struct {
+	Action  Action   `json:"action"`
+	Score   int      `json:"score"`
+	Reasons []string `json:"reasons"`
+	// RetryAfter is set when the chirp is throttled
+	RetryAfter time.Duration `json:"-"`
+}
+
+type Detector struct {
+	config Config
+}
+
+func NewDetector(config Config) *Detector {
+	return &Detector{
+		config: config,
+	}
+}
+
+// Check scores the chirp. Posting too fast throttles regardless of the score, other heuristics add up.
+func (d *Detector) Check(in Input) Verdict {
+	v := Verdict{
+		Action:  ActionAllow,
+		Reasons: make([]string, 0),
+	}
+
+	if retryAfter := d.velocity(in); retryAfter > 0 {
+		v.Action = ActionThrottle
+		v.RetryAfter = retryAfter
+		v.Reasons = append(v.Reasons, fmt.Sprintf("more than %d chirps in %s", d.config.MaxVelocity, d.config.VelocityWindow))
+		return v
+	}
+
+	if copies := d.duplicates(in); copies > 0 {
+		v.Reasons = append(v.Reasons, fmt.Sprintf("%d near duplicate chirps", copies))
+		v.Score += duplicateScore
+		if copies >= d.config.MaxDuplicates {
+			v.Score += d.config.RejectScore
+		}
+	}
+
+	links := len(linkPattern.FindAllString(in.Body, -1))
+	words := len(strings.Fields(in.Body))
+	if links > 0 && (links >= d.config.MaxLinks || float64(links)/float64(words) > d.config.MaxLinkDensity) {
+		v.Reasons = append(v.Reasons, fmt.Sprintf("%d links in %d words", links, words))
+		v.Score += linkScore
+	}
+
+	// New accounts only count against chirps that already look suspicious
+	if v.Score > 0 && !in.AccountCreatedAt.IsZero() && in.Now.Sub(in.AccountCreatedAt) < d.config.NewAccountAge {
+		v.Reasons = append(v.Reasons, "new account")
+		v.Score += newAccountScore
+	}
+
+	switch {
+	case v.Score >= d.config.RejectScore:
+		v.Action = ActionReject
+	case v.Score >= d.config.QueueScore:
+		v.Action = ActionQueue
+	}
+
+	return v
+}
+
+// velocity returns how long until the author may post again, zero if they may now
+func (d *Detector) velocity(in Input) time.Duration {
+	since := in.Now.Add(-d.config.VelocityWindow)
+	inWindow := make([]time.Time, 0)
+	for _, p := range in.Recent {
+		if p.CreatedAt.After(since) {
+			inWindow = append(inWindow, p.CreatedAt)
+		}
+	}
+
+	if len(inWindow) < d.config.MaxVelocity {
+		return 0
+	}
+
+	// Wait until enough of them are out of the window
+	sort.Slice(inWindow, func(i, j int) bool {
+		return inWindow[i].Before(inWindow[j])
+	})
+	return inWindow[len(inWindow)-d.config.MaxVelocity].Sub(since)
+}
+
+func (d *Detector) duplicates(in Input) int {
+	if len(normalize(in.Body)) < d.config.MinDuplicateLength {
+		return 0
+	}
+
+	fingerprint := Simhash(in.Body)
+	since := in.Now.Add(-d.config.DuplicateWindow)
+
+	copies := 0
+	for _, p := range in.Recent {
+		if !p.CreatedAt.After(since) || len(normalize(p.Body)) < d.config.MinDuplicateLength {
+			continue
+		}
+		if Distance(fingerprint, Simhash(p.Body)) <= d.config.DuplicateDistance {
+			copies++
+		}
+	}
+	return copies
+}
@@ -0,0 +1,76 @@
+package spam
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimhash(t *testing.T) {
+	original := "Check out my amazing new crypto project and get rich quick with us today"
+
+	similar := []string{
+		original,
+		"CHECK OUT my amazing new crypto project and get rich quick with us today!!!",
+		"Check out my amazing new crypto project and get rich quick with us tonight",
+		"hey check out my amazing new crypto project and get rich quick with us today",
+		"Check out my amazing new crypt0 project and get rich quick with us today",
+	}
+	for _, s := range similar {
+		if d := Distance(Simhash(original), Simhash(s)); d > DefaultConfig.DuplicateDistance {
+			t.Errorf("%q: distance %d should be at most %d", s, d, DefaultConfig.DuplicateDistance)
+		}
+	}
+
+	different := "I had the best ramen of my life at the place around the corner yesterday"
+	if d := Distance(Simhash(original), Simhash(different)); d <= DefaultConfig.DuplicateDistance {
+		t.Errorf("%q: distance %d should be more than %d", different, d, DefaultConfig.DuplicateDistance)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	d := NewDetector(DefaultConfig)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	oldAccount := now.Add(-time.Hour * 24 * 30)
+	body := "Check out my amazing new crypto project and get rich quick with us today"
+
+	copiesOf := func(body string, n int, every time.Duration) []Post {
+		posts := make([]Post, 0, n)
+		for i := 0; i < n; i++ {
+			posts = append(posts, Post{Body: body, CreatedAt: now.Add(-every * time.Duration(i+1))})
+		}
+		return posts
+	}
+	copies := func(n int, every time.Duration) []Post {
+		return copiesOf(body, n, every)
+	}
+
+	tests := []struct {
+		name     string
+		input    Input
+		expected Action
+	}{
+		{name: "first chirp", input: Input{Body: body, AccountCreatedAt: oldAccount, Now: now}, expected: ActionAllow},
+		{name: "one copy", input: Input{Body: body, AccountCreatedAt: oldAccount, Recent: copies(1, time.Hour), Now: now}, expected: ActionAllow},
+		{name: "one copy from a new account", input: Input{Body: body, AccountCreatedAt: now.Add(-time.Hour), Recent: copies(1, time.Hour), Now: now}, expected: ActionQueue},
+		{name: "many copies", input: Input{Body: body, AccountCreatedAt: oldAccount, Recent: copies(3, time.Hour), Now: now}, expected: ActionReject},
+		// Short replies are repeated legitimately, and all look alike once normalized
+		{name: "short replies", input: Input{Body: "lol", AccountCreatedAt: now.Add(-time.Hour), Recent: copiesOf("lol", 3, time.Hour), Now: now}, expected: ActionAllow},
+		{name: "repeated emoji", input: Input{Body: "👍", AccountCreatedAt: oldAccount, Recent: copiesOf("👍", 3, time.Hour), Now: now}, expected: ActionAllow},
+		{name: "repeated short reply", input: Input{Body: "Thanks so much!", AccountCreatedAt: oldAccount, Recent: copiesOf("Thanks so much!", 3, time.Hour), Now: now}, expected: ActionAllow},
+		{name: "too fast", input: Input{Body: "hello", AccountCreatedAt: oldAccount, Recent: copies(5, time.Second), Now: now}, expected: ActionThrottle},
+		{name: "links", input: Input{Body: "https://a.example https://b.example https://c.example", Now: now}, expected: ActionAllow},
+		{name: "links from a new account", input: Input{Body: "https://a.example https://b.example", AccountCreatedAt: now, Now: now}, expected: ActionQueue},
+	}
+
+	for _, test := range tests {
+		v := d.Check(test.input)
+		if v.Action != test.expected {
+			t.Errorf("%s: output %s not equal to expected %s (%v)", test.name, v.Action, test.expected, v.Reasons)
+		}
+	}
+
+	v := d.Check(Input{Body: "hello", Recent: copies(5, time.Second), Now: now})
+	if v.RetryAfter != time.Minute-time.Second*5 {
+		t.Errorf("Output %s not equal to expected %s", v.RetryAfter, time.Minute-time.Second*5)
+	}
+}
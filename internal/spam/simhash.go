@@ -0,0 +1,78 @@
+package spam
+
+import (
+	"github.com/bobby-lin/chirpy/internal/profanity"
+	"hash/fnv"
+	"math/bits"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// shingleSize is the number of characters in a shingle. Characters rather than words give short chirps enough
+// shingles for their fingerprints to be stable.
+const shingleSize = 4
+
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+// normalize keeps the letters and digits of the normalized text. Links are reduced to their host so tracking
+// parameters don't make copies look different.
+func normalize(text string) []rune {
+	text = linkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		host := strings.SplitN(strings.SplitN(link, "://", 2)[1], "/", 2)[0]
+		return " " + host + " "
+	})
+
+	return []rune(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return r
+		}
+		return -1
+	}, profanity.Normalize(text)))
+}
+
+// shingles splits the normalized text into overlapping runs of characters
+func shingles(text string) []string {
+	runes := normalize(text)
+	if len(runes) <= shingleSize {
+		return []string{string(runes)}
+	}
+
+	result := make([]string, 0, len(runes)-shingleSize+1)
+	for i := 0; i+shingleSize <= len(runes); i++ {
+		result = append(result, string(runes[i:i+shingleSize]))
+	}
+	return result
+}
+
+// Simhash fingerprints text so that similar texts get fingerprints that differ in few bits
+func Simhash(text string) uint64 {
+	var weights [64]int
+
+	for _, s := range shingles(text) {
+		h := fnv.New64a()
+		h.Write([]byte(s))
+		sum := h.Sum64()
+
+		for i := 0; i < 64; i++ {
+			if sum&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for i := 0; i < 64; i++ {
+		if weights[i] > 0 {
+			fingerprint |= 1 << i
+		}
+	}
+	return fingerprint
+}
+
+// Distance is the number of bits that differ between two fingerprints
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
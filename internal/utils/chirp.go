@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/bobby-lin/chirpy/internal/profanity"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ChirpURLLength is how many characters a link counts as, however long it is
+const ChirpURLLength = 23
+
+var urlPattern = regexp.MustCompile(`https?://\S+`)
+
+// Chirp is the content going through a ChirpPipeline. Steps may rewrite it.
+type Chirp struct {
+	Body  string
+	Media []string
+	// Flags are the names of the profanity rules that asked for a review by a moderator
+	Flags []string
+}
+
+// ChirpStep is one check of a ChirpPipeline. It reports problems by adding to errs.
+type ChirpStep func(c *Chirp, errs *ValidationError)
+
+// ChirpPipeline runs every step in order and collects their errors, so the same rules apply wherever chirps
+// are written.
+type ChirpPipeline struct {
+	steps []ChirpStep
+}
+
+func NewChirpPipeline(steps ...ChirpStep) *ChirpPipeline {
+	return &ChirpPipeline{
+		steps: steps,
+	}
+}
+
+// Validate returns the cleaned chirp, or a *ValidationError
+func (p *ChirpPipeline) Validate(body string, media []string) (Chirp, error) {
+	c := Chirp{
+		Body:  body,
+		Media: media,
+	}
+	errs := &ValidationError{}
+
+	for _, step := range p.steps {
+		step(&c, errs)
+	}
+
+	return c, errs.Err()
+}
+
+// StripControlChars removes control and invisible formatting characters, except for new lines and tabs
+func StripControlChars() ChirpStep {
+	return func(c *Chirp, errs *ValidationError) {
+		c.Body = strings.Map(func(r rune) rune {
+			if r == '\n' || r == '\t' {
+				return r
+			}
+			if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+				return -1
+			}
+			return r
+		}, c.Body)
+	}
+}
+
+// RequireBody rejects chirps that are empty or only whitespace
+func RequireBody() ChirpStep {
+	return func(c *Chirp, errs *ValidationError) {
+		if strings.TrimSpace(c.Body) == "" {
+			errs.Add("body", "empty", "Chirp is empty")
+		}
+	}
+}
+
+// ChirpLength counts characters rather than bytes, with every link counting as ChirpURLLength
+func ChirpLength(body string) int {
+	length := utf8.RuneCountInString(body)
+	for _, u := range urlPattern.FindAllString(body, -1) {
+		length += ChirpURLLength - utf8.RuneCountInString(u)
+	}
+	return length
+}
+
+func MaxLength(limit int) ChirpStep {
+	return func(c *Chirp, errs *ValidationError) {
+		if ChirpLength(c.Body) > limit {
+			errs.Add("body", "too_long", fmt.Sprintf("Chirp is too long, the limit is %d characters", limit))
+		}
+	}
+}
+
+// MaxMedia limits the number of attached media, which must be http(s) URLs
+func MaxMedia(limit int) ChirpStep {
+	return func(c *Chirp, errs *ValidationError) {
+		if len(c.Media) > limit {
+			errs.Add("media", "too_many", fmt.Sprintf("at most %d media can be attached", limit))
+		}
+
+		for _, m := range c.Media {
+			u, err := url.Parse(m)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				errs.Add("media", "invalid_url", "invalid media url: "+m)
+			}
+		}
+	}
+}
+
+// FilterProfanity masks, rejects or flags the body depending on the rule that matched
+func FilterProfanity(engine *profanity.Engine) ChirpStep {
+	return func(c *Chirp, errs *ValidationError) {
+		result := engine.Check(c.Body)
+		if result.Rejected() {
+			errs.Add("body", "prohibited", "Chirp contains prohibited content")
+			return
+		}
+
+		c.Body = result.Text
+		for _, m := range result.Matches {
+			if m.Action == profanity.ActionFlag {
+				c.Flags = append(c.Flags, m.Rule)
+			}
+		}
+	}
+}
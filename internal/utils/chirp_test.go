@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"errors"
+	"github.com/bobby-lin/chirpy/internal/profanity"
+	"strings"
+	"testing"
+)
+
+type chirpPipelineTest struct {
+	body         string
+	media        []string
+	expectedBody string
+	expectedCode string
+}
+
+var chirpPipelineTests = []chirpPipelineTest{
+	{body: "hello world", expectedBody: "hello world"},
+	{body: "i love kerfuffle!", expectedBody: "i love ****!"},
+	{body: "hello\u0000 wor\u200bld\n", expectedBody: "hello world\n"},
+	{body: "   \n\t", expectedCode: "empty"},
+	{body: "", expectedCode: "empty"},
+	// 20 characters but 40 bytes
+	{body: strings.Repeat("é", 20), expectedBody: strings.Repeat("é", 20)},
+	{body: strings.Repeat("é", 21), expectedCode: "too_long"},
+	// Links count as ChirpURLLength, however long they are
+	{body: "https://example.com/" + strings.Repeat("a", 100), expectedCode: "too_long"},
+	{body: "hi", media: []string{"https://example.com/a.png", "https://example.com/b.png"}, expectedCode: "too_many"},
+	{body: "hi", media: []string{"javascript:alert(1)"}, expectedCode: "invalid_url"},
+}
+
+func TestChirpPipeline(t *testing.T) {
+	engine, _ := profanity.NewEngine("")
+	p := NewChirpPipeline(StripControlChars(), RequireBody(), MaxLength(20), MaxMedia(1), FilterProfanity(engine))
+
+	for _, test := range chirpPipelineTests {
+		c, err := p.Validate(test.body, test.media)
+
+		var validationErr *ValidationError
+		if test.expectedCode == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error %v", test.body, err)
+			}
+		} else if !errors.As(err, &validationErr) || validationErr.Fields[0].Code != test.expectedCode {
+			t.Errorf("%q: output %v not equal to expected %s", test.body, err, test.expectedCode)
+		}
+
+		if test.expectedBody != "" && c.Body != test.expectedBody {
+			t.Errorf("Output %q not equal to expected %q", c.Body, test.expectedBody)
+		}
+	}
+}
+
+func TestChirpLength(t *testing.T) {
+	if output := ChirpLength("see https://example.com/a/very/long/path/to/something"); output != 4+ChirpURLLength {
+		t.Errorf("Output %d not equal to expected %d", output, 4+ChirpURLLength)
+	}
+}
@@ -1,25 +1,79 @@
 package utils
 
 import (
+	"errors"
+	"github.com/bobby-lin/chirpy/internal/profanity"
+	"net/mail"
 	"strings"
 )
 
+// FilterWords masks the default profanity list, see profanity.DefaultConfig. Use a profanity.Engine for
+// configurable rules.
 func FilterWords(body string) (string, bool) {
-	const filtered = "****"
-	disallowedList := []string{"kerfuffle", "sharbert", "fornax"}
-	isFiltered := false
-	bodyWords := strings.Split(body, " ")
-
-	for i, w := range bodyWords {
-		for _, fw := range disallowedList {
-			if strings.ToLower(w) == fw {
-				bodyWords[i] = filtered
-				isFiltered = true
-			}
-		}
+	result := profanity.Default().Check(body)
+	return result.Text, result.Masked()
+}
+
+// ValidateEmail only accepts a bare address, e.g. "a@b.com" but not "A <a@b.com>"
+func ValidateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return errors.New("email is invalid")
+	}
+
+	if !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return errors.New("email is invalid")
+	}
+
+	return nil
+}
+
+// FieldError describes why a single request field is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError groups the field errors of a request so they can all be reported at once
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Add(field, code, message string) {
+	e.Fields = append(e.Fields, FieldError{
+		Field:   field,
+		Code:    code,
+		Message: message,
+	})
+}
+
+// Merge adds the fields of a *ValidationError, or err as a single invalid field when it is any other error
+func (e *ValidationError) Merge(field string, err error) {
+	if err == nil {
+		return
 	}
 
-	finalBody := strings.Join(bodyWords, " ")
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		e.Fields = append(e.Fields, validationErr.Fields...)
+		return
+	}
+	e.Add(field, "invalid", err.Error())
+}
 
-	return finalBody, isFiltered
+// Err returns nil when there are no field errors, so it can be returned directly
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
 }
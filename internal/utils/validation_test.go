@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -33,8 +36,8 @@ var filterWordsTests = []filterWordsTest{
 	},
 	{
 		body:               "i love kerfuffle!",
-		expectedReturnBody: "i love kerfuffle!",
-		expectedIsFiltered: false,
+		expectedReturnBody: "i love ****!",
+		expectedIsFiltered: true,
 	}, {
 		body:               "Sharbert love kerfuffle man",
 		expectedReturnBody: "**** love **** man",
@@ -55,3 +58,52 @@ func TestFilterWords(t *testing.T) {
 
 	}
 }
+
+type validateEmailTest struct {
+	email         string
+	expectedValid bool
+}
+
+var validateEmailTests = []validateEmailTest{
+	{email: "walt@breakingbad.com", expectedValid: true},
+	{email: "walt+chirpy@breaking.bad.com", expectedValid: true},
+	{email: "walt", expectedValid: false},
+	{email: "", expectedValid: false},
+	{email: "walt@localhost", expectedValid: false},
+	{email: "Walt <walt@breakingbad.com>", expectedValid: false},
+	{email: "walt@breakingbad.com\r\nBcc: x@y.com", expectedValid: false},
+}
+
+func TestValidateEmail(t *testing.T) {
+	for _, test := range validateEmailTests {
+		err := ValidateEmail(test.email)
+		if (err == nil) != test.expectedValid {
+			t.Errorf("Output %t not equal to expected %t for %q", err == nil, test.expectedValid, test.email)
+		}
+	}
+}
+
+type mergeTest struct {
+	err            error
+	expectedFields []FieldError
+}
+
+var mergeTests = []mergeTest{
+	{err: nil, expectedFields: nil},
+	{err: &ValidationError{Fields: []FieldError{{Field: "password", Code: "too_short", Message: "password is too short"}}},
+		expectedFields: []FieldError{{Field: "password", Code: "too_short", Message: "password is too short"}}},
+	{err: fmt.Errorf("wrapped: %w", &ValidationError{Fields: []FieldError{{Field: "password", Code: "breached", Message: "password was breached"}}}),
+		expectedFields: []FieldError{{Field: "password", Code: "breached", Message: "password was breached"}}},
+	{err: errors.New("password can't be checked"),
+		expectedFields: []FieldError{{Field: "password", Code: "invalid", Message: "password can't be checked"}}},
+}
+
+func TestMerge(t *testing.T) {
+	for _, test := range mergeTests {
+		errs := &ValidationError{}
+		errs.Merge("password", test.err)
+		if !reflect.DeepEqual(errs.Fields, test.expectedFields) {
+			t.Errorf("Output %v not equal to expected %v", errs.Fields, test.expectedFields)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var errPrivateAddress = errors.New("webhook endpoint resolves to a private address")
+
+// Dispatcher sends queued outbound webhooks, retrying failed deliveries with exponential backoff until
+// MaxAttempts is reached, after which they are moved to the dead letters.
+type Dispatcher struct {
+	db          *database.DB
+	client      *http.Client
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	BatchSize   int
+}
+
+// NewDispatcher refuses to connect to loopback and private addresses unless allowPrivateNetworks is set,
+// since users choose the endpoint URLs.
+func NewDispatcher(db *database.DB, allowPrivateNetworks bool) *Dispatcher {
+	dialer := &net.Dialer{
+		Timeout: time.Second * 5,
+	}
+	if !allowPrivateNetworks {
+		dialer.Control = rejectPrivateAddress
+	}
+
+	return &Dispatcher{
+		db: db,
+		client: &http.Client{
+			Timeout: time.Second * 10,
+			Transport: &http.Transport{
+				DialContext: dialer.DialContext,
+			},
+		},
+		MaxAttempts: 8,
+		BaseBackoff: time.Second * 30,
+		MaxBackoff:  time.Hour * 6,
+		BatchSize:   20,
+	}
+}
+
+func rejectPrivateAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() {
+		return errPrivateAddress
+	}
+
+	return nil
+}
+
+// Backoff returns how long to wait after the given number of failed attempts
+func (d *Dispatcher) Backoff(attempts int) time.Duration {
+	backoff := d.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= d.MaxBackoff {
+			return d.MaxBackoff
+		}
+	}
+
+	return backoff
+}
+
+// DeliverDue sends every delivery that is due and returns how many succeeded
+func (d *Dispatcher) DeliverDue(ctx context.Context, now time.Time) (int, error) {
+	due, err := d.db.DueOutboundWebhooks(now, d.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, msg := range due {
+		endpoint, err := d.db.GetWebhookEndpoint(msg.EndpointID)
+		if err != nil {
+			continue
+		}
+
+		attempt := d.send(ctx, endpoint, msg)
+		ok := attempt.Error == ""
+
+		var nextAttemptAt *time.Time
+		if !ok && len(msg.Attempts)+1 < d.MaxAttempts {
+			next := attempt.At.Add(d.Backoff(len(msg.Attempts) + 1))
+			nextAttemptAt = &next
+		}
+
+		err = d.db.RecordOutboundAttempt(msg.ID, attempt, ok, nextAttemptAt)
+		if err != nil {
+			return delivered, err
+		}
+
+		if ok {
+			delivered++
+		}
+	}
+
+	return delivered, nil
+}
+
+func (d *Dispatcher) send(ctx context.Context, endpoint database.WebhookEndpoint, msg database.OutboundWebhook) database.WebhookAttempt {
+	now := time.Now().UTC()
+	attempt := database.WebhookAttempt{
+		At: now,
+	}
+
+	// The database file is indented, which also indents the stored payload
+	payload := &bytes.Buffer{}
+	err := json.Compact(payload, msg.Payload)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(payload.Bytes()))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set("Chirpy-Event", msg.Event)
+	req.Header.Set("Chirpy-Event-Id", msg.EventID)
+	req.Header.Set("Chirpy-Delivery", strconv.Itoa(msg.ID))
+	req.Header.Set("Chirpy-Signature", security.SignWebhook(endpoint.Secret, now, payload.Bytes()))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("endpoint responded with status %d", resp.StatusCode)
+	}
+
+	return attempt
+}
+
+// Run delivers due webhooks every interval until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := d.DeliverDue(ctx, time.Now().UTC())
+			if err != nil {
+				log.Printf("Error delivering webhooks: %s", err)
+			}
+		}
+	}
+}
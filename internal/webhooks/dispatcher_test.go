@@ -0,0 +1,90 @@
+package webhooks
+
+import (
+	"context"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeliverDue(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		err := security.VerifyWebhookSignature("secret", r.Header.Get("Chirpy-Signature"), body, time.Now(), time.Minute)
+		if err != nil {
+			t.Errorf("Invalid signature: %s", err)
+		}
+
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	walt, _ := db.CreateUser("walt@breakingbad.com", "hash")
+	jesse, _ := db.CreateUser("jesse@breakingbad.com", "hash")
+	endpoint, _ := db.CreateWebhookEndpoint(walt.ID, server.URL, []string{database.EventChirpCreated}, "secret")
+
+	db.CreateChirp("say my name", walt.ID)
+	// Endpoints registered by users don't receive events about other users
+	db.CreateChirp("yeah science", jesse.ID)
+
+	d := NewDispatcher(db, true)
+	d.MaxAttempts = 2
+
+	now := time.Now().UTC()
+	delivered, err := d.DeliverDue(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivered != 0 {
+		t.Errorf("Output %d not equal to expected %d", delivered, 0)
+	}
+
+	// Not due until the backoff is over
+	due, _ := db.DueOutboundWebhooks(now, 0)
+	if len(due) != 0 {
+		t.Errorf("Delivery should be waiting for its backoff, got %v", due)
+	}
+
+	failing = false
+	delivered, _ = d.DeliverDue(context.Background(), now.Add(d.Backoff(1)+time.Second))
+	if delivered != 1 {
+		t.Errorf("Output %d not equal to expected %d", delivered, 1)
+	}
+
+	deliveries, _ := db.GetOutboundWebhooks(endpoint.ID, "", 0)
+	if len(deliveries) != 1 || deliveries[0].Status != database.OutboundDelivered || len(deliveries[0].Attempts) != 2 {
+		t.Errorf("Expected one delivery delivered on the second attempt, got %v", deliveries)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{BaseBackoff: time.Second * 30, MaxBackoff: time.Minute * 5}
+
+	tests := map[int]time.Duration{
+		1: time.Second * 30,
+		2: time.Minute,
+		3: time.Minute * 2,
+		5: time.Minute * 5,
+	}
+
+	for attempts, expected := range tests {
+		if output := d.Backoff(attempts); output != expected {
+			t.Errorf("Output %s not equal to expected %s", output, expected)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"github.com/go-chi/chi/v5"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var accountLimiterConfig = security.LoginLimiterConfig{
+	FreeAttempts:     3,
+	LockoutThreshold: 10,
+	BaseDelay:        time.Second,
+	MaxDelay:         time.Minute,
+	LockoutDuration:  time.Minute * 15,
+	ResetAfter:       time.Hour,
+}
+
+// Many users can share an IP (e.g. behind NAT) so the IP limiter is more lenient
+var ipLimiterConfig = security.LoginLimiterConfig{
+	FreeAttempts:     20,
+	LockoutThreshold: 100,
+	BaseDelay:        time.Second,
+	MaxDelay:         time.Minute,
+	LockoutDuration:  time.Minute * 15,
+	ResetAfter:       time.Hour,
+}
+
+// clientIP trusts X-Forwarded-For only when the request comes from one of TRUSTED_PROXIES
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	return cfg.trustedProxies.ClientIP(r.RemoteAddr, r.Header.Values("X-Forwarded-For"))
+}
+
+// loginAttempt is an attempt reserved by reserveLoginAttempt. It counts as a failure from the start, so concurrent
+// guesses can't all pass the limiters, and is refunded by release unless fail was called.
+type loginAttempt struct {
+	cfg     *apiConfig
+	account string
+	ip      string
+	failed  bool
+}
+
+// reserveLoginAttempt responds with 429 and returns nil when the account or client IP has to back off. Callers
+// defer release on the attempt and call fail when the credentials are wrong.
+func (cfg *apiConfig) reserveLoginAttempt(w http.ResponseWriter, r *http.Request, email string) *loginAttempt {
+	attempt := &loginAttempt{cfg: cfg, account: database.NormalizeEmail(email), ip: cfg.clientIP(r)}
+
+	wait := cfg.accountLimiter.Reserve(attempt.account)
+	if wait == 0 {
+		wait = cfg.ipLimiter.Reserve(attempt.ip)
+		if wait > 0 {
+			cfg.accountLimiter.Release(attempt.account)
+		}
+	}
+
+	if wait == 0 {
+		return attempt
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithProblem(w, http.StatusTooManyRequests, codeRateLimited, "too many failed login attempts")
+	return nil
+}
+
+func (a *loginAttempt) fail() {
+	a.failed = true
+}
+
+func (a *loginAttempt) release() {
+	if a.failed {
+		return
+	}
+
+	a.cfg.accountLimiter.Release(a.account)
+	a.cfg.ipLimiter.Release(a.ip)
+}
+
+// recordLoginSuccess only clears the account. Clearing the IP would let an attacker reset it with their own account.
+func (cfg *apiConfig) recordLoginSuccess(email string) {
+	cfg.accountLimiter.Reset(database.NormalizeEmail(email))
+}
+
+func (cfg *apiConfig) handlerUnlockUser(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	paramValue := chi.URLParam(r, "userID")
+	userID, err := strconv.Atoi(paramValue)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user id value: "+paramValue)
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	cfg.recordLoginSuccess(user.Email)
+
+	w.WriteHeader(http.StatusOK)
+}
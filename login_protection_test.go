@@ -0,0 +1,101 @@
+package main
+
+import (
+	"github.com/bobby-lin/chirpy/internal/security"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginAttemptsCounted(t *testing.T) {
+	cfg := newTestConfig(t)
+	walt := createTestUser(t, cfg, "walt@breakingbad.com", "Str0ngPassw0rd!")
+	jesse := createTestUser(t, cfg, "jesse@breakingbad.com", "Str0ngPassw0rd!")
+
+	secret, _ := security.GenerateTOTPSecret()
+	cfg.db.SaveTwoFactorSecret(walt.ID, secret)
+	cfg.db.SaveTwoFactorSecret(jesse.ID, secret)
+	cfg.db.EnableTwoFactor(jesse.ID, 0, nil)
+	code, _ := security.TOTPCode(secret, time.Now().Unix()/30)
+
+	waltToken, _ := cfg.createAccessToken(walt, "", "")
+	jesseToken, _ := cfg.createAccessToken(jesse, "", "")
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		token   string
+		body    string
+	}{
+		{name: "verify wrong code", handler: cfg.handlerVerifyTwoFactor, token: waltToken, body: `{"code":"000000"}`},
+		{name: "disable wrong password", handler: cfg.handlerDisableTwoFactor, token: jesseToken, body: `{"password":"wrong","code":"` + code + `"}`},
+		{name: "disable wrong code", handler: cfg.handlerDisableTwoFactor, token: jesseToken, body: `{"password":"Str0ngPassw0rd!","code":"000000"}`},
+	}
+
+	for _, test := range tests {
+		// Each user gets the free attempts of the account limiter, then has to back off
+		for i := 0; i < accountLimiterConfig.FreeAttempts; i++ {
+			r := httptest.NewRequest("POST", "/api/users/2fa", strings.NewReader(test.body))
+			r.Header.Set("Authorization", "Bearer "+test.token)
+			w := httptest.NewRecorder()
+			test.handler(w, r)
+			if w.Code == http.StatusTooManyRequests || w.Code == http.StatusOK {
+				t.Fatalf("%s: attempt %d status %d", test.name, i+1, w.Code)
+			}
+		}
+
+		r := httptest.NewRequest("POST", "/api/users/2fa", strings.NewReader(test.body))
+		r.Header.Set("Authorization", "Bearer "+test.token)
+		w := httptest.NewRecorder()
+		test.handler(w, r)
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: status %d not equal to expected %d", test.name, w.Code, http.StatusTooManyRequests)
+		}
+
+		cfg.accountLimiter.Reset(walt.Email)
+		cfg.accountLimiter.Reset(jesse.Email)
+	}
+}
+
+func TestParallelLoginGuesses(t *testing.T) {
+	cfg := newTestConfig(t)
+	walt := createTestUser(t, cfg, "walt@breakingbad.com", "Str0ngPassw0rd!")
+
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	unauthorized := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email":"`+walt.Email+`","password":"wrong"}`))
+			w := httptest.NewRecorder()
+			cfg.handlerPostLogin(w, r)
+			if w.Code == http.StatusUnauthorized {
+				mux.Lock()
+				unauthorized++
+				mux.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Only the free attempts may reach the password check, the others are throttled
+	if unauthorized != accountLimiterConfig.FreeAttempts {
+		t.Errorf("Output %d checked passwords not equal to expected %d", unauthorized, accountLimiterConfig.FreeAttempts)
+	}
+
+	// A correct password is not counted as a failure
+	cfg.accountLimiter.Reset(walt.Email)
+	for i := 0; i < accountLimiterConfig.FreeAttempts+1; i++ {
+		r := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email":"`+walt.Email+`","password":"Str0ngPassw0rd!"}`))
+		w := httptest.NewRecorder()
+		cfg.handlerPostLogin(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("login %d: status %d not equal to expected %d", i+1, w.Code, http.StatusOK)
+		}
+	}
+}
@@ -1,27 +1,61 @@
 package main
 
 import (
+	"context"
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/mail"
+	"github.com/bobby-lin/chirpy/internal/openapi"
+	"github.com/bobby-lin/chirpy/internal/profanity"
 	"github.com/bobby-lin/chirpy/internal/security"
+	"github.com/bobby-lin/chirpy/internal/spam"
 	"github.com/bobby-lin/chirpy/internal/utils"
+	"github.com/bobby-lin/chirpy/internal/webhooks"
 	"github.com/go-chi/chi/v5"
 	"github.com/joho/godotenv"
-	"golang.org/x/crypto/bcrypt"
 	"log"
 	"net/http"
 	"os"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
+const profanityReloadInterval = time.Second * 30
+
 type apiConfig struct {
 	fileserverHits               int
 	db                           *database.DB
 	accessTokenExpiresInSeconds  int
 	refreshTokenExpiresInSeconds int
+	oauthIssuer                  string
+	oidcKey                      *rsa.PrivateKey
+	accountLimiter               *security.LoginLimiter
+	ipLimiter                    *security.LoginLimiter
+	adminEmails                  []string
+	mailer                       mail.Mailer
+	passwords                    *security.Passwords
+	passwordPolicy               *security.PasswordPolicy
+	accountDeletionGracePeriod   time.Duration
+	exportDir                    string
+	exportSlots                  chan struct{}
+	polkaAPIKey                  string
+	polkaWebhookSecret           string
+	chirpQuota                   *security.Quota
+	profanity                    *profanity.Engine
+	trustedProxies               security.TrustedProxies
+	rateLimiters                 map[string]*security.RateLimiter
+	rateLimitSubjects            *rateLimitSubjects
+	spam                         *spam.Detector
+	openAPI                      *openapi.Document
+	apiUsage                     *apiUsage
+	v1Lifecycle                  apiLifecycle
+	devMode                      bool
 }
 
 func main() {
@@ -37,19 +71,140 @@ func main() {
 		return
 	}
 
+	oidcKey, err := security.LoadOIDCSigningKey(os.Getenv("OIDC_SIGNING_KEY_FILE"))
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	oauthIssuer := os.Getenv("OAUTH_ISSUER")
+	if oauthIssuer == "" {
+		oauthIssuer = "http://localhost:8080"
+	}
+
+	adminEmails := make([]string, 0)
+	for _, v := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if v != "" {
+			adminEmails = append(adminEmails, database.NormalizeEmail(v))
+		}
+	}
+
+	attemptStore := security.NewMemoryAttemptStore()
+
+	trustedProxies, err := security.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	rateLimiters, err := rateLimitersFromEnv(security.NewMemoryBucketStore())
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	v1Lifecycle, err := apiLifecycleFromEnv(apiV1)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	var spamDetector *spam.Detector
+	if os.Getenv("SPAM_DETECTION") != "off" {
+		spamDetector = spam.NewDetector(spam.DefaultConfig)
+	}
+
+	mailer, err := newMailerFromEnv()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	passwords, err := newPasswordsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	passwordPolicy, err := newPasswordPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	gracePeriod, deletedChirpsPolicy, err := accountDeletionConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	exportDir := os.Getenv("EXPORT_DIR")
+	if exportDir == "" {
+		exportDir = "./exports"
+	}
+
+	err = os.MkdirAll(exportDir, 0700)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	err = failInterruptedDataExports(dbConn, exportDir)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	profanityEngine, err := profanity.NewEngine(os.Getenv("PROFANITY_CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	polkaWebhookSecret := os.Getenv("POLKA_WEBHOOK_SECRET")
+	if polkaWebhookSecret == "" {
+		log.Println("POLKA_WEBHOOK_SECRET is not set, falling back to unsigned Polka webhooks authenticated by POLKA_API_KEY")
+	}
+
+	go dbConn.RunAccountDeletionJob(context.Background(), accountDeletionJobInterval, deletedChirpsPolicy)
+	go dbConn.RunSubscriptionExpiryJob(context.Background(), subscriptionExpiryJobInterval)
+	go dbConn.RunDataExportExpiryJob(context.Background(), dataExportExpiryJobInterval)
+
+	go profanityEngine.Watch(context.Background(), profanityReloadInterval)
+
+	dispatcher := webhooks.NewDispatcher(dbConn, os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true")
+	go dispatcher.Run(context.Background(), webhookDispatchInterval)
+
 	apiCfg := apiConfig{
 		db:                           dbConn,
 		accessTokenExpiresInSeconds:  60 * 60,           // 1 hour
 		refreshTokenExpiresInSeconds: 60 * 60 * 24 * 60, // 60 days
-	}
-
-	r := chi.NewRouter()
-	r.Handle("/app", http.StripPrefix("/app", apiCfg.middlewareMetricsInc(http.FileServer(http.Dir("./app")))))
-	r.Handle("/app/*", http.StripPrefix("/app/assets/", apiCfg.middlewareMetricsInc(http.FileServer(http.Dir("./app/assets/")))))
-	r.Mount("/api", apiRouter(&apiCfg))
-	r.Mount("/admin", adminRouter(&apiCfg))
-
-	corsRouter := middlewareCors(r)
+		oauthIssuer:                  strings.TrimSuffix(oauthIssuer, "/"),
+		oidcKey:                      oidcKey,
+		accountLimiter:               security.NewLoginLimiter(attemptStore, "account:", accountLimiterConfig),
+		ipLimiter:                    security.NewLoginLimiter(attemptStore, "ip:", ipLimiterConfig),
+		adminEmails:                  adminEmails,
+		mailer:                       mailer,
+		passwords:                    passwords,
+		passwordPolicy:               passwordPolicy,
+		accountDeletionGracePeriod:   gracePeriod,
+		exportDir:                    exportDir,
+		exportSlots:                  make(chan struct{}, maxConcurrentExports),
+		polkaAPIKey:                  os.Getenv("POLKA_API_KEY"),
+		polkaWebhookSecret:           polkaWebhookSecret,
+		chirpQuota:                   security.NewQuota(chirpQuotaWindow),
+		profanity:                    profanityEngine,
+		trustedProxies:               trustedProxies,
+		rateLimiters:                 rateLimiters,
+		rateLimitSubjects:            newRateLimitSubjects(),
+		spam:                         spamDetector,
+		openAPI:                      apiSpec(strings.TrimSuffix(oauthIssuer, "/")),
+		apiUsage:                     newAPIUsage(),
+		v1Lifecycle:                  v1Lifecycle,
+		devMode:                      os.Getenv("DEV_MODE") == "true",
+	}
+
+	corsRouter := middlewareCors(newRouter(&apiCfg))
 
 	srv := &http.Server{
 		Addr:    ":8080",
@@ -60,40 +215,194 @@ func main() {
 	log.Fatal(srv.ListenAndServe())
 }
 
+func newRouter(apiCfg *apiConfig) chi.Router {
+	r := chi.NewRouter()
+	r.Use(middlewareRecover)
+	if apiCfg.devMode {
+		r.Use(apiCfg.middlewareValidateRequest)
+	}
+
+	r.Handle("/app", http.StripPrefix("/app", apiCfg.middlewareMetricsInc(http.FileServer(http.Dir("./app")))))
+	r.Handle("/app/*", http.StripPrefix("/app/assets/", apiCfg.middlewareMetricsInc(http.FileServer(http.Dir("./app/assets/")))))
+	r.Get("/.well-known/openid-configuration", apiCfg.handlerOpenIDConfiguration)
+	r.Mount("/api", apiRouter(apiCfg))
+	r.Mount("/admin", adminRouter(apiCfg))
+	return r
+}
+
 func adminRouter(apiCfg *apiConfig) http.Handler {
 	r := chi.NewRouter()
+	r.Use(apiCfg.middlewareRateLimit(rateLimitAdmin))
 	r.Get("/metrics", apiCfg.handlerMetric)
+	r.Get("/health", apiCfg.handlerHealth)
+	r.Get("/api-usage", apiCfg.handlerAPIUsage)
+	r.Post("/users/{userID}/unlock", apiCfg.handlerUnlockUser)
+	r.Post("/users/{userID}/suspend", apiCfg.handlerSuspendUser)
+	r.Delete("/users/{userID}/suspend", apiCfg.handlerUnsuspendUser)
+	r.Post("/users/{userID}/shadow-ban", apiCfg.handlerShadowBanUser)
+	r.Delete("/users/{userID}/shadow-ban", apiCfg.handlerUnshadowBanUser)
+	r.Get("/webhooks/polka/deliveries", apiCfg.handlerGetPolkaDeliveries)
+	r.Get("/reports", apiCfg.handlerGetReports)
+	r.Get("/reports/{reportID}", apiCfg.handlerGetReport)
+	r.Post("/reports/{reportID}/claim", apiCfg.handlerClaimReport)
+	r.Post("/reports/{reportID}/resolve", apiCfg.handlerResolveReport)
+	r.Get("/moderation/audit", apiCfg.handlerGetModerationAudit)
+	r.Post("/webhooks/endpoints", apiCfg.handlerAdminPostWebhookEndpoints)
+	r.Get("/webhooks/endpoints", apiCfg.handlerAdminGetWebhookEndpoints)
+	r.Delete("/webhooks/endpoints/{webhookID}", apiCfg.handlerAdminDeleteWebhookEndpoint)
+	r.Get("/webhooks/dead-letters", apiCfg.handlerGetWebhookDeadLetters)
+	r.Post("/webhooks/dead-letters/{deliveryID}/retry", apiCfg.handlerRetryWebhookDeadLetter)
 	return r
 }
 
-// Create API sub-routes
+// Create API sub-routes. Endpoints that are not versioned are added here, the rest by apiVersionRoutes.
 func apiRouter(apiCfg *apiConfig) http.Handler {
 	r := chi.NewRouter()
-	r.Get("/healthz", handlerReadiness)
-	r.HandleFunc("/reset", apiCfg.handlerReset)
+	r.Get("/healthz", apiCfg.handlerReadiness)
+	r.Get("/reset", apiCfg.handlerReset)
+	r.Post("/reset", apiCfg.handlerReset)
+	r.Get("/openapi.json", apiCfg.handlerOpenAPI)
+	r.Get("/docs", handlerDocs)
+	r.Get("/docs/{file}", handlerDocsAsset)
 
-	r.Post("/validate_chirp", handlerValidateChirp)
-	r.Get("/chirps", apiCfg.handlerGetChirps)
-	r.Get("/chirps/{chirpID}", apiCfg.handlerGetChirp)
-	r.Post("/chirps", apiCfg.handlerPostChirps)
-	r.Delete("/chirps/{chirpID}", apiCfg.handlerDeleteChirp)
+	r.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRateLimit(rateLimitWebhook))
 
-	r.Post("/users", apiCfg.handlerPostUsers)
-	r.Post("/login", apiCfg.handlerPostLogin)
-	r.Put("/users", apiCfg.handlerUpdateUsers)
+		r.Post("/polka/webhooks", apiCfg.handlerWebhook)
+	})
 
-	r.Post("/refresh", apiCfg.handlerRefreshToken)
-	r.Post("/revoke", apiCfg.handlerRevokeRefreshToken)
+	// OAuth and OpenID Connect endpoints follow their RFCs, so they don't change with the API version
+	r.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRateLimit(rateLimitAuth))
+
+		r.Post("/oauth/token", apiCfg.handlerOAuthToken)
+		r.Post("/oauth/introspect", apiCfg.handlerOAuthIntrospect)
+	})
 
-	r.Post("/polka/webhooks", apiCfg.handlerWebhook)
+	r.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRateLimit(rateLimitWrite))
+
+		r.Post("/oauth/authorize", apiCfg.handlerPostAuthorize)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRateLimit(rateLimitRead))
+
+		r.Get("/oauth/authorize", apiCfg.handlerGetAuthorize)
+		r.Get("/oauth/userinfo", apiCfg.handlerUserInfo)
+		r.Get("/oauth/jwks", apiCfg.handlerJWKS)
+	})
+
+	r.Route("/"+apiV1, func(r chi.Router) {
+		apiVersionRoutes(r, apiCfg, apiV1, false)
+	})
+	r.Route("/"+apiV2, func(r chi.Router) {
+		apiVersionRoutes(r, apiCfg, apiV2, false)
+	})
+	// Clients written before versioning use paths without a version, they get v1
+	r.Group(func(r chi.Router) {
+		apiVersionRoutes(r, apiCfg, apiV1, true)
+	})
 
 	return r
 }
 
+// apiVersionRoutes adds the endpoints that differ between versions of the API
+func apiVersionRoutes(r chi.Router, apiCfg *apiConfig, version string, unversioned bool) {
+	r.Use(apiCfg.middlewareAPIVersion(version, unversioned))
+
+	// Endpoints taking credentials get the strictest limit as they are what gets brute forced
+	r.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRateLimit(rateLimitAuth))
+
+		r.Post("/users", apiCfg.handlerPostUsers)
+		r.Post("/login", apiCfg.handlerPostLogin)
+		r.Post("/login/mfa", apiCfg.handlerPostLoginMFA)
+		r.Post("/users/email/confirm", apiCfg.handlerConfirmEmailChange)
+
+		r.Post("/users/verify-email/request", apiCfg.handlerRequestEmailVerification)
+		r.Post("/users/verify-email", apiCfg.handlerVerifyEmail)
+		r.Post("/users/password-reset/request", apiCfg.handlerRequestPasswordReset)
+		r.Post("/users/password-reset", apiCfg.handlerResetPassword)
+		r.Post("/users/2fa/verify", apiCfg.handlerVerifyTwoFactor)
+
+		r.Post("/refresh", apiCfg.handlerRefreshToken)
+		r.Post("/revoke", apiCfg.handlerRevokeRefreshToken)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRateLimit(rateLimitWrite))
+
+		r.Post("/validate_chirp", apiCfg.handlerValidateChirp)
+		r.Post("/chirps", apiCfg.handlerPostChirps)
+		r.Put("/chirps/{chirpID}", apiCfg.handlerPutChirp)
+		r.Delete("/chirps/{chirpID}", apiCfg.handlerDeleteChirp)
+		r.Post("/chirps/{chirpID}/report", apiCfg.handlerReportChirp)
+		r.Post("/users/{userID}/report", apiCfg.handlerReportUser)
+		r.Post("/users/{userID}/block", apiCfg.handlerBlockUser)
+		r.Delete("/users/{userID}/block", apiCfg.handlerUnblockUser)
+		r.Post("/users/{userID}/mute", apiCfg.handlerMuteUser)
+		r.Delete("/users/{userID}/mute", apiCfg.handlerUnmuteUser)
+
+		r.Put("/users", apiCfg.handlerUpdateUsers)
+		r.Patch("/users", apiCfg.handlerUpdateUsers)
+		r.Delete("/users", apiCfg.handlerDeleteUsers)
+		r.Post("/users/export", apiCfg.handlerPostDataExport)
+
+		r.Post("/users/2fa", apiCfg.handlerPostTwoFactor)
+		r.Post("/users/2fa/disable", apiCfg.handlerDisableTwoFactor)
+
+		r.Post("/tokens", apiCfg.handlerPostTokens)
+		r.Delete("/tokens/{tokenID}", apiCfg.handlerDeleteToken)
+
+		r.Post("/webhooks", apiCfg.handlerPostWebhookEndpoints)
+		r.Delete("/webhooks/{webhookID}", apiCfg.handlerDeleteWebhookEndpoint)
+
+		r.Post("/oauth/clients", apiCfg.handlerPostOAuthClients)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(apiCfg.middlewareRateLimit(rateLimitRead))
+
+		r.Get("/chirps", apiCfg.handlerGetChirps)
+		r.Get("/chirps/{chirpID}", apiCfg.handlerGetChirp)
+		r.Get("/users/me/blocks", apiCfg.handlerGetBlocks)
+		r.Get("/users/me/mutes", apiCfg.handlerGetMutes)
+		r.Get("/users/me/subscription", apiCfg.handlerGetSubscription)
+		r.Get("/users/export/{exportID}", apiCfg.handlerGetDataExport)
+		r.Get("/users/2fa/qr", apiCfg.handlerGetTwoFactorQRCode)
+		r.Get("/tokens", apiCfg.handlerGetTokens)
+
+		r.Get("/webhooks", apiCfg.handlerGetWebhookEndpoints)
+		r.Get("/webhooks/{webhookID}/deliveries", apiCfg.handlerGetWebhookEndpointDeliveries)
+	})
+}
+
+// middlewareRecover turns a panic in a handler into a 500 so one bad request doesn't take the server down
+func middlewareRecover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// Aborting a response is done by panicking, net/http handles it
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			log.Printf("Panic handling %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+			respondWithProblem(w, http.StatusInternalServerError, codeInternal, "something went wrong")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func middlewareCors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, PATCH, DELETE")
 		w.Header().Set("Access-Control-Allow-Headers", "*")
 
 		if r.Method == "OPTIONS" {
@@ -111,13 +420,40 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	})
 }
 
-func handlerReadiness(w http.ResponseWriter, r *http.Request) {
+// handlerReadiness reports 503 while the storage is degraded, so load balancers stop sending traffic until it
+// works again. The check reads the database itself so a recovered storage is noticed without other traffic.
+func (cfg *apiConfig) handlerReadiness(w http.ResponseWriter, r *http.Request) {
+	// Readiness follows the storage as it is now, not the outcome of the last request
+	status, text := http.StatusOK, http.StatusText(http.StatusOK)
+	err := cfg.db.Ping()
+	if err != nil {
+		log.Printf("Health check failed: %s", err)
+		status, text = http.StatusServiceUnavailable, "Degraded"
+	}
+
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	_, err := w.Write([]byte(http.StatusText(http.StatusOK)))
+	w.WriteHeader(status)
+	w.Write([]byte(text))
+}
+
+// handlerHealth shows admins why the storage is degraded
+func (cfg *apiConfig) handlerHealth(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateAdmin(r)
 	if err != nil {
-		log.Fatal(err)
+		respondWithAuthError(w, err)
+		return
 	}
+
+	health := cfg.db.Health()
+
+	w.Header().Set("Content-Type", "application/json")
+	if health.Degraded {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	dat, _ := json.Marshal(health)
+	w.Write(dat)
 }
 
 func (cfg *apiConfig) handlerMetric(w http.ResponseWriter, r *http.Request) {
@@ -126,9 +462,8 @@ func (cfg *apiConfig) handlerMetric(w http.ResponseWriter, r *http.Request) {
 	page := fmt.Sprintf("<html>\n\n<body>\n    <h1>Welcome, Chirpy Admin</h1>\n    <p>Chirpy has been visited %d times!</p>\n</body>\n\n</html>\n", cfg.fileserverHits)
 
 	_, err := w.Write([]byte(page))
-
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Error writing metrics: %s", err)
 	}
 }
 
@@ -136,13 +471,14 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 	cfg.fileserverHits = 0
 	_, err := w.Write([]byte("Reset count to " + strconv.Itoa(cfg.fileserverHits)))
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Error writing reset response: %s", err)
 	}
 }
 
-func handlerValidateChirp(w http.ResponseWriter, r *http.Request) {
+func (cfg *apiConfig) handlerValidateChirp(w http.ResponseWriter, r *http.Request) {
 	type requestBody struct {
-		Body string `json:"body"`
+		Body  string   `json:"body"`
+		Media []string `json:"media"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -150,35 +486,37 @@ func handlerValidateChirp(w http.ResponseWriter, r *http.Request) {
 	err := decoder.Decode(&reqBody)
 
 	if err != nil {
-		log.Printf("Error decoding request body: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "invalid request body")
 		return
 	}
 
-	if len(reqBody.Body) > 140 {
-		log.Printf("Chirp is too long")
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
-		return
+	// Signed in users are validated against their own plan
+	entitlements := security.FreeEntitlements
+	if userID, err := cfg.authenticateUser(r, security.ScopeChirpsWrite); err == nil {
+		entitlements = cfg.entitlementsFor(r, userID)
 	}
 
-	filteredBody, _ := utils.FilterWords(reqBody.Body)
+	c, err := cfg.chirpPipeline(entitlements).Validate(reqBody.Body, reqBody.Media)
+	if err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
 
-	respondWithJSON(w, http.StatusOK, filteredBody)
-}
+	// v2 returns the chirp as it would be posted instead of the cleaned_body of v1
+	if apiVersion(r) == apiV2 {
+		type responseBody struct {
+			Body  string   `json:"body"`
+			Media []string `json:"media,omitempty"`
+		}
 
-func respondWithError(w http.ResponseWriter, code int, msg string) {
-	type responseBody struct {
-		Error string `json:"error"`
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		dat, _ := json.Marshal(responseBody{Body: c.Body, Media: c.Media})
+		w.Write(dat)
+		return
 	}
 
-	respBody := responseBody{
-		Error: msg,
-	}
-	dat, _ := json.Marshal(respBody)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	w.Write(dat)
+	respondWithJSON(w, http.StatusOK, c.Body)
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -204,32 +542,41 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 }
 
 func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
-	token := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
-	claims, err := security.GetTokenClaims(token)
-
+	userID, err := cfg.authenticateUser(r, security.ScopeChirpsWrite)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "token is invalid")
+		respondWithAuthError(w, err)
 		return
 	}
 
-	issuer, err := claims.GetIssuer()
+	paramValue := chi.URLParam(r, "chirpID")
+	chirpID, err := strconv.Atoi(paramValue)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "token is invalid")
+		respondWithError(w, http.StatusBadRequest, "invalid chirp id value: "+paramValue)
 		return
 	}
 
-	if issuer != "chirpy-access" {
-		respondWithError(w, http.StatusUnauthorized, "action requires an access token")
+	err = cfg.db.DeleteChirps(userID, chirpID)
+	if err != nil {
+		respondWithErr(w, err)
 		return
 	}
 
-	id, err := claims.GetSubject()
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlerPutChirp edits a chirp, which is a Chirpy Red feature
+func (cfg *apiConfig) handlerPutChirp(w http.ResponseWriter, r *http.Request) {
+	userID, err := cfg.authenticateUser(r, security.ScopeChirpsWrite)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "user id is invalid")
+		respondWithAuthError(w, err)
 		return
 	}
 
-	userID, err := strconv.Atoi(id)
+	entitlements := cfg.entitlementsFor(r, userID)
+	if !entitlements.EditChirps {
+		respondWithError(w, http.StatusForbidden, "editing chirps requires Chirpy Red")
+		return
+	}
 
 	paramValue := chi.URLParam(r, "chirpID")
 	chirpID, err := strconv.Atoi(paramValue)
@@ -238,13 +585,45 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	statusCode, err := cfg.db.DeleteChirps(userID, chirpID)
+	type requestBody struct {
+		Body  string   `json:"body"`
+		Media []string `json:"media"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to update chirp")
+		return
+	}
+
+	content, err := cfg.chirpPipeline(entitlements).Validate(reqBody.Body, reqBody.Media)
+	if err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+
+	review, ok := cfg.checkSpam(w, userID, chirpID, content)
+	if !ok {
+		return
+	}
+
+	if !cfg.allowChirp(w, userID, entitlements) {
+		return
+	}
+
+	// Edits are reviewed like new chirps, so a chirp can't be posted clean and edited into spam
+	c, err := cfg.db.UpdateChirp(userID, chirpID, content.Body, content.Media, review)
 	if err != nil {
-		respondWithError(w, statusCode, err.Error())
+		respondWithErr(w, err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(c)
+	w.Write(dat)
 }
 
 func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request) {
@@ -256,10 +635,19 @@ func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, err := cfg.db.GetChirp(id)
+	viewerID, err := cfg.authenticateViewer(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	c, err := cfg.db.GetChirp(id, viewerID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "fail to get chirp with id "+chirpID)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		//respondWithError(w, http.StatusNotFound, "fail to get chirp with id "+chirpID)
+		respondWithErr(w, err)
 		return
 	}
 	file, _ := json.Marshal(c)
@@ -271,6 +659,12 @@ func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := cfg.authenticateViewer(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
 	paramAuthorID := r.URL.Query().Get("author_id")
 
 	if paramAuthorID == "" {
@@ -279,7 +673,7 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 
 	authorID, err := strconv.Atoi(paramAuthorID)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		respondWithError(w, http.StatusBadRequest, "invalid author id value: "+paramAuthorID)
 		return
 	}
 
@@ -290,9 +684,9 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 		sortOrder = "desc"
 	}
 
-	chirpsList, err := cfg.db.GetChirps(authorID)
+	chirpsList, err := cfg.db.GetChirps(authorID, viewerID)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "fail to get chirps")
+		respondWithError(w, http.StatusInternalServerError, "fail to get chirps")
 		return
 	}
 
@@ -314,44 +708,46 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handlerPostChirps(w http.ResponseWriter, r *http.Request) {
-	token := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
-	claims, err := security.GetTokenClaims(token)
-
+	userId, err := cfg.authenticateUser(r, security.ScopeChirpsWrite)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "token is invalid")
+		respondWithAuthError(w, err)
 		return
 	}
 
-	issuer, err := claims.GetIssuer()
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "token is invalid")
-		return
+	type requestBody struct {
+		Body  string   `json:"body"`
+		Media []string `json:"media"`
 	}
 
-	if issuer != "chirpy-access" {
-		respondWithError(w, http.StatusUnauthorized, "action requires an access token")
+	decoder := json.NewDecoder(r.Body)
+	reqBody := requestBody{}
+	err = decoder.Decode(&reqBody)
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to create chirp")
 		return
 	}
 
-	id, err := claims.GetSubject()
+	entitlements := cfg.entitlementsFor(r, userId)
+
+	content, err := cfg.chirpPipeline(entitlements).Validate(reqBody.Body, reqBody.Media)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "user id is invalid")
+		respondWithValidationError(w, err)
 		return
 	}
 
-	userId, err := strconv.Atoi(id)
-
-	type requestBody struct {
-		Body string `json:"body"`
+	review, ok := cfg.checkSpam(w, userId, 0, content)
+	if !ok {
+		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	reqBody := requestBody{}
-	err = decoder.Decode(&reqBody)
+	if !cfg.allowChirp(w, userId, entitlements) {
+		return
+	}
 
-	c, err := cfg.db.CreateChirp(reqBody.Body, userId)
+	// Flagged chirps are published but queued for review, likely spam is held until it is approved
+	c, err := cfg.db.CreateReviewedChirp(content.Body, userId, review, content.Media...)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "fail to create chirp")
+		respondWithErr(w, err)
 		return
 	}
 
@@ -371,15 +767,36 @@ func (cfg *apiConfig) handlerPostUsers(w http.ResponseWriter, r *http.Request) {
 	reqBody := requestBody{}
 	err := decoder.Decode(&reqBody)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "fail to create user")
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to create user")
+		return
+	}
+
+	err = cfg.validateCredentials(reqBody.Email, reqBody.Password)
+	if err != nil {
+		respondWithValidationError(w, err)
 		return
 	}
 
-	user, err := cfg.db.CreateUser(reqBody.Email, reqBody.Password)
+	passwordHash, err := cfg.passwords.Hash(reqBody.Password)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "fail to create user")
+		respondWithError(w, http.StatusInternalServerError, "fail to create user")
+		return
+	}
+
+	user, err := cfg.db.CreateUser(reqBody.Email, passwordHash)
+	if errors.Is(err, database.ErrEmailTaken) {
+		respondWithErr(w, err)
 		return
 	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to create user")
+		return
+	}
+
+	err = cfg.sendVerificationEmail(user)
+	if err != nil {
+		log.Printf("Error sending verification email: %s", err)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -398,26 +815,70 @@ func (cfg *apiConfig) handlerPostLogin(w http.ResponseWriter, r *http.Request) {
 	reqBody := requestBody{}
 	err := decoder.Decode(&reqBody)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "fail to login")
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to login")
 		return
 	}
 
 	email := reqBody.Email
 	password := reqBody.Password
 
+	attempt := cfg.reserveLoginAttempt(w, r, email)
+	if attempt == nil {
+		return
+	}
+	defer attempt.release()
+
+	// Unknown emails and wrong passwords get the same response so accounts can't be enumerated
 	user, err := cfg.db.GetUser(email)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		respondWithErr(w, err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "fail to login")
+		attempt.fail()
+		cfg.passwords.Verify(password, cfg.passwords.DummyHash())
+		respondWithProblem(w, http.StatusUnauthorized, codeInvalidCredentials, "incorrect email or password")
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	err = cfg.verifyPassword(user, password)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+		attempt.fail()
+		respondWithProblem(w, http.StatusUnauthorized, codeInvalidCredentials, "incorrect email or password")
+		return
+	}
+
+	// Suspended users are turned away before anything about the login is recorded
+	if user.SuspendedAt != nil {
+		respondWithErr(w, errSuspended)
+		return
+	}
+
+	// Failures are only cleared once the second factor has been verified too
+	tf, err := cfg.db.GetTwoFactor(user.ID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		respondWithErr(w, err)
+		return
+	}
+	if err == nil && tf.Enabled {
+		respondWithMFAChallenge(w, user)
 		return
 	}
 
-	accessToken, err := security.CreateJwtToken(user.ID, cfg.accessTokenExpiresInSeconds, "chirpy-access")
+	cfg.recordLoginSuccess(email)
+	cfg.cancelAccountDeletion(user)
+	cfg.respondWithLogin(w, r, user)
+}
+
+// respondWithLogin issues the access and refresh tokens once the user is fully authenticated. v2 nests the
+// user instead of listing some of its fields next to the tokens.
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	if user.SuspendedAt != nil {
+		respondWithErr(w, errSuspended)
+		return
+	}
+
+	accessToken, err := cfg.createAccessToken(user, "", "")
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "fail to generate accessToken")
 		return
@@ -429,21 +890,46 @@ func (cfg *apiConfig) handlerPostLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	type responseBody struct {
-		Id           int    `json:"id"`
-		Email        string `json:"email"`
-		IsChirpyRed  bool   `json:"is_chirpy_red"`
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-	}
+	isChirpyRed := user.ChirpyRedActive(time.Now().UTC())
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
+	if apiVersion(r) == apiV2 {
+		type responseBody struct {
+			User         database.User         `json:"user"`
+			Entitlements security.Entitlements `json:"entitlements"`
+			Token        string                `json:"token"`
+			RefreshToken string                `json:"refresh_token"`
+		}
+
+		user.Password = ""
+		user.IsChirpyRed = isChirpyRed
+
+		file, _ := json.Marshal(responseBody{
+			User:         user,
+			Entitlements: security.EntitlementsFor(isChirpyRed),
+			Token:        accessToken,
+			RefreshToken: refreshToken,
+		})
+		w.Write(file)
+		return
+	}
+
+	type responseBody struct {
+		Id           int                   `json:"id"`
+		Email        string                `json:"email"`
+		IsChirpyRed  bool                  `json:"is_chirpy_red"`
+		Entitlements security.Entitlements `json:"entitlements"`
+		Token        string                `json:"token"`
+		RefreshToken string                `json:"refresh_token"`
+	}
+
 	resp := responseBody{
 		Id:           user.ID,
 		Email:        user.Email,
-		IsChirpyRed:  user.IsChirpyRed,
+		IsChirpyRed:  isChirpyRed,
+		Entitlements: security.EntitlementsFor(isChirpyRed),
 		Token:        accessToken,
 		RefreshToken: refreshToken,
 	}
@@ -452,46 +938,108 @@ func (cfg *apiConfig) handlerPostLogin(w http.ResponseWriter, r *http.Request) {
 	w.Write(file)
 }
 
+// handlerUpdateUsers only changes the supplied fields. Both changes require the current password, and a new
+// email only takes effect once it has been confirmed, see handlerConfirmEmailChange.
 func (cfg *apiConfig) handlerUpdateUsers(w http.ResponseWriter, r *http.Request) {
 	type requestBody struct {
-		Email    string `json:"email,omitempty"`
-		Password string `json:"password,omitempty"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	reqBody := requestBody{}
 	err := decoder.Decode(&reqBody)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "fail to update user")
+		respondWithProblem(w, http.StatusBadRequest, codeInvalidJSON, "fail to update user")
 		return
 	}
 
-	token := strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
-	claims, err := security.GetTokenClaims(token)
+	id, err := cfg.authenticateUser(r, security.ScopeUsersWrite)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "token is invalid")
+		respondWithAuthError(w, err)
 		return
 	}
 
-	issuer, err := claims.GetIssuer()
+	if reqBody.Email == nil && reqBody.Password == nil {
+		respondWithError(w, http.StatusBadRequest, "nothing to update")
+		return
+	}
+
+	user, err := cfg.db.GetUserByID(id)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "token is invalid")
+		respondWithError(w, http.StatusNotFound, "user does not exist")
 		return
 	}
 
-	if issuer == "chirpy-refresh" {
-		respondWithError(w, http.StatusUnauthorized, "cannot use refresh token to perform the action")
+	attempt := cfg.reserveLoginAttempt(w, r, user.Email)
+	if attempt == nil {
 		return
 	}
+	defer attempt.release()
 
-	userId, err := claims.GetSubject()
+	err = cfg.verifyPassword(user, reqBody.CurrentPassword)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "user id is invalid")
+		attempt.fail()
+		respondWithProblem(w, http.StatusUnauthorized, codeInvalidCredentials, "current password is incorrect")
 		return
 	}
 
-	id, err := strconv.Atoi(userId)
-	user, err := cfg.db.UpdateUser(id, reqBody.Email, reqBody.Password)
+	changeEmail := reqBody.Email != nil && database.NormalizeEmail(*reqBody.Email) != database.NormalizeEmail(user.Email)
+	errs := &utils.ValidationError{}
+
+	email := user.Email
+	if changeEmail {
+		email = *reqBody.Email
+		err = utils.ValidateEmail(email)
+		if err != nil {
+			errs.Add("email", "invalid", err.Error())
+		}
+	}
+
+	if reqBody.Password != nil {
+		errs.Merge("password", cfg.passwordPolicy.Validate(*reqBody.Password, email))
+	}
+
+	if err = errs.Err(); err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+
+	if changeEmail {
+		user, err = cfg.db.SetPendingEmail(id, email)
+		if errors.Is(err, database.ErrEmailTaken) {
+			respondWithErr(w, err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "fail to update user")
+			return
+		}
+
+		err = cfg.sendEmailChangeConfirmation(user)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "fail to send confirmation email")
+			return
+		}
+	}
+
+	if reqBody.Password != nil {
+		passwordHash, err := cfg.passwords.Hash(*reqBody.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "fail to update user")
+			return
+		}
+
+		// Refresh tokens and personal access tokens are revoked, the access token of this request expires on its own
+		err = cfg.db.ChangePassword(id, passwordHash)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "fail to update user")
+			return
+		}
+	}
+
+	user, err = cfg.db.GetUserByID(id)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "fail to update user")
 		return
@@ -539,12 +1087,34 @@ func (cfg *apiConfig) handlerRefreshToken(w http.ResponseWriter, r *http.Request
 	}
 
 	id, err := strconv.Atoi(userId)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "user id is invalid")
+		return
+	}
+
+	// The account may have been deleted since the refresh token was issued
+	user, err := cfg.db.GetUserByID(id)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "user id is invalid")
+		return
+	}
+
+	if user.SuspendedAt != nil {
+		respondWithErr(w, errSuspended)
+		return
+	}
+
+	if user.TokenRevoked(claims.IssuedAt.Time) {
+		respondWithError(w, http.StatusUnauthorized, "token is invalid")
+		return
+	}
 
 	type responseBody struct {
 		Token string `json:"token"`
 	}
 
-	accessToken, err := security.CreateJwtToken(id, cfg.accessTokenExpiresInSeconds, "chirpy-access")
+	// Refresh tokens issued to OAuth clients keep their scope, entitlements follow the current plan
+	accessToken, err := cfg.createAccessToken(user, claims.Scope, claims.ClientID)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "user id is invalid")
 		return
@@ -587,7 +1157,9 @@ func (cfg *apiConfig) handlerRevokeRefreshToken(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(token)
+	userID, _ := strconv.Atoi(claims.Subject)
+
+	err = cfg.db.RevokeRefreshToken(token, userID)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "fail to revoke refresh token")
 		return
@@ -595,38 +1167,3 @@ func (cfg *apiConfig) handlerRevokeRefreshToken(w http.ResponseWriter, r *http.R
 
 	w.WriteHeader(http.StatusOK)
 }
-
-func (cfg *apiConfig) handlerWebhook(w http.ResponseWriter, r *http.Request) {
-	requestApiKey := r.Header.Get("Authorization")
-	if requestApiKey != "ApiKey "+os.Getenv("POLKA_API_KEY") {
-		respondWithError(w, http.StatusUnauthorized, "invalid API key")
-		return
-	}
-
-	type requestBody struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID int `json:"user_id"`
-		} `json:"data"`
-	}
-
-	decoder := json.NewDecoder(r.Body)
-	reqBody := requestBody{}
-	err := decoder.Decode(&reqBody)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "fail to update user")
-		return
-	}
-
-	if reqBody.Event != "user.upgraded" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	status, err := cfg.db.UpdateChirpyRedStatus(reqBody.Data.UserID)
-	if err != nil {
-		log.Println(err)
-	}
-
-	w.WriteHeader(status)
-}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/mail"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestConfig returns a config backed by an empty database, with cheap password hashing
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Setenv("JWT_SECRET", "testsecret")
+
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attemptStore := security.NewMemoryAttemptStore()
+
+	return &apiConfig{
+		db:                           db,
+		accessTokenExpiresInSeconds:  60 * 60,
+		refreshTokenExpiresInSeconds: 60 * 60,
+		oauthIssuer:                  "http://localhost:8080",
+		accountLimiter:               security.NewLoginLimiter(attemptStore, "account:", accountLimiterConfig),
+		ipLimiter:                    security.NewLoginLimiter(attemptStore, "ip:", ipLimiterConfig),
+		mailer:                       mail.NewWriterMailer(io.Discard, "no-reply@chirpy.local"),
+		passwords:                    security.NewPasswords(security.NewBcryptHasher(bcrypt.MinCost)),
+		passwordPolicy:               security.NewPasswordPolicy(),
+		rateLimitSubjects:            newRateLimitSubjects(),
+		apiUsage:                     newAPIUsage(),
+	}
+}
+
+// createTestUser signs up a user with the given password
+func createTestUser(t *testing.T, cfg *apiConfig, email, password string) database.User {
+	passwordHash, err := cfg.passwords.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := cfg.db.CreateUser(email, passwordHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func TestLoginSuspended(t *testing.T) {
+	cfg := newTestConfig(t)
+	walt := createTestUser(t, cfg, "walt@breakingbad.com", "Str0ngPassw0rd!")
+
+	secret, _ := security.GenerateTOTPSecret()
+	cfg.db.SaveTwoFactorSecret(walt.ID, secret)
+	cfg.db.EnableTwoFactor(walt.ID, 0, nil)
+	cfg.db.ScheduleUserDeletion(walt.ID, time.Now().Add(time.Hour))
+	cfg.db.ModerateUser(walt.ID, 100, database.ModerationSuspendUser, "")
+
+	// Suspended users don't get a two-factor challenge, and logging in doesn't cancel their deletion
+	r := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email":"walt@breakingbad.com","password":"Str0ngPassw0rd!"}`))
+	w := httptest.NewRecorder()
+	cfg.handlerPostLogin(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Status %d not equal to expected %d: %s", w.Code, http.StatusForbidden, w.Body.String())
+	}
+
+	stored, _ := cfg.db.GetUserByID(walt.ID)
+	if stored.DeletionScheduledAt == nil {
+		t.Errorf("Deletion of user %d should still be scheduled", walt.ID)
+	}
+}
+
+func TestUpdateUsers(t *testing.T) {
+	cfg := newTestConfig(t)
+	walt := createTestUser(t, cfg, "walt@breakingbad.com", "Str0ngPassw0rd!")
+	createTestUser(t, cfg, "jesse@breakingbad.com", "Str0ngPassw0rd!")
+	token, _ := cfg.createAccessToken(walt, "", "")
+	refreshToken, _ := security.CreateJwtToken(walt.ID, cfg.refreshTokenExpiresInSeconds, "chirpy-refresh")
+
+	tests := []struct {
+		name          string
+		method        string
+		body          string
+		expected      int
+		expectedEmail string
+		pendingEmail  string
+	}{
+		{name: "no current password", method: "PATCH", body: `{"password":"N3wPassw0rd!!"}`, expected: http.StatusUnauthorized},
+		{name: "wrong current password", method: "PATCH", body: `{"password":"N3wPassw0rd!!","current_password":"wrong"}`, expected: http.StatusUnauthorized},
+		{name: "nothing to update", method: "PATCH", body: `{"current_password":"Str0ngPassw0rd!"}`, expected: http.StatusBadRequest},
+		{name: "weak password", method: "PATCH", body: `{"password":"short","current_password":"Str0ngPassw0rd!"}`, expected: http.StatusBadRequest},
+		// Only the password changes, the email stays as it is
+		{name: "password only", method: "PATCH", body: `{"password":"N3wPassw0rd!!","current_password":"Str0ngPassw0rd!"}`, expected: http.StatusOK, expectedEmail: walt.Email},
+		{name: "old password", method: "PUT", body: `{"email":"heisenberg@breakingbad.com","current_password":"Str0ngPassw0rd!"}`, expected: http.StatusUnauthorized},
+		{name: "email taken", method: "PUT", body: `{"email":"JESSE@breakingbad.com","current_password":"N3wPassw0rd!!"}`, expected: http.StatusConflict},
+		// A new email is pending until it is confirmed
+		{name: "email only", method: "PUT", body: `{"email":"heisenberg@breakingbad.com","current_password":"N3wPassw0rd!!"}`, expected: http.StatusOK, expectedEmail: walt.Email, pendingEmail: "heisenberg@breakingbad.com"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/api/users", strings.NewReader(test.body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		cfg.handlerUpdateUsers(w, r)
+
+		// Wrong passwords count against the login limiter, which isn't what is tested here
+		cfg.accountLimiter.Reset(walt.Email)
+
+		if w.Code != test.expected {
+			t.Errorf("%s: status %d not equal to expected %d: %s", test.name, w.Code, test.expected, w.Body.String())
+			continue
+		}
+		if test.expected != http.StatusOK {
+			continue
+		}
+
+		var user database.User
+		json.Unmarshal(w.Body.Bytes(), &user)
+		if user.Email != test.expectedEmail || user.PendingEmail != test.pendingEmail || user.Password != "" {
+			t.Errorf("%s: output %+v not equal to expected email %s pending %q", test.name, user, test.expectedEmail, test.pendingEmail)
+		}
+	}
+
+	stored, _ := cfg.db.GetUserByID(walt.ID)
+	if err := cfg.verifyPassword(stored, "N3wPassw0rd!!"); err != nil {
+		t.Errorf("Password should have been changed: %s", err)
+	}
+
+	// Sessions started with the old password end with it
+	r := httptest.NewRequest("POST", "/api/refresh", nil)
+	r.Header.Set("Authorization", "Bearer "+refreshToken)
+	w := httptest.NewRecorder()
+	cfg.handlerRefreshToken(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh: status %d not equal to expected %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// Following the emailed link switches to the new address
+	changeToken, _ := security.CreateActionToken(walt.ID, changeEmailTokenExpiresInSeconds, "chirpy-change-email", "heisenberg@breakingbad.com")
+	for _, expected := range []int{http.StatusOK, http.StatusBadRequest} {
+		r := httptest.NewRequest("POST", "/api/users/email/confirm", strings.NewReader(`{"token":"`+changeToken+`"}`))
+		w := httptest.NewRecorder()
+		cfg.handlerConfirmEmailChange(w, r)
+		if w.Code != expected {
+			t.Errorf("confirm: status %d not equal to expected %d", w.Code, expected)
+		}
+	}
+
+	stored, _ = cfg.db.GetUserByID(walt.ID)
+	if stored.Email != "heisenberg@breakingbad.com" || stored.PendingEmail != "" {
+		t.Errorf("Output %s (pending %q) not equal to expected %s", stored.Email, stored.PendingEmail, "heisenberg@breakingbad.com")
+	}
+}
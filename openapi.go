@@ -0,0 +1,819 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/openapi"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"github.com/go-chi/chi/v5"
+	swaggerFiles "github.com/swaggo/files/v2"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// operation builds the documentation of one endpoint
+type operation struct {
+	*openapi.Operation
+}
+
+func op(tag, id, summary string) operation {
+	return operation{&openapi.Operation{
+		OperationID: id,
+		Summary:     summary,
+		Tags:        []string{tag},
+		Responses:   map[string]openapi.Response{},
+	}}
+}
+
+// auth requires an access token, or an API token with one of the scopes
+func (o operation) auth(scopes ...string) operation {
+	o.Security = []openapi.SecurityRequirement{{"bearerAuth": scopes}}
+	return o.problems(http.StatusUnauthorized, http.StatusForbidden)
+}
+
+// optionalAuth is for endpoints that show more to authenticated users
+func (o operation) optionalAuth() operation {
+	o.Security = []openapi.SecurityRequirement{{}, {"bearerAuth": {}}}
+	return o
+}
+
+func (o operation) admin() operation {
+	o.Description = "Requires an access token of an admin."
+	return o.auth()
+}
+
+// path adds integer path parameters, e.g. chirpID
+func (o operation) path(names ...string) operation {
+	for _, name := range names {
+		o.Parameters = append(o.Parameters, openapi.Parameter{Name: name, In: "path", Required: true, Schema: openapi.Integer()})
+	}
+	return o.problems(http.StatusBadRequest)
+}
+
+func (o operation) query(name string, s *openapi.Schema, description string) operation {
+	o.Parameters = append(o.Parameters, openapi.Parameter{Name: name, In: "query", Description: description, Schema: s})
+	return o
+}
+
+// limit adds the ?limit= of list endpoints
+func (o operation) limit() operation {
+	return o.query("limit", openapi.Integer(), "Maximum number of results, "+strconv.Itoa(defaultDeliveriesLimit)+" by default")
+}
+
+func (o operation) body(s *openapi.Schema) operation {
+	o.RequestBody = &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{"application/json": {Schema: s}}}
+	return o.problems(http.StatusBadRequest)
+}
+
+func (o operation) optionalBody(s *openapi.Schema) operation {
+	o = o.body(s)
+	o.RequestBody.Required = false
+	return o
+}
+
+func (o operation) form(s *openapi.Schema) operation {
+	o.RequestBody = &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{"application/x-www-form-urlencoded": {Schema: s}}}
+	return o.problems(http.StatusBadRequest)
+}
+
+func (o operation) returns(status int, description string, s *openapi.Schema) operation {
+	return o.returnsType(status, description, "application/json", s)
+}
+
+func (o operation) returnsType(status int, description, contentType string, s *openapi.Schema) operation {
+	o.Responses[strconv.Itoa(status)] = openapi.Response{
+		Description: description,
+		Content:     map[string]openapi.MediaType{contentType: {Schema: s}},
+	}
+	return o
+}
+
+// empty is a response without a body
+func (o operation) empty(status int, description string) operation {
+	o.Responses[strconv.Itoa(status)] = openapi.Response{Description: description}
+	return o
+}
+
+// problems adds error responses, which are all RFC 7807 problem details
+func (o operation) problems(statuses ...int) operation {
+	for _, status := range statuses {
+		o.Responses[strconv.Itoa(status)] = openapi.Response{
+			Description: http.StatusText(status),
+			Content:     map[string]openapi.MediaType{"application/problem+json": {Schema: openapi.Ref("Problem")}},
+		}
+	}
+	return o
+}
+
+// rateLimited documents the 429 and headers of a route group with a rate limit
+func (o operation) rateLimited() operation {
+	o = o.problems(http.StatusTooManyRequests)
+	r := o.Responses["429"]
+	r.Headers = map[string]openapi.Header{
+		"Retry-After": {Description: "Seconds until the request can be retried", Schema: openapi.Integer()},
+	}
+	o.Responses["429"] = r
+	return o
+}
+
+// oauthErrors are the RFC 6749 errors of the OAuth endpoints
+func (o operation) oauthErrors(statuses ...int) operation {
+	for _, status := range statuses {
+		o.Responses[strconv.Itoa(status)] = openapi.Response{
+			Description: http.StatusText(status),
+			Content:     map[string]openapi.MediaType{"application/json": {Schema: openapi.Ref("OAuthError")}},
+		}
+	}
+	return o
+}
+
+// apiSpec describes every route of the router. openapi_test.go checks that the two stay in sync.
+func apiSpec(issuer string) *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:   "Chirpy",
+		Version: "2.0.0",
+		Description: "Errors are RFC 7807 problem details with a stable code, except for the OAuth endpoints which follow RFC 6749. " +
+			"Endpoints are served under /api/v2, which wraps successful JSON responses in a data envelope, and under " +
+			"the deprecated /api/v1 and /api. Operations, OAuth and Polka endpoints are not versioned.",
+	})
+	doc.Servers = []openapi.Server{{URL: issuer}}
+	doc.Components.SecuritySchemes = map[string]openapi.SecurityScheme{
+		"bearerAuth":   {Type: "http", Scheme: "bearer", Description: "An access token from /api/login or /api/oauth/token, or an API token"},
+		"refreshToken": {Type: "http", Scheme: "bearer", Description: "A refresh token"},
+		"clientAuth":   {Type: "http", Scheme: "basic", Description: "The id and secret of an OAuth client"},
+		"polkaApiKey":  {Type: "apiKey", In: "header", Name: "Authorization", Description: "ApiKey followed by the Polka API key, or a Polka-Signature header"},
+	}
+
+	doc.SchemaOf(Problem{})
+	doc.Components.Schemas["OAuthError"] = openapi.Object(map[string]*openapi.Schema{
+		"error":             openapi.String(),
+		"error_description": openapi.String(),
+	}, "error")
+
+	// The password hash is stored on the user but never returned
+	user := doc.SchemaOf(database.User{})
+	delete(doc.Components.Schemas["User"].Properties, "password")
+
+	chirp := doc.SchemaOf(database.Chirp{})
+	report := doc.SchemaOf(database.Report{})
+	relationship := doc.SchemaOf(database.Relationship{})
+	endpoint := doc.SchemaOf(database.WebhookEndpoint{})
+	outboundWebhook := doc.SchemaOf(database.OutboundWebhook{})
+	entitlements := doc.SchemaOf(security.Entitlements{})
+
+	chirpBody := openapi.Object(map[string]*openapi.Schema{
+		"body":  openapi.String(),
+		"media": openapi.ArrayOf(openapi.String()),
+	}, "body")
+	credentials := openapi.Object(map[string]*openapi.Schema{
+		"email":    openapi.String(),
+		"password": openapi.String(),
+	}, "email", "password")
+	token := openapi.Object(map[string]*openapi.Schema{"token": openapi.String()}, "token")
+	note := openapi.Object(map[string]*openapi.Schema{"note": openapi.String()})
+	login := openapi.Object(map[string]*openapi.Schema{
+		"id":            openapi.Integer(),
+		"email":         openapi.String(),
+		"is_chirpy_red": openapi.Boolean(),
+		"entitlements":  entitlements,
+		"token":         openapi.String(),
+		"refresh_token": openapi.String(),
+	}, "id", "email", "is_chirpy_red", "entitlements", "token", "refresh_token")
+	mfaChallenge := openapi.Object(map[string]*openapi.Schema{
+		"mfa_required": openapi.Boolean(),
+		"mfa_token":    openapi.String(),
+	}, "mfa_required", "mfa_token")
+	reportBody := openapi.Object(map[string]*openapi.Schema{
+		"reason":  openapi.Enum(database.ReportReasons...),
+		"details": openapi.String(),
+	}, "reason")
+	webhookBody := openapi.Object(map[string]*openapi.Schema{
+		"url":    openapi.String(),
+		"events": openapi.ArrayOf(openapi.String()),
+	}, "url", "events")
+
+	add := func(method, path string, o operation) {
+		doc.Add(method, path, o.Operation)
+	}
+
+	// Operations
+	add("GET", "/api/healthz", op("operations", "getHealthz", "Readiness check").
+		returnsType(http.StatusOK, "The storage is working", "text/plain", openapi.String()).
+		returnsType(http.StatusServiceUnavailable, "The storage is degraded", "text/plain", openapi.String()))
+	for _, method := range []string{"GET", "POST"} {
+		add(method, "/api/reset", op("operations", "reset"+method, "Reset the file server hit counter").
+			returnsType(http.StatusOK, "The counter was reset", "text/plain", openapi.String()))
+	}
+	add("GET", "/api/openapi.json", op("operations", "getOpenAPI", "This document").
+		returns(http.StatusOK, "The OpenAPI document", &openapi.Schema{Type: "object"}))
+	add("GET", "/api/docs", op("operations", "getDocs", "Swagger UI for this document").
+		returnsType(http.StatusOK, "An HTML page", "text/html", openapi.String()))
+	add("GET", "/api/docs/{file}", op("operations", "getDocsAsset", "Assets of the Swagger UI page").
+		returnsType(http.StatusOK, "A file of Swagger UI", "application/octet-stream", openapi.String()).
+		problems(http.StatusNotFound))
+	doc.Paths["/api/docs/{file}"]["get"].Parameters = []openapi.Parameter{{Name: "file", In: "path", Required: true, Schema: openapi.String()}}
+	add("GET", "/.well-known/openid-configuration", op("oauth", "getOpenIDConfiguration", "OpenID Connect discovery document").
+		returns(http.StatusOK, "The discovery document", &openapi.Schema{Type: "object"}))
+
+	// Authentication
+	add("POST", "/api/users", op("users", "createUser", "Sign up").
+		body(credentials).
+		returns(http.StatusCreated, "The new user", user).
+		problems(http.StatusConflict).rateLimited())
+	add("POST", "/api/login", op("auth", "login", "Log in with email and password").
+		body(credentials).
+		returns(http.StatusOK, "Tokens of the user, or a challenge when two-factor authentication is enabled", openapi.OneOf(login, mfaChallenge)).
+		problems(http.StatusUnauthorized, http.StatusForbidden).rateLimited())
+	add("POST", "/api/login/mfa", op("auth", "loginMFA", "Complete a login with a second factor").
+		body(openapi.Object(map[string]*openapi.Schema{
+			"mfa_token":     openapi.String(),
+			"code":          openapi.String(),
+			"recovery_code": openapi.String(),
+		}, "mfa_token")).
+		returns(http.StatusOK, "Tokens of the user", login).
+		problems(http.StatusUnauthorized).rateLimited())
+	add("POST", "/api/refresh", op("auth", "refreshToken", "Get a new access token").
+		returns(http.StatusOK, "A new access token", token).
+		problems(http.StatusUnauthorized, http.StatusForbidden).rateLimited())
+	doc.Paths["/api/refresh"]["post"].Security = []openapi.SecurityRequirement{{"refreshToken": {}}}
+	add("POST", "/api/revoke", op("auth", "revokeToken", "Revoke a refresh token").
+		empty(http.StatusOK, "The token was revoked").
+		problems(http.StatusUnauthorized).rateLimited())
+	doc.Paths["/api/revoke"]["post"].Security = []openapi.SecurityRequirement{{"refreshToken": {}}}
+
+	// Email
+	add("POST", "/api/users/verify-email/request", op("users", "requestEmailVerification", "Send a verification email").
+		auth().empty(http.StatusAccepted, "The email was sent").
+		problems(http.StatusNotFound, http.StatusConflict).rateLimited())
+	add("POST", "/api/users/verify-email", op("users", "verifyEmail", "Verify an email address").
+		body(token).
+		empty(http.StatusOK, "The email is verified").rateLimited())
+	add("POST", "/api/users/password-reset/request", op("users", "requestPasswordReset", "Send a password reset email").
+		body(openapi.Object(map[string]*openapi.Schema{"email": openapi.String()}, "email")).
+		empty(http.StatusAccepted, "An email was sent if the account exists").rateLimited())
+	add("POST", "/api/users/password-reset", op("users", "resetPassword", "Set a new password").
+		body(openapi.Object(map[string]*openapi.Schema{"token": openapi.String(), "password": openapi.String()}, "token", "password")).
+		empty(http.StatusOK, "The password was changed").rateLimited())
+	add("POST", "/api/users/email/confirm", op("users", "confirmEmailChange", "Confirm a new email address").
+		body(token).
+		returns(http.StatusOK, "The updated user", user).
+		problems(http.StatusConflict).rateLimited())
+
+	// Two-factor authentication
+	add("POST", "/api/users/2fa", op("two-factor", "setupTwoFactor", "Start enrolling in two-factor authentication").
+		auth().
+		returns(http.StatusCreated, "The secret to add to an authenticator app", openapi.Object(map[string]*openapi.Schema{
+			"secret":      openapi.String(),
+			"otpauth_uri": openapi.String(),
+			"qr_code_url": openapi.String(),
+		}, "secret", "otpauth_uri", "qr_code_url")).
+		problems(http.StatusNotFound, http.StatusConflict).rateLimited())
+	add("GET", "/api/users/2fa/qr", op("two-factor", "getTwoFactorQRCode", "QR code of the pending secret").
+		auth().
+		returnsType(http.StatusOK, "A PNG image", "image/png", &openapi.Schema{Type: "string", Format: "binary"}).
+		problems(http.StatusNotFound).rateLimited())
+	add("POST", "/api/users/2fa/verify", op("two-factor", "verifyTwoFactor", "Finish enrolling with a code from the app").
+		auth().
+		body(openapi.Object(map[string]*openapi.Schema{"code": openapi.String()}, "code")).
+		returns(http.StatusOK, "Recovery codes, only shown once", openapi.Object(map[string]*openapi.Schema{
+			"recovery_codes": openapi.ArrayOf(openapi.String()),
+		}, "recovery_codes")).
+		problems(http.StatusNotFound).rateLimited())
+	add("POST", "/api/users/2fa/disable", op("two-factor", "disableTwoFactor", "Turn off two-factor authentication").
+		auth().
+		body(openapi.Object(map[string]*openapi.Schema{
+			"password":      openapi.String(),
+			"code":          openapi.String(),
+			"recovery_code": openapi.String(),
+		}, "password")).
+		empty(http.StatusOK, "Two-factor authentication is off").
+		problems(http.StatusNotFound).rateLimited())
+
+	// Chirps
+	add("POST", "/api/validate_chirp", op("chirps", "validateChirp", "Check a chirp without posting it").
+		optionalAuth().
+		body(chirpBody).
+		returns(http.StatusOK, "The cleaned chirp", openapi.Object(map[string]*openapi.Schema{"cleaned_body": openapi.String()}, "cleaned_body")).
+		rateLimited())
+	add("POST", "/api/chirps", op("chirps", "createChirp", "Post a chirp").
+		auth(security.ScopeChirpsWrite).
+		body(chirpBody).
+		returns(http.StatusCreated, "The new chirp", chirp).rateLimited())
+	add("GET", "/api/chirps", op("chirps", "getChirps", "List chirps").
+		optionalAuth().
+		query("author_id", openapi.Integer(), "Only chirps of this user").
+		query("sort", openapi.Enum("asc", "desc"), "Order by id, asc by default").
+		returns(http.StatusOK, "The chirps the caller may see", openapi.ArrayOf(chirp)).
+		problems(http.StatusBadRequest).rateLimited())
+	add("GET", "/api/chirps/{chirpID}", op("chirps", "getChirp", "Get a chirp").
+		optionalAuth().path("chirpID").
+		returns(http.StatusOK, "The chirp", chirp).
+		problems(http.StatusNotFound).rateLimited())
+	add("PUT", "/api/chirps/{chirpID}", op("chirps", "updateChirp", "Edit a chirp, a Chirpy Red feature").
+		auth(security.ScopeChirpsWrite).path("chirpID").
+		body(chirpBody).
+		returns(http.StatusOK, "The edited chirp", chirp).
+		problems(http.StatusNotFound).rateLimited())
+	add("DELETE", "/api/chirps/{chirpID}", op("chirps", "deleteChirp", "Delete a chirp").
+		auth(security.ScopeChirpsWrite).path("chirpID").
+		empty(http.StatusOK, "The chirp was deleted").
+		problems(http.StatusNotFound).rateLimited())
+
+	// Users
+	add("PUT", "/api/users", op("users", "updateUser", "Change email or password").
+		auth(security.ScopeUsersWrite).
+		body(openapi.Object(map[string]*openapi.Schema{
+			"email":            openapi.String(),
+			"password":         openapi.String(),
+			"current_password": openapi.String(),
+		})).
+		returns(http.StatusOK, "The updated user", user).
+		problems(http.StatusConflict).rateLimited())
+	doc.Paths["/api/users"]["patch"] = withID(doc.Paths["/api/users"]["put"], "patchUser")
+	add("DELETE", "/api/users", op("users", "deleteUser", "Schedule the deletion of the account").
+		auth().
+		body(openapi.Object(map[string]*openapi.Schema{"password": openapi.String()}, "password")).
+		returns(http.StatusAccepted, "The account will be deleted unless the user logs in before", openapi.Object(map[string]*openapi.Schema{
+			"deletion_scheduled_at": openapi.DateTime(),
+		}, "deletion_scheduled_at")).
+		problems(http.StatusNotFound).rateLimited())
+	add("POST", "/api/users/export", op("users", "createDataExport", "Start an export of the user's data").
+		auth().
+		returns(http.StatusAccepted, "The export, poll it until it is ready", doc.SchemaOf(database.DataExport{})).
+		problems(http.StatusConflict).rateLimited())
+	add("GET", "/api/users/export/{exportID}", op("users", "getDataExport", "Download an export").
+		auth().path("exportID").
+		returnsType(http.StatusOK, "The export", "application/zip", &openapi.Schema{Type: "string", Format: "binary"}).
+		returns(http.StatusAccepted, "The export is not ready yet", doc.SchemaOf(database.DataExport{})).
+		problems(http.StatusNotFound, http.StatusGone).rateLimited())
+	add("GET", "/api/users/me/subscription", op("users", "getSubscription", "Chirpy Red status and entitlements").
+		auth(security.ScopeUsersRead).
+		returns(http.StatusOK, "The subscription", openapi.Object(map[string]*openapi.Schema{
+			"is_chirpy_red": openapi.Boolean(),
+			"status":        openapi.String(),
+			"started_at":    &openapi.Schema{Type: "string", Format: "date-time", Nullable: true},
+			"expires_at":    &openapi.Schema{Type: "string", Format: "date-time", Nullable: true},
+			"entitlements":  entitlements,
+		}, "is_chirpy_red", "status", "started_at", "expires_at", "entitlements")).
+		problems(http.StatusNotFound).rateLimited())
+
+	// Reports, blocks and mutes
+	add("POST", "/api/chirps/{chirpID}/report", op("moderation", "reportChirp", "Report a chirp to the moderators").
+		auth(security.ScopeChirpsWrite).path("chirpID").
+		body(reportBody).
+		returns(http.StatusCreated, "The report", report).
+		problems(http.StatusNotFound, http.StatusConflict).rateLimited())
+	add("POST", "/api/users/{userID}/report", op("moderation", "reportUser", "Report a user to the moderators").
+		auth(security.ScopeChirpsWrite).path("userID").
+		body(reportBody).
+		returns(http.StatusCreated, "The report", report).
+		problems(http.StatusNotFound, http.StatusConflict).rateLimited())
+	for _, kind := range []string{database.RelationshipBlock, database.RelationshipMute} {
+		add("POST", "/api/users/{userID}/"+kind, op("relationships", kind+"User", kind+" a user").
+			auth(security.ScopeUsersWrite).path("userID").
+			returns(http.StatusCreated, "The "+kind, relationship).
+			problems(http.StatusNotFound).rateLimited())
+		add("DELETE", "/api/users/{userID}/"+kind, op("relationships", "un"+kind+"User", "Undo a "+kind).
+			auth(security.ScopeUsersWrite).path("userID").
+			empty(http.StatusOK, "The "+kind+" was removed").
+			problems(http.StatusNotFound).rateLimited())
+		add("GET", "/api/users/me/"+kind+"s", op("relationships", "get"+kind+"s", "List the users the caller did "+kind).
+			auth(security.ScopeUsersRead).
+			returns(http.StatusOK, "The "+kind+"s", openapi.ArrayOf(relationship)).rateLimited())
+	}
+
+	// API tokens
+	add("POST", "/api/tokens", op("tokens", "createToken", "Create an API token").
+		auth().
+		body(openapi.Object(map[string]*openapi.Schema{
+			"name":   openapi.String(),
+			"scopes": openapi.ArrayOf(openapi.String()),
+		}, "name", "scopes")).
+		returns(http.StatusCreated, "The token, only shown once", openapi.Object(map[string]*openapi.Schema{
+			"id":     openapi.Integer(),
+			"name":   openapi.String(),
+			"scopes": openapi.ArrayOf(openapi.String()),
+			"token":  openapi.String(),
+		}, "id", "name", "scopes", "token")).rateLimited())
+	add("GET", "/api/tokens", op("tokens", "getTokens", "List API tokens").
+		auth().
+		returns(http.StatusOK, "The tokens", openapi.ArrayOf(doc.SchemaOf(database.APIToken{}))).rateLimited())
+	add("DELETE", "/api/tokens/{tokenID}", op("tokens", "revokeAPIToken", "Revoke an API token").
+		auth().path("tokenID").
+		empty(http.StatusOK, "The token was revoked").
+		problems(http.StatusNotFound, http.StatusConflict).rateLimited())
+
+	// Outbound webhooks
+	add("POST", "/api/webhooks", op("webhooks", "createWebhook", "Subscribe to events").
+		auth().
+		body(webhookBody).
+		returns(http.StatusCreated, "The endpoint, with its signing secret", endpoint).rateLimited())
+	add("GET", "/api/webhooks", op("webhooks", "getWebhooks", "List webhook endpoints").
+		auth().
+		returns(http.StatusOK, "The endpoints", openapi.ArrayOf(endpoint)).rateLimited())
+	add("DELETE", "/api/webhooks/{webhookID}", op("webhooks", "deleteWebhook", "Delete a webhook endpoint").
+		auth().path("webhookID").
+		empty(http.StatusOK, "The endpoint was deleted").
+		problems(http.StatusNotFound).rateLimited())
+	add("GET", "/api/webhooks/{webhookID}/deliveries", op("webhooks", "getWebhookDeliveries", "List deliveries to an endpoint, newest first").
+		auth().path("webhookID").
+		query("status", openapi.String(), "Only deliveries with this status").limit().
+		returns(http.StatusOK, "The deliveries", openapi.ArrayOf(outboundWebhook)).
+		problems(http.StatusNotFound).rateLimited())
+
+	// OAuth
+	add("POST", "/api/oauth/clients", op("oauth", "createOAuthClient", "Register an OAuth client").
+		auth().
+		body(openapi.Object(map[string]*openapi.Schema{
+			"name":          openapi.String(),
+			"redirect_uris": openapi.ArrayOf(openapi.String()),
+			"public":        openapi.Boolean(),
+		}, "name", "redirect_uris")).
+		returns(http.StatusCreated, "The client, the secret is only shown once", openapi.Object(map[string]*openapi.Schema{
+			"client_id":     openapi.String(),
+			"client_secret": openapi.String(),
+			"name":          openapi.String(),
+			"redirect_uris": openapi.ArrayOf(openapi.String()),
+		}, "client_id", "name", "redirect_uris")).rateLimited())
+	authorize := op("oauth", "getAuthorize", "Consent page of the authorization code flow")
+	for _, name := range []string{"response_type", "client_id", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		authorize = authorize.query(name, openapi.String(), "")
+	}
+	add("GET", "/api/oauth/authorize", authorize.
+		returnsType(http.StatusOK, "The consent page", "text/html", openapi.String()).
+		empty(http.StatusFound, "Redirect to the client with an error").
+		problems(http.StatusBadRequest).rateLimited())
+	add("POST", "/api/oauth/authorize", op("oauth", "postAuthorize", "Submit the consent page").
+		form(openapi.Object(map[string]*openapi.Schema{
+			"decision":      openapi.String(),
+			"email":         openapi.String(),
+			"password":      openapi.String(),
+			"code":          openapi.String(),
+			"recovery_code": openapi.String(),
+		}, "decision")).
+		returnsType(http.StatusUnauthorized, "The consent page with an error", "text/html", openapi.String()).
+		empty(http.StatusFound, "Redirect to the client with a code or an error").rateLimited())
+	add("POST", "/api/oauth/token", op("oauth", "oauthToken", "Exchange a code or refresh token for tokens").
+		form(openapi.Object(map[string]*openapi.Schema{
+			"grant_type":    openapi.Enum("authorization_code", "refresh_token"),
+			"code":          openapi.String(),
+			"redirect_uri":  openapi.String(),
+			"code_verifier": openapi.String(),
+			"refresh_token": openapi.String(),
+			"client_id":     openapi.String(),
+			"client_secret": openapi.String(),
+		}, "grant_type")).
+		returns(http.StatusOK, "The tokens", openapi.Object(map[string]*openapi.Schema{
+			"access_token":  openapi.String(),
+			"token_type":    openapi.String(),
+			"expires_in":    openapi.Integer(),
+			"refresh_token": openapi.String(),
+			"id_token":      openapi.String(),
+			"scope":         openapi.String(),
+		}, "access_token", "token_type")).
+		oauthErrors(http.StatusBadRequest, http.StatusUnauthorized, http.StatusInternalServerError).rateLimited())
+	doc.Paths["/api/oauth/token"]["post"].Security = []openapi.SecurityRequirement{{}, {"clientAuth": {}}}
+	add("POST", "/api/oauth/introspect", op("oauth", "introspectToken", "RFC 7662 token introspection").
+		form(openapi.Object(map[string]*openapi.Schema{"token": openapi.String()}, "token")).
+		returns(http.StatusOK, "Whether the token is active and its claims", openapi.Object(map[string]*openapi.Schema{
+			"active":     openapi.Boolean(),
+			"scope":      openapi.String(),
+			"client_id":  openapi.String(),
+			"sub":        openapi.String(),
+			"token_type": openapi.String(),
+			"exp":        openapi.Integer(),
+			"iat":        openapi.Integer(),
+		}, "active")).
+		oauthErrors(http.StatusUnauthorized).rateLimited())
+	doc.Paths["/api/oauth/introspect"]["post"].Security = []openapi.SecurityRequirement{{"clientAuth": {}}}
+	add("GET", "/api/oauth/userinfo", op("oauth", "getUserInfo", "OpenID Connect user info").
+		auth().
+		returns(http.StatusOK, "Claims of the user", openapi.Object(map[string]*openapi.Schema{
+			"sub":   openapi.String(),
+			"email": openapi.String(),
+		}, "sub")).
+		problems(http.StatusNotFound).rateLimited())
+	add("GET", "/api/oauth/jwks", op("oauth", "getJWKS", "Keys that sign ID tokens").
+		returns(http.StatusOK, "A JSON Web Key Set", &openapi.Schema{Type: "object"}).rateLimited())
+
+	// Polka
+	add("POST", "/api/polka/webhooks", op("subscriptions", "polkaWebhook", "Subscription events from Polka").
+		body(openapi.Object(map[string]*openapi.Schema{
+			"id":    openapi.String(),
+			"event": openapi.String(),
+			"data": openapi.Object(map[string]*openapi.Schema{
+				"user_id":    openapi.Integer(),
+				"expires_at": &openapi.Schema{Type: "string", Format: "date-time", Nullable: true},
+			}, "user_id"),
+		}, "event", "data")).
+		empty(http.StatusOK, "The event was handled or ignored").
+		problems(http.StatusUnauthorized, http.StatusNotFound, http.StatusInternalServerError))
+	doc.Paths["/api/polka/webhooks"]["post"].Security = []openapi.SecurityRequirement{{"polkaApiKey": {}}}
+
+	// Admin
+	add("GET", "/admin/metrics", op("admin", "getMetrics", "File server hit counter").
+		returnsType(http.StatusOK, "An HTML page", "text/html", openapi.String()).rateLimited())
+	add("GET", "/admin/api-usage", op("admin", "getAPIUsage", "Requests per API version since the server started").
+		admin().
+		returns(http.StatusOK, "Requests per version, unversioned counts v1 requests without a version in the path",
+			&openapi.Schema{Type: "object", AdditionalProperties: openapi.Integer()}))
+	add("GET", "/admin/health", op("admin", "getHealth", "Why the storage is degraded").
+		admin().
+		returns(http.StatusOK, "The storage is working", doc.SchemaOf(database.Health{})).
+		returns(http.StatusServiceUnavailable, "The storage is degraded", doc.SchemaOf(database.Health{})).rateLimited())
+	add("POST", "/admin/users/{userID}/unlock", op("admin", "unlockUser", "Clear failed logins of a user").
+		admin().path("userID").
+		empty(http.StatusOK, "The user can log in again").
+		problems(http.StatusNotFound).rateLimited())
+	for _, action := range []string{"suspend", "shadow-ban"} {
+		add("POST", "/admin/users/{userID}/"+action, op("admin", action+"User", action+" a user").
+			admin().path("userID").
+			optionalBody(note).
+			returns(http.StatusOK, "The user", user).
+			problems(http.StatusNotFound).rateLimited())
+		add("DELETE", "/admin/users/{userID}/"+action, op("admin", "un"+action+"User", "Lift a "+action).
+			admin().path("userID").
+			optionalBody(note).
+			returns(http.StatusOK, "The user", user).
+			problems(http.StatusNotFound).rateLimited())
+	}
+	add("GET", "/admin/webhooks/polka/deliveries", op("admin", "getPolkaDeliveries", "Log of Polka webhook requests, newest first").
+		admin().
+		query("outcome", openapi.Enum(database.WebhookProcessed, database.WebhookDuplicate, database.WebhookIgnored, database.WebhookRejected, database.WebhookFailed), "Only deliveries with this outcome").
+		limit().
+		returns(http.StatusOK, "The deliveries", openapi.ArrayOf(doc.SchemaOf(database.WebhookDelivery{}))).
+		problems(http.StatusBadRequest).rateLimited())
+	add("GET", "/admin/reports", op("admin", "getReports", "The moderation queue, oldest first").
+		admin().
+		query("status", openapi.Enum(database.ReportOpen, database.ReportClaimed, database.ReportResolved), "").
+		query("target_type", openapi.Enum(database.ReportTargetChirp, database.ReportTargetUser), "").
+		limit().
+		returns(http.StatusOK, "The reports", openapi.ArrayOf(report)).
+		problems(http.StatusBadRequest).rateLimited())
+	add("GET", "/admin/reports/{reportID}", op("admin", "getReport", "Get a report").
+		admin().path("reportID").
+		returns(http.StatusOK, "The report", report).
+		problems(http.StatusNotFound).rateLimited())
+	add("POST", "/admin/reports/{reportID}/claim", op("admin", "claimReport", "Claim a report").
+		admin().path("reportID").
+		returns(http.StatusOK, "The report", report).
+		problems(http.StatusNotFound, http.StatusConflict).rateLimited())
+	add("POST", "/admin/reports/{reportID}/resolve", op("admin", "resolveReport", "Act on a report").
+		admin().path("reportID").
+		body(openapi.Object(map[string]*openapi.Schema{
+			"action": openapi.String(),
+			"note":   openapi.String(),
+		}, "action")).
+		returns(http.StatusOK, "The report", report).
+		problems(http.StatusNotFound, http.StatusConflict).rateLimited())
+	add("GET", "/admin/moderation/audit", op("admin", "getModerationAudit", "What moderators did, newest first").
+		admin().
+		query("report_id", openapi.Integer(), "Only entries of this report").
+		limit().
+		returns(http.StatusOK, "The entries", openapi.ArrayOf(doc.SchemaOf(database.ModerationAuditEntry{}))).
+		problems(http.StatusBadRequest).rateLimited())
+	add("POST", "/admin/webhooks/endpoints", op("admin", "adminCreateWebhook", "Subscribe to events of all users").
+		admin().
+		body(webhookBody).
+		returns(http.StatusCreated, "The endpoint, with its signing secret", endpoint).rateLimited())
+	add("GET", "/admin/webhooks/endpoints", op("admin", "adminGetWebhooks", "List endpoints subscribed to all users").
+		admin().
+		returns(http.StatusOK, "The endpoints", openapi.ArrayOf(endpoint)).rateLimited())
+	add("DELETE", "/admin/webhooks/endpoints/{webhookID}", op("admin", "adminDeleteWebhook", "Delete an endpoint").
+		admin().path("webhookID").
+		empty(http.StatusOK, "The endpoint was deleted").
+		problems(http.StatusNotFound).rateLimited())
+	add("GET", "/admin/webhooks/dead-letters", op("admin", "getWebhookDeadLetters", "Deliveries that failed every attempt").
+		admin().limit().
+		returns(http.StatusOK, "The deliveries", openapi.ArrayOf(outboundWebhook)).
+		problems(http.StatusBadRequest).rateLimited())
+	add("POST", "/admin/webhooks/dead-letters/{deliveryID}/retry", op("admin", "retryWebhookDeadLetter", "Deliver a dead letter again").
+		admin().path("deliveryID").
+		returns(http.StatusOK, "The delivery, queued again", outboundWebhook).
+		problems(http.StatusNotFound).rateLimited())
+
+	// Every endpoint can fail
+	for _, item := range doc.Paths {
+		for _, o := range item {
+			if _, ok := o.Responses["500"]; !ok {
+				operation{o}.problems(http.StatusInternalServerError)
+			}
+		}
+	}
+
+	versionPaths(doc, map[string]bool{
+		"/api/healthz":          true,
+		"/api/reset":            true,
+		"/api/openapi.json":     true,
+		"/api/docs":             true,
+		"/api/docs/{file}":      true,
+		"/api/oauth/token":      true,
+		"/api/oauth/introspect": true,
+		"/api/oauth/authorize":  true,
+		"/api/oauth/userinfo":   true,
+		"/api/oauth/jwks":       true,
+		"/api/polka/webhooks":   true,
+	})
+
+	loginV2 := openapi.Object(map[string]*openapi.Schema{
+		"user":          user,
+		"entitlements":  entitlements,
+		"token":         openapi.String(),
+		"refresh_token": openapi.String(),
+	}, "user", "entitlements", "token", "refresh_token")
+	operation{doc.Paths["/api/v2/login"]["post"]}.
+		returns(http.StatusOK, "Tokens of the user, or a challenge when two-factor authentication is enabled", envelope(openapi.OneOf(loginV2, mfaChallenge)))
+	operation{doc.Paths["/api/v2/login/mfa"]["post"]}.
+		returns(http.StatusOK, "Tokens of the user", envelope(loginV2))
+	operation{doc.Paths["/api/v2/validate_chirp"]["post"]}.
+		returns(http.StatusOK, "The chirp as it would be posted", envelope(chirpBody))
+
+	return doc
+}
+
+// versionPaths serves every /api path, except the unversioned ones, under /api/v1 and /api/v2 too. The paths
+// without a version are the deprecated v1 that existed before versioning.
+func versionPaths(doc *openapi.Document, unversioned map[string]bool) {
+	deprecation := map[string]openapi.Header{
+		"Deprecation": {Description: "When v1 was deprecated, as @ followed by a Unix timestamp. Only sent once a date is configured", Schema: openapi.String()},
+		"Sunset":      {Description: "When v1 will stop being served. Only sent once a date is configured", Schema: openapi.String()},
+		"Link":        {Description: "The same endpoint in v2, as the successor-version", Schema: openapi.String()},
+	}
+
+	paths := make([]string, 0)
+	for path := range doc.Paths {
+		if strings.HasPrefix(path, "/api/") && !unversioned[path] {
+			paths = append(paths, path)
+		}
+	}
+
+	for _, path := range paths {
+		item := doc.Paths[path]
+		rest := strings.TrimPrefix(path, "/api")
+		for method, o := range item {
+			v1 := versioned(o, "V1", func(resp openapi.Response) openapi.Response {
+				resp.Headers = mergeHeaders(resp.Headers, deprecation)
+				return resp
+			})
+			v2 := versioned(o, "V2", func(resp openapi.Response) openapi.Response {
+				if media, ok := resp.Content["application/json"]; ok {
+					resp.Content = map[string]openapi.MediaType{"application/json": {Schema: envelope(media.Schema)}}
+				}
+				return resp
+			})
+			legacy := versioned(o, "", func(resp openapi.Response) openapi.Response {
+				resp.Headers = mergeHeaders(resp.Headers, deprecation)
+				return resp
+			})
+			v1.Deprecated = true
+			legacy.Deprecated = true
+
+			doc.Add(method, "/api/"+apiV1+rest, v1)
+			doc.Add(method, "/api/"+apiV2+rest, v2)
+			item[method] = legacy
+		}
+	}
+}
+
+// versioned copies an operation, suffixing its id and changing its successful responses with update
+func versioned(o *openapi.Operation, suffix string, update func(openapi.Response) openapi.Response) *openapi.Operation {
+	c := *o
+	c.OperationID += suffix
+	c.Responses = map[string]openapi.Response{}
+	for status, resp := range o.Responses {
+		if strings.HasPrefix(status, "2") {
+			resp = update(resp)
+		}
+		c.Responses[status] = resp
+	}
+	return &c
+}
+
+func mergeHeaders(headers, extra map[string]openapi.Header) map[string]openapi.Header {
+	result := map[string]openapi.Header{}
+	for name, h := range headers {
+		result[name] = h
+	}
+	for name, h := range extra {
+		result[name] = h
+	}
+	return result
+}
+
+// envelope is how v2 returns a resource
+func envelope(s *openapi.Schema) *openapi.Schema {
+	return openapi.Object(map[string]*openapi.Schema{"data": s}, "data")
+}
+
+// withID copies an operation for a second method of the same handler
+func withID(o *openapi.Operation, id string) *openapi.Operation {
+	c := *o
+	c.OperationID = id
+	return &c
+}
+
+func (cfg *apiConfig) handlerOpenAPI(w http.ResponseWriter, r *http.Request) {
+	dat, err := json.Marshal(cfg.openAPI)
+	if err != nil {
+		respondWithErr(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
+
+// middlewareValidateRequest rejects requests that don't match the OpenAPI document and logs responses with a
+// status it doesn't list. It is only used with DEV_MODE=true, to catch the document drifting from the code.
+func (cfg *apiConfig) middlewareValidateRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := cfg.openAPI.ValidateRequest(r)
+		if err != nil {
+			respondWithValidationError(w, err)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if o, _, ok := cfg.openAPI.Find(r.Method, r.URL.Path); ok {
+			if _, documented := o.Responses[strconv.Itoa(rec.status)]; !documented {
+				log.Printf("OpenAPI: %s %s responded %d which is not documented", r.Method, r.URL.Path, rec.status)
+			}
+		} else if rec.status != http.StatusNotFound && rec.status != http.StatusMethodNotAllowed {
+			log.Printf("OpenAPI: %s %s is not documented", r.Method, r.URL.Path)
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// docsPage is the Swagger UI page, its assets are bundled with github.com/swaggo/files
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Chirpy API</title>
+    <link rel="stylesheet" type="text/css" href="docs/swagger-ui.css">
+    <link rel="icon" type="image/png" href="docs/favicon-32x32.png" sizes="32x32">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="docs/swagger-ui-bundle.js"></script>
+    <script>
+        window.ui = SwaggerUIBundle({
+            url: "openapi.json",
+            dom_id: "#swagger-ui",
+            deepLinking: true
+        });
+    </script>
+</body>
+</html>
+`
+
+func handlerDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(docsPage))
+}
+
+func handlerDocsAsset(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "file")
+
+	f, err := swaggerFiles.FS.Open(name)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "file does not exist")
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	content, ok := f.(io.ReadSeeker)
+	if err != nil || !ok {
+		respondWithError(w, http.StatusNotFound, "file does not exist")
+		return
+	}
+
+	http.ServeContent(w, r, name, stat.ModTime(), content)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func routes(t *testing.T) []string {
+	result := make([]string, 0)
+	err := chi.Walk(newRouter(&apiConfig{}), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		// The web app is static files, not part of the API
+		if strings.HasPrefix(route, "/app") {
+			return nil
+		}
+		result = append(result, method+" "+strings.TrimSuffix(route, "/"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	spec := apiSpec("http://localhost:8080")
+
+	documented := map[string]bool{}
+	for _, op := range spec.Operations() {
+		documented[op] = true
+	}
+
+	routed := map[string]bool{}
+	for _, route := range routes(t) {
+		routed[route] = true
+		if !documented[route] {
+			t.Errorf("%s has no entry in the OpenAPI document", route)
+		}
+	}
+
+	for op := range documented {
+		if !routed[op] {
+			t.Errorf("%s is documented but there is no such route", op)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	spec := apiSpec("http://localhost:8080")
+
+	ids := map[string]string{}
+	for path, item := range spec.Paths {
+		for method, op := range item {
+			if other, ok := ids[op.OperationID]; ok {
+				t.Errorf("%s %s: operation id %s is already used by %s", method, path, op.OperationID, other)
+			}
+			ids[op.OperationID] = method + " " + path
+
+			if len(op.Responses) == 0 {
+				t.Errorf("%s %s documents no responses", method, path)
+			}
+		}
+	}
+
+	dat, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every reference must point to a schema of the components
+	for _, ref := range strings.Split(string(dat), `"$ref":"#/components/schemas/`)[1:] {
+		name := ref[:strings.Index(ref, `"`)]
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Errorf("Schema %s is referenced but not defined", name)
+		}
+	}
+}
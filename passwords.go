@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"github.com/bobby-lin/chirpy/internal/utils"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// newPasswordsFromEnv configures password hashing from PASSWORD_HASH_ALGORITHM ("argon2id" by default or "bcrypt")
+// and the ARGON2_* / BCRYPT_COST parameters. Hashes made with the other algorithm are still accepted and upgraded on login.
+func newPasswordsFromEnv() (*security.Passwords, error) {
+	argon := security.NewArgon2idHasher()
+	bcryptHasher := security.NewBcryptHasher(bcrypt.DefaultCost)
+
+	for env, target := range map[string]*uint32{
+		"ARGON2_MEMORY_KIB": &argon.MemoryKiB,
+		"ARGON2_ITERATIONS": &argon.Iterations,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("invalid %s: %s", env, v)
+			}
+			*target = uint32(n)
+		}
+	}
+
+	if v := os.Getenv("ARGON2_PARALLELISM"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid ARGON2_PARALLELISM: %s", v)
+		}
+		argon.Parallelism = uint8(n)
+	}
+
+	if v := os.Getenv("BCRYPT_COST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < bcrypt.MinCost || n > bcrypt.MaxCost {
+			return nil, fmt.Errorf("invalid BCRYPT_COST: %s", v)
+		}
+		bcryptHasher.Cost = n
+	}
+
+	switch os.Getenv("PASSWORD_HASH_ALGORITHM") {
+	case "", "argon2id":
+		return security.NewPasswords(argon, bcryptHasher), nil
+	case "bcrypt":
+		return security.NewPasswords(bcryptHasher, argon), nil
+	default:
+		return nil, fmt.Errorf("unsupported PASSWORD_HASH_ALGORITHM: %s", os.Getenv("PASSWORD_HASH_ALGORITHM"))
+	}
+}
+
+// newPasswordPolicyFromEnv reads PASSWORD_MIN_LENGTH, PASSWORD_MAX_BYTES, PASSWORD_MIN_CHAR_CLASSES and
+// BREACHED_PASSWORDS_FILE on top of the default policy
+func newPasswordPolicyFromEnv() (*security.PasswordPolicy, error) {
+	policy := security.NewPasswordPolicy()
+
+	for env, target := range map[string]*int{
+		"PASSWORD_MIN_LENGTH":       &policy.MinLength,
+		"PASSWORD_MAX_BYTES":        &policy.MaxBytes,
+		"PASSWORD_MIN_CHAR_CLASSES": &policy.MinCharClasses,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s: %s", env, v)
+			}
+			*target = n
+		}
+	}
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := security.LoadBreachedPasswords(path)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+// validateCredentials reports every problem with the email and password at once
+func (cfg *apiConfig) validateCredentials(email, password string) error {
+	errs := &utils.ValidationError{}
+
+	err := utils.ValidateEmail(email)
+	if err != nil {
+		errs.Add("email", "invalid", err.Error())
+	}
+
+	errs.Merge("password", cfg.passwordPolicy.Validate(password, email))
+
+	return errs.Err()
+}
+
+// verifyPassword checks the user's password and transparently upgrades the stored hash when it is outdated
+func (cfg *apiConfig) verifyPassword(user database.User, password string) error {
+	needsRehash, err := cfg.passwords.Verify(password, user.Password)
+	if err != nil {
+		return err
+	}
+
+	if !needsRehash {
+		return nil
+	}
+
+	passwordHash, err := cfg.passwords.Hash(password)
+	if err != nil {
+		log.Printf("Error rehashing password: %s", err)
+		return nil
+	}
+
+	err = cfg.db.UpdatePasswordHash(user.ID, passwordHash)
+	if err != nil {
+		log.Printf("Error rehashing password: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"github.com/bobby-lin/chirpy/internal/utils"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Error codes clients can rely on. Statuses without a more specific code use the snake cased status text,
+// e.g. "not_found", see codeForStatus.
+const (
+	codeInvalidJSON        = "invalid_json"
+	codeValidationFailed   = "validation_failed"
+	codeInvalidCredentials = "invalid_credentials"
+	codeInvalidToken       = "invalid_token"
+	codeInsufficientScope  = "insufficient_scope"
+	codeAdminRequired      = "admin_required"
+	codeAccountSuspended   = "account_suspended"
+	codeRateLimited        = "rate_limited"
+	codeQuotaExceeded      = "quota_exceeded"
+	codeEmailTaken         = "email_taken"
+	codeAlreadyReported    = "already_reported"
+	codeReportClaimed      = "report_claimed"
+	codeReportResolved     = "report_resolved"
+	codeInvalidModAction   = "invalid_moderation_action"
+	codeInternal           = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details response. Code is stable and meant for clients to branch on while
+// Detail is meant for people and may change.
+type Problem struct {
+	Type   string             `json:"type"`
+	Title  string             `json:"title"`
+	Status int                `json:"status"`
+	Code   string             `json:"code"`
+	Detail string             `json:"detail,omitempty"`
+	Fields []utils.FieldError `json:"fields,omitempty"`
+	// Error repeats Detail for clients written against the {"error": ...} responses
+	Error string `json:"error"`
+}
+
+// apiError is an error that knows how it should be reported to clients
+type apiError struct {
+	status int
+	code   string
+	detail string
+	err    error
+}
+
+func newAPIError(status int, code, detail string) *apiError {
+	return &apiError{
+		status: status,
+		code:   code,
+		detail: detail,
+	}
+}
+
+func (e *apiError) Error() string {
+	if e.err != nil {
+		return e.detail + ": " + e.err.Error()
+	}
+	return e.detail
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+// sentinelErrors maps the errors of the database and security packages to how they are reported. Their
+// messages are written for clients.
+var sentinelErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{err: database.ErrEmailTaken, status: http.StatusConflict, code: codeEmailTaken},
+	{err: database.ErrAlreadyReported, status: http.StatusConflict, code: codeAlreadyReported},
+	{err: database.ErrReportClaimed, status: http.StatusConflict, code: codeReportClaimed},
+	{err: database.ErrReportResolved, status: http.StatusConflict, code: codeReportResolved},
+	{err: database.ErrInvalidModAction, status: http.StatusBadRequest, code: codeInvalidModAction},
+	{err: security.ErrPasswordMismatch, status: http.StatusUnauthorized, code: codeInvalidCredentials},
+	{err: errInsufficientScope, status: http.StatusForbidden, code: codeInsufficientScope},
+	{err: errNotAdmin, status: http.StatusForbidden, code: codeAdminRequired},
+	{err: errSuspended, status: http.StatusForbidden, code: codeAccountSuspended},
+}
+
+// databaseErrorKinds maps the kinds of database errors that aren't sentinels above. The message of the error
+// itself is reported, e.g. "chirp id 3 does not exist".
+var databaseErrorKinds = []struct {
+	err    error
+	status int
+}{
+	{err: database.ErrNotFound, status: http.StatusNotFound},
+	{err: database.ErrForbidden, status: http.StatusForbidden},
+	{err: database.ErrConflict, status: http.StatusConflict},
+	{err: database.ErrInvalid, status: http.StatusBadRequest},
+}
+
+// codeForStatus is the default code of a status, e.g. "not_found"
+func codeForStatus(status int) string {
+	if status == http.StatusInternalServerError {
+		return codeInternal
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+func writeProblem(w http.ResponseWriter, p Problem) {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	if p.Code == "" {
+		p.Code = codeForStatus(p.Status)
+	}
+	p.Error = p.Detail
+
+	dat, _ := json.Marshal(p)
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(dat)
+}
+
+func respondWithError(w http.ResponseWriter, code int, msg string) {
+	writeProblem(w, Problem{
+		Status: code,
+		Detail: msg,
+	})
+}
+
+// respondWithProblem is respondWithError with a more specific code than the one of the status
+func respondWithProblem(w http.ResponseWriter, status int, code, msg string) {
+	writeProblem(w, Problem{
+		Status: status,
+		Code:   code,
+		Detail: msg,
+	})
+}
+
+// respondWithErr reports an apiError, a validation error, a sentinel error or a database error with its own
+// status and code. Other errors, including database.ErrCorrupt, are internal: they are logged and clients only
+// get a generic message.
+func respondWithErr(w http.ResponseWriter, err error) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		if apiErr.status >= http.StatusInternalServerError {
+			log.Printf("Error handling request: %s", err)
+		}
+		respondWithProblem(w, apiErr.status, apiErr.code, apiErr.detail)
+		return
+	}
+
+	var validationErr *utils.ValidationError
+	if errors.As(err, &validationErr) {
+		respondWithValidationError(w, err)
+		return
+	}
+
+	for _, s := range sentinelErrors {
+		if errors.Is(err, s.err) {
+			respondWithProblem(w, s.status, s.code, s.err.Error())
+			return
+		}
+	}
+
+	for _, k := range databaseErrorKinds {
+		if errors.Is(err, k.err) {
+			respondWithError(w, k.status, err.Error())
+			return
+		}
+	}
+
+	log.Printf("Error handling request: %s", err)
+	respondWithProblem(w, http.StatusInternalServerError, codeInternal, "something went wrong")
+}
+
+// respondWithValidationError reports field-level errors from a utils.ValidationError
+func respondWithValidationError(w http.ResponseWriter, err error) {
+	p := Problem{
+		Status: http.StatusBadRequest,
+		Code:   codeValidationFailed,
+		Detail: "validation failed",
+	}
+
+	var validationErr *utils.ValidationError
+	if errors.As(err, &validationErr) {
+		p.Fields = validationErr.Fields
+	} else {
+		p.Detail = err.Error()
+	}
+
+	writeProblem(w, p)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"github.com/bobby-lin/chirpy/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type respondWithErrTest struct {
+	name           string
+	err            error
+	expectedStatus int
+	expectedCode   string
+	expectedDetail string
+}
+
+var respondWithErrTests = []respondWithErrTest{
+	{name: "email taken", err: database.ErrEmailTaken, expectedStatus: http.StatusConflict, expectedCode: codeEmailTaken, expectedDetail: database.ErrEmailTaken.Error()},
+	{name: "wrapped sentinel", err: fmt.Errorf("fail to resolve report: %w", database.ErrReportClaimed), expectedStatus: http.StatusConflict, expectedCode: codeReportClaimed, expectedDetail: database.ErrReportClaimed.Error()},
+	{name: "invalid moderation action", err: database.ErrInvalidModAction, expectedStatus: http.StatusBadRequest, expectedCode: codeInvalidModAction, expectedDetail: database.ErrInvalidModAction.Error()},
+	{name: "password mismatch", err: security.ErrPasswordMismatch, expectedStatus: http.StatusUnauthorized, expectedCode: codeInvalidCredentials, expectedDetail: security.ErrPasswordMismatch.Error()},
+	{name: "insufficient scope", err: errInsufficientScope, expectedStatus: http.StatusForbidden, expectedCode: codeInsufficientScope, expectedDetail: errInsufficientScope.Error()},
+	{name: "not admin", err: errNotAdmin, expectedStatus: http.StatusForbidden, expectedCode: codeAdminRequired, expectedDetail: errNotAdmin.Error()},
+	{name: "suspended", err: errSuspended, expectedStatus: http.StatusForbidden, expectedCode: codeAccountSuspended, expectedDetail: errSuspended.Error()},
+	// Database errors without a sentinel are reported with their own message and the code of the status
+	{name: "not found", err: fmt.Errorf("chirp id 3 does not exist: %w", database.ErrNotFound), expectedStatus: http.StatusNotFound, expectedCode: "not_found", expectedDetail: "chirp id 3 does not exist: " + database.ErrNotFound.Error()},
+	{name: "conflict", err: database.ErrConflict, expectedStatus: http.StatusConflict, expectedCode: "conflict", expectedDetail: database.ErrConflict.Error()},
+	{name: "api error", err: newAPIError(http.StatusTeapot, "teapot", "short and stout"), expectedStatus: http.StatusTeapot, expectedCode: "teapot", expectedDetail: "short and stout"},
+	// Anything else is internal and not reported to clients
+	{name: "corrupt", err: database.ErrCorrupt, expectedStatus: http.StatusInternalServerError, expectedCode: codeInternal, expectedDetail: "something went wrong"},
+	{name: "unknown", err: errors.New("disk is on fire"), expectedStatus: http.StatusInternalServerError, expectedCode: codeInternal, expectedDetail: "something went wrong"},
+}
+
+func TestRespondWithErr(t *testing.T) {
+	for _, test := range respondWithErrTests {
+		w := httptest.NewRecorder()
+		respondWithErr(w, test.err)
+
+		var p Problem
+		json.Unmarshal(w.Body.Bytes(), &p)
+
+		if w.Code != test.expectedStatus || p.Status != test.expectedStatus {
+			t.Errorf("%s: status %d not equal to expected %d", test.name, w.Code, test.expectedStatus)
+		}
+		if p.Code != test.expectedCode {
+			t.Errorf("%s: code %s not equal to expected %s", test.name, p.Code, test.expectedCode)
+		}
+		if p.Detail != test.expectedDetail {
+			t.Errorf("%s: detail %q not equal to expected %q", test.name, p.Detail, test.expectedDetail)
+		}
+		if w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s: content type %q not equal to expected application/problem+json", test.name, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	errs := &utils.ValidationError{}
+	errs.Add("body", "too_long", "Chirp is too long")
+
+	tests := []struct {
+		name     string
+		respond  func(w http.ResponseWriter)
+		expected map[string]interface{}
+	}{
+		{
+			name:    "error",
+			respond: func(w http.ResponseWriter) { respondWithError(w, http.StatusNotFound, "chirp does not exist") },
+			expected: map[string]interface{}{
+				"type": "about:blank", "title": "Not Found", "status": 404.0, "code": "not_found",
+				"detail": "chirp does not exist", "error": "chirp does not exist",
+			},
+		},
+		{
+			name: "problem",
+			respond: func(w http.ResponseWriter) {
+				respondWithProblem(w, http.StatusTooManyRequests, codeRateLimited, "too many requests")
+			},
+			expected: map[string]interface{}{
+				"type": "about:blank", "title": "Too Many Requests", "status": 429.0, "code": codeRateLimited,
+				"detail": "too many requests", "error": "too many requests",
+			},
+		},
+		{
+			name:    "validation",
+			respond: func(w http.ResponseWriter) { respondWithValidationError(w, errs) },
+			expected: map[string]interface{}{
+				"type": "about:blank", "title": "Bad Request", "status": 400.0, "code": codeValidationFailed,
+				"detail": "validation failed", "error": "validation failed",
+				"fields": []interface{}{map[string]interface{}{"field": "body", "code": "too_long", "message": "Chirp is too long"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		test.respond(w)
+
+		// Clients written against the old responses only read "error", it must keep the message
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if !reflect.DeepEqual(body, test.expected) {
+			t.Errorf("%s: output %v not equal to expected %v", test.name, body, test.expected)
+		}
+	}
+}
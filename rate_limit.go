@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route groups with their own rate limit, configured with RATE_LIMIT_<GROUP>, e.g. RATE_LIMIT_WRITE=60/1m,burst=10.
+// "off" disables the limit of a group.
+const (
+	rateLimitAuth  = "auth"
+	rateLimitWrite = "write"
+	rateLimitRead  = "read"
+	rateLimitAdmin = "admin"
+	// rateLimitWebhook covers incoming webhooks, which are limited by IP before their signature is checked
+	rateLimitWebhook = "webhook"
+)
+
+var defaultRateLimits = map[string]security.RateLimit{
+	rateLimitAuth:    {Requests: 10, Window: time.Minute, Burst: 10},
+	rateLimitWrite:   {Requests: 60, Window: time.Minute, Burst: 20},
+	rateLimitRead:    {Requests: 300, Window: time.Minute, Burst: 100},
+	rateLimitAdmin:   {Requests: 120, Window: time.Minute, Burst: 60},
+	rateLimitWebhook: {Requests: 120, Window: time.Minute, Burst: 60},
+}
+
+// rateLimitersFromEnv creates a limiter for every group, sharing the store. Disabled groups have none.
+func rateLimitersFromEnv(store security.BucketStore) (map[string]*security.RateLimiter, error) {
+	limiters := map[string]*security.RateLimiter{}
+
+	for group, limit := range defaultRateLimits {
+		env := "RATE_LIMIT_" + strings.ToUpper(group)
+		if v := os.Getenv(env); v != "" {
+			if v == "off" {
+				continue
+			}
+
+			var err error
+			limit, err = security.ParseRateLimit(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", env, err)
+			}
+		}
+
+		limiters[group] = security.NewRateLimiter(store, group+":", limit)
+	}
+
+	return limiters, nil
+}
+
+// rateLimitSubjectTTL is how long the user behind a personal access token is remembered for rate limiting.
+// Authentication still looks every token up, so a revoked token is never let through because of the cache.
+const rateLimitSubjectTTL = time.Minute
+
+type rateLimitSubject struct {
+	userID       int
+	entitlements security.Entitlements
+	expiresAt    time.Time
+}
+
+// rateLimitSubjects caches the users of personal access tokens by token hash, so rate limiting doesn't load the
+// database on every request
+type rateLimitSubjects struct {
+	mux       sync.Mutex
+	subjects  map[string]rateLimitSubject
+	lastSweep time.Time
+}
+
+func newRateLimitSubjects() *rateLimitSubjects {
+	return &rateLimitSubjects{
+		subjects: map[string]rateLimitSubject{},
+	}
+}
+
+func (c *rateLimitSubjects) get(tokenHash string, now time.Time) (rateLimitSubject, bool) {
+	if c == nil {
+		return rateLimitSubject{}, false
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	s, ok := c.subjects[tokenHash]
+	if !ok || now.After(s.expiresAt) {
+		return rateLimitSubject{}, false
+	}
+	return s, true
+}
+
+func (c *rateLimitSubjects) set(tokenHash string, s rateLimitSubject, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	s.expiresAt = now.Add(rateLimitSubjectTTL)
+	c.subjects[tokenHash] = s
+
+	if now.Sub(c.lastSweep) > rateLimitSubjectTTL {
+		for k, v := range c.subjects {
+			if now.After(v.expiresAt) {
+				delete(c.subjects, k)
+			}
+		}
+		c.lastSweep = now
+	}
+}
+
+// rateLimitKey limits authenticated users by account, wherever they connect from, and everyone else by IP.
+// Invalid tokens fall back to the IP so they can't be used to get a fresh bucket. Users get the limits of their
+// plan, anonymous requests those of the free plan.
+func (cfg *apiConfig) rateLimitKey(r *http.Request) (string, security.Entitlements) {
+	token := getBearerToken(r)
+	if token != "" {
+		if security.IsAPIToken(token) {
+			now := time.Now()
+			hash := security.HashToken(token)
+
+			s, ok := cfg.rateLimitSubjects.get(hash, now)
+			if !ok {
+				t, err := cfg.db.GetAPITokenByHash(hash)
+				if err == nil {
+					s = rateLimitSubject{userID: t.UserID, entitlements: cfg.entitlementsFor(r, t.UserID)}
+					cfg.rateLimitSubjects.set(hash, s, now)
+					ok = true
+				}
+			}
+			if ok {
+				return "user:" + strconv.Itoa(s.userID), s.entitlements
+			}
+		} else if _, userID, err := parseAccessToken(token); err == nil {
+			return "user:" + strconv.Itoa(userID), cfg.entitlementsFor(r, userID)
+		}
+	}
+
+	return "ip:" + cfg.clientIP(r), security.FreeEntitlements
+}
+
+func (cfg *apiConfig) middlewareRateLimit(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limiter, ok := cfg.rateLimiters[group]
+		if !ok {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, entitlements := cfg.rateLimitKey(r)
+			result := limiter.AllowScaled(key, entitlements.RateLimitMultiplier)
+
+			limit := limiter.Limit().Scale(entitlements.RateLimitMultiplier)
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", limit.Requests, int(limit.Window.Seconds()), limit.Burst))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+				respondWithProblem(w, http.StatusTooManyRequests, codeRateLimited, "too many requests")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
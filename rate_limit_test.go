@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/security"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.rateLimiters = map[string]*security.RateLimiter{
+		rateLimitRead: security.NewRateLimiter(security.NewMemoryBucketStore(), "read:", security.RateLimit{Requests: 60, Window: time.Minute, Burst: 2}),
+	}
+
+	walt := createTestUser(t, cfg, "walt@breakingbad.com", "Str0ngPassw0rd!")
+	jesse := createTestUser(t, cfg, "jesse@breakingbad.com", "Str0ngPassw0rd!")
+	cfg.db.UpdateChirpyRedStatus(jesse.ID, database.SubscriptionEvent{Type: database.SubscriptionStarted, At: time.Now().UTC()})
+	jesse, _ = cfg.db.GetUserByID(jesse.ID)
+
+	waltToken, _ := cfg.createAccessToken(walt, "", "")
+	jesseToken, _ := cfg.createAccessToken(jesse, "", "")
+	apiToken, _ := security.GenerateAPIToken()
+	pat, _ := cfg.db.CreateAPIToken(jesse.ID, "cli", security.HashToken(apiToken), []string{security.ScopeChirpsRead})
+
+	handler := cfg.middlewareRateLimit(rateLimitRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name     string
+		token    string
+		allowed  int
+		expected string
+	}{
+		{name: "anonymous", allowed: 2, expected: "60;w=60;burst=2"},
+		{name: "free", token: waltToken, allowed: 2, expected: "60;w=60;burst=2"},
+		{name: "chirpy red", token: jesseToken, allowed: 10, expected: "300;w=60;burst=10"},
+		// The token shares the bucket of its user, which the access token used up
+		{name: "chirpy red pat", token: apiToken, allowed: 0, expected: "300;w=60;burst=10"},
+	}
+
+	for _, test := range tests {
+		allowed := 0
+		policy := ""
+		for i := 0; i < 20; i++ {
+			r := httptest.NewRequest("GET", "/api/chirps", nil)
+			if test.token != "" {
+				r.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code == http.StatusOK {
+				allowed++
+			}
+			policy = w.Header().Get("RateLimit-Policy")
+		}
+
+		if allowed != test.allowed {
+			t.Errorf("%s: output %d allowed not equal to expected %d", test.name, allowed, test.allowed)
+		}
+		if policy != test.expected {
+			t.Errorf("%s: policy %q not equal to expected %q", test.name, policy, test.expected)
+		}
+	}
+
+	// The user of a personal access token is remembered instead of being looked up on every request
+	cfg.db.RevokeAPIToken(jesse.ID, pat.ID)
+	r := httptest.NewRequest("GET", "/api/chirps", nil)
+	r.Header.Set("Authorization", "Bearer "+apiToken)
+	if key, _ := cfg.rateLimitKey(r); key != "user:"+strconv.Itoa(jesse.ID) {
+		t.Errorf("Output %s not equal to expected cached user %d", key, jesse.ID)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/bobby-lin/chirpy/internal/database"
+	"github.com/bobby-lin/chirpy/internal/spam"
+	"github.com/bobby-lin/chirpy/internal/utils"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkSpam runs the spam detector on a new or edited chirp, chirpID is 0 for a new one. It responds and returns
+// false when the chirp is rejected or the author is throttled, and otherwise returns the review the chirp needs,
+// if any, along with profanity flags.
+func (cfg *apiConfig) checkSpam(w http.ResponseWriter, userID, chirpID int, content utils.Chirp) (*database.ChirpReview, bool) {
+	var review *database.ChirpReview
+	if len(content.Flags) > 0 {
+		review = &database.ChirpReview{
+			Reason:  database.ReportAutomatic,
+			Details: strings.Join(content.Flags, ", "),
+		}
+	}
+
+	// SPAM_DETECTION=off disables the detector
+	if cfg.spam == nil {
+		return review, true
+	}
+
+	now := time.Now().UTC()
+
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "fail to create chirp")
+		return nil, false
+	}
+
+	chirps, err := cfg.db.GetRecentChirps(userID, now.Add(-spam.DefaultConfig.DuplicateWindow))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "fail to create chirp")
+		return nil, false
+	}
+
+	recent := make([]spam.Post, 0, len(chirps))
+	for _, c := range chirps {
+		// An edit is not a duplicate of the chirp it replaces
+		if c.ID == chirpID {
+			continue
+		}
+		recent = append(recent, spam.Post{Body: c.Body, CreatedAt: c.CreatedAt})
+	}
+
+	verdict := cfg.spam.Check(spam.Input{
+		Body:             content.Body,
+		AccountCreatedAt: user.CreatedAt,
+		Recent:           recent,
+		Now:              now,
+	})
+
+	switch verdict.Action {
+	case spam.ActionThrottle:
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(verdict.RetryAfter.Seconds()))))
+		respondWithProblem(w, http.StatusTooManyRequests, codeRateLimited, "you are posting too fast")
+		return nil, false
+	case spam.ActionReject:
+		log.Printf("Rejected chirp by user %d as spam: %s", userID, strings.Join(verdict.Reasons, ", "))
+		errs := &utils.ValidationError{}
+		errs.Add("body", "spam", "Chirp looks like spam")
+		respondWithValidationError(w, errs)
+		return nil, false
+	case spam.ActionQueue:
+		details := strings.Join(verdict.Reasons, ", ")
+		if review != nil {
+			details += "; flagged by " + review.Details
+		}
+		return &database.ChirpReview{Reason: database.ReportSpam, Details: details, Hold: true}, true
+	}
+
+	return review, true
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// apiV1 is the API as it was before versioning, also served without a version in the path
+	apiV1 = "v1"
+	// apiV2 wraps every successful JSON response in a {"data": ...} envelope
+	apiV2 = "v2"
+	// usageUnversioned counts v1 requests made without a version in the path
+	usageUnversioned = "unversioned"
+)
+
+// apiLifecycle is when a version was deprecated and when it will stop being served. Dates that aren't set
+// are not announced.
+type apiLifecycle struct {
+	deprecation *time.Time
+	sunset      *time.Time
+}
+
+// apiLifecycleFromEnv reads the dates of a version from API_<VERSION>_DEPRECATION and API_<VERSION>_SUNSET,
+// e.g. API_V1_SUNSET=2027-04-18
+func apiLifecycleFromEnv(version string) (apiLifecycle, error) {
+	prefix := "API_" + strings.ToUpper(version)
+
+	deprecation, err := dateFromEnv(prefix + "_DEPRECATION")
+	if err != nil {
+		return apiLifecycle{}, err
+	}
+	sunset, err := dateFromEnv(prefix + "_SUNSET")
+	if err != nil {
+		return apiLifecycle{}, err
+	}
+
+	if deprecation != nil && sunset != nil && sunset.Before(*deprecation) {
+		return apiLifecycle{}, fmt.Errorf("%s_SUNSET is before %s_DEPRECATION", prefix, prefix)
+	}
+
+	return apiLifecycle{deprecation: deprecation, sunset: sunset}, nil
+}
+
+func dateFromEnv(env string) (*time.Time, error) {
+	v := os.Getenv(env)
+	if v == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", env, err)
+	}
+	return &t, nil
+}
+
+type contextKey string
+
+const apiVersionKey contextKey = "apiVersion"
+
+// apiVersion is the version of the API a request was routed to
+func apiVersion(r *http.Request) string {
+	version, ok := r.Context().Value(apiVersionKey).(string)
+	if !ok {
+		return apiV1
+	}
+	return version
+}
+
+// middlewareAPIVersion counts the requests of a version and makes it available to handlers with apiVersion.
+// v1 responses point to v2 and announce the configured deprecation with RFC 9745 and RFC 8594 headers.
+func (cfg *apiConfig) middlewareAPIVersion(version string, unversioned bool) func(http.Handler) http.Handler {
+	label := version
+	if unversioned {
+		label = usageUnversioned
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg.apiUsage.add(label)
+			r = r.WithContext(context.WithValue(r.Context(), apiVersionKey, version))
+
+			if version == apiV1 {
+				path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api"), "/"+apiV1)
+				if cfg.v1Lifecycle.deprecation != nil {
+					w.Header().Set("Deprecation", "@"+strconv.FormatInt(cfg.v1Lifecycle.deprecation.Unix(), 10))
+				}
+				if cfg.v1Lifecycle.sunset != nil {
+					w.Header().Set("Sunset", cfg.v1Lifecycle.sunset.Format(http.TimeFormat))
+				}
+				w.Header().Set("Link", "</api/"+apiV2+path+">; rel=\"successor-version\"")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ew := &envelopeWriter{ResponseWriter: w}
+			next.ServeHTTP(ew, r)
+			ew.flush()
+		})
+	}
+}
+
+// envelopeWriter wraps successful JSON responses in {"data": ...}. Problem details, files and pages are
+// written as they are.
+type envelopeWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buffer      *bytes.Buffer
+}
+
+func (w *envelopeWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.status = status
+	w.wroteHeader = true
+
+	contentType := w.Header().Get("Content-Type")
+	if status >= 200 && status < 300 && strings.HasPrefix(contentType, "application/json") {
+		w.buffer = &bytes.Buffer{}
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *envelopeWriter) Write(dat []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffer == nil {
+		return w.ResponseWriter.Write(dat)
+	}
+	return w.buffer.Write(dat)
+}
+
+func (w *envelopeWriter) flush() {
+	if w.buffer == nil {
+		return
+	}
+
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buffer.Len() == 0 {
+		return
+	}
+
+	dat, err := json.Marshal(map[string]json.RawMessage{"data": w.buffer.Bytes()})
+	if err != nil {
+		// The handler wrote invalid JSON, it is better sent as it is than not at all
+		w.ResponseWriter.Write(w.buffer.Bytes())
+		return
+	}
+	w.ResponseWriter.Write(dat)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *envelopeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// apiUsage counts requests per API version, to know when v1 can be turned off
+type apiUsage struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newAPIUsage() *apiUsage {
+	return &apiUsage{
+		counts: map[string]int{apiV1: 0, apiV2: 0, usageUnversioned: 0},
+	}
+}
+
+func (u *apiUsage) add(label string) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.counts[label]++
+}
+
+func (u *apiUsage) snapshot() map[string]int {
+	result := map[string]int{}
+	if u == nil {
+		return result
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for label, count := range u.counts {
+		result[label] = count
+	}
+	return result
+}
+
+func (cfg *apiConfig) handlerAPIUsage(w http.ResponseWriter, r *http.Request) {
+	_, err := cfg.authenticateAdmin(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	dat, _ := json.Marshal(cfg.apiUsage.snapshot())
+	w.Write(dat)
+}
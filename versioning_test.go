@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareAPIVersion(t *testing.T) {
+	deprecation := time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	sunset := deprecation.AddDate(0, 6, 0)
+	cfg := &apiConfig{apiUsage: newAPIUsage(), v1Lifecycle: apiLifecycle{deprecation: &deprecation, sunset: &sunset}}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("respond") {
+		case "problem":
+			respondWithError(w, http.StatusNotFound, "chirp does not exist")
+		case "text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("OK"))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"version":"` + apiVersion(r) + `"}`))
+		}
+	})
+
+	tests := []struct {
+		name        string
+		version     string
+		unversioned bool
+		target      string
+		status      int
+		expected    string
+		link        string
+	}{
+		{name: "v2 json", version: apiV2, target: "/api/v2/chirps", status: http.StatusCreated, expected: `{"data":{"version":"v2"}}`},
+		{name: "v2 problem", version: apiV2, target: "/api/v2/chirps?respond=problem", status: http.StatusNotFound},
+		{name: "v2 text", version: apiV2, target: "/api/v2/chirps?respond=text", status: http.StatusOK, expected: "OK"},
+		{name: "v1", version: apiV1, target: "/api/v1/chirps", status: http.StatusCreated, expected: `{"version":"v1"}`, link: `</api/v2/chirps>; rel="successor-version"`},
+		{name: "unversioned", version: apiV1, unversioned: true, target: "/api/chirps", status: http.StatusCreated, expected: `{"version":"v1"}`, link: `</api/v2/chirps>; rel="successor-version"`},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		cfg.middlewareAPIVersion(test.version, test.unversioned)(handler).ServeHTTP(w, httptest.NewRequest("GET", test.target, nil))
+
+		if w.Code != test.status {
+			t.Errorf("%s: status %d not equal to expected %d", test.name, w.Code, test.status)
+		}
+		if test.expected != "" && w.Body.String() != test.expected {
+			t.Errorf("%s: body %q not equal to expected %q", test.name, w.Body.String(), test.expected)
+		}
+		if test.status == http.StatusNotFound && w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s: problem details were changed to %q", test.name, w.Body.String())
+		}
+
+		if w.Header().Get("Link") != test.link {
+			t.Errorf("%s: Link %q not equal to expected %q", test.name, w.Header().Get("Link"), test.link)
+		}
+		if deprecated := w.Header().Get("Deprecation") == "@1792281600"; deprecated != (test.version == apiV1) {
+			t.Errorf("%s: Deprecation header %q", test.name, w.Header().Get("Deprecation"))
+		}
+		if sunsets := w.Header().Get("Sunset") == "Sun, 18 Apr 2027 00:00:00 GMT"; sunsets != (test.version == apiV1) {
+			t.Errorf("%s: Sunset header %q", test.name, w.Header().Get("Sunset"))
+		}
+	}
+
+	// Without configured dates v1 is not announced as deprecated
+	cfg.v1Lifecycle = apiLifecycle{}
+	w := httptest.NewRecorder()
+	cfg.middlewareAPIVersion(apiV1, false)(handler).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/chirps", nil))
+	if w.Header().Get("Deprecation") != "" || w.Header().Get("Sunset") != "" {
+		t.Errorf("Deprecation %q and Sunset %q should not be set", w.Header().Get("Deprecation"), w.Header().Get("Sunset"))
+	}
+
+	usage := cfg.apiUsage.snapshot()
+	if usage[apiV1] != 2 || usage[apiV2] != 3 || usage[usageUnversioned] != 1 {
+		t.Errorf("usage %v not counted per version", usage)
+	}
+}
+
+func TestAPILifecycleFromEnv(t *testing.T) {
+	tests := []struct {
+		name        string
+		deprecation string
+		sunset      string
+		expectErr   bool
+	}{
+		{name: "unset"},
+		{name: "deprecation only", deprecation: "2026-10-18"},
+		{name: "both", deprecation: "2026-10-18", sunset: "2027-04-18"},
+		{name: "invalid date", deprecation: "18/10/2026", expectErr: true},
+		{name: "sunset before deprecation", deprecation: "2026-10-18", sunset: "2026-01-01", expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Setenv("API_V1_DEPRECATION", test.deprecation)
+		t.Setenv("API_V1_SUNSET", test.sunset)
+
+		lifecycle, err := apiLifecycleFromEnv(apiV1)
+		if (err != nil) != test.expectErr {
+			t.Errorf("%s: error %v, expected error %v", test.name, err, test.expectErr)
+			continue
+		}
+		if test.expectErr {
+			continue
+		}
+
+		if (lifecycle.deprecation != nil) != (test.deprecation != "") || (lifecycle.sunset != nil) != (test.sunset != "") {
+			t.Errorf("%s: output %+v not equal to expected %q and %q", test.name, lifecycle, test.deprecation, test.sunset)
+		}
+	}
+}